@@ -0,0 +1,190 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Errorf("hashPassword = %q, want a pbkdf2-sha256 hash with 600000 iterations", hash)
+	}
+	if !checkPassword(hash, "correct horse") {
+		t.Error("checkPassword rejected the right password")
+	}
+	if checkPassword(hash, "correct horse ") {
+		t.Error("checkPassword accepted a wrong password")
+	}
+
+	other, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if other == hash {
+		t.Error("hashPassword returned the same hash twice; the salt is not random")
+	}
+}
+
+func TestCheckPasswordMalformed(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"secret",
+		"bcrypt$10$abc$def",
+		"pbkdf2-sha256$0$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$x$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$1000$not base64$aGFzaA",
+		"pbkdf2-sha256$1000$c2FsdA$not base64",
+		"pbkdf2-sha256$1000$c2FsdA",
+	} {
+		if checkPassword(encoded, "secret") {
+			t.Errorf("checkPassword(%q) accepted a malformed hash", encoded)
+		}
+	}
+}
+
+// newTestAuthStore opens an auth store in a temporary directory with one
+// local user, "alice", whose password is "alice-password".
+func newTestAuthStore(t *testing.T, cfg AuthConfig) *AuthStore {
+	t.Helper()
+	store, err := openAuthStore(filepath.Join(t.TempDir(), "auth.json"), cfg)
+	if err != nil {
+		t.Fatalf("openAuthStore: %v", err)
+	}
+	if _, err := store.CreateUser("alice", "alice-password", RoleUser); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return store
+}
+
+func TestSessionIdentity(t *testing.T) {
+	store := newTestAuthStore(t, AuthConfig{})
+
+	if _, _, err := store.Login("alice", "wrong-password"); err != errInvalidCredentials {
+		t.Errorf("Login with a wrong password: err = %v, want %v", err, errInvalidCredentials)
+	}
+	if _, _, err := store.Login("bob", "alice-password"); err != errInvalidCredentials {
+		t.Errorf("Login of an unknown user: err = %v, want %v", err, errInvalidCredentials)
+	}
+
+	token, _, err := store.Login("alice", "alice-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	identity, ok := store.SessionIdentity(token)
+	if !ok {
+		t.Fatal("SessionIdentity did not find a new session")
+	}
+	if identity.Username != "alice" || identity.Role != RoleUser || identity.Method != "session" {
+		t.Errorf("SessionIdentity = %+v, want alice with role user by session", identity)
+	}
+	if _, ok := store.SessionIdentity(token + "x"); ok {
+		t.Error("SessionIdentity accepted an unknown token")
+	}
+
+	store.Logout(token)
+	if _, ok := store.SessionIdentity(token); ok {
+		t.Error("SessionIdentity accepted a token after logout")
+	}
+}
+
+func TestSessionIdentityExpired(t *testing.T) {
+	store := newTestAuthStore(t, AuthConfig{})
+	token, _, err := store.Login("alice", "alice-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	store.sessions[token].expires = time.Now().Add(-time.Second)
+	if _, ok := store.SessionIdentity(token); ok {
+		t.Error("SessionIdentity accepted an expired session")
+	}
+	if _, ok := store.sessions[token]; ok {
+		t.Error("SessionIdentity kept an expired session")
+	}
+}
+
+func TestSessionIdentityDeletedUser(t *testing.T) {
+	store := newTestAuthStore(t, AuthConfig{})
+	token, _, err := store.Login("alice", "alice-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if err := store.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, ok := store.SessionIdentity(token); ok {
+		t.Error("SessionIdentity accepted the session of a deleted user")
+	}
+}
+
+func TestAPIKeyIdentity(t *testing.T) {
+	store := newTestAuthStore(t, AuthConfig{})
+
+	if _, _, err := store.IssueKey("bob", "ci"); err != errUserNotFound {
+		t.Errorf("IssueKey for an unknown user: err = %v, want %v", err, errUserNotFound)
+	}
+	key, info, err := store.IssueKey("alice", "ci")
+	if err != nil {
+		t.Fatalf("IssueKey: %v", err)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) || !strings.HasPrefix(key, info.Prefix) {
+		t.Errorf("IssueKey = %q with prefix %q, want a key starting with %q and its prefix", key, info.Prefix, apiKeyPrefix)
+	}
+
+	identity, ok := store.APIKeyIdentity(key)
+	if !ok {
+		t.Fatal("APIKeyIdentity did not find a new key")
+	}
+	if identity.Username != "alice" || identity.Role != RoleUser || identity.Method != "apikey" || identity.KeyID != info.ID {
+		t.Errorf("APIKeyIdentity = %+v, want alice with role user by API key %s", identity, info.ID)
+	}
+	if _, ok := store.APIKeyIdentity(key[:len(key)-1]); ok {
+		t.Error("APIKeyIdentity accepted a truncated key")
+	}
+
+	// The key survives a restart since only its hash is stored.
+	reopened, err := openAuthStore(store.path, AuthConfig{})
+	if err != nil {
+		t.Fatalf("openAuthStore: %v", err)
+	}
+	if _, ok := reopened.APIKeyIdentity(key); !ok {
+		t.Error("APIKeyIdentity did not find the key after reopening the store")
+	}
+
+	if _, err := store.RevokeKey(info.ID); err != nil {
+		t.Fatalf("RevokeKey: %v", err)
+	}
+	if _, ok := store.APIKeyIdentity(key); ok {
+		t.Error("APIKeyIdentity accepted a revoked key")
+	}
+}
+
+func TestConfigUsers(t *testing.T) {
+	hash, err := hashPassword("carol-password")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	store := newTestAuthStore(t, AuthConfig{Users: []UserConfig{{Username: "carol", PasswordHash: hash, Role: RoleModelAdmin}}})
+	token, u, err := store.Login("carol", "carol-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if u.Role != RoleModelAdmin {
+		t.Errorf("Login role = %q, want %q", u.Role, RoleModelAdmin)
+	}
+	if identity, ok := store.SessionIdentity(token); !ok || identity.Role != RoleModelAdmin {
+		t.Errorf("SessionIdentity = %+v, %t, want carol with role %s", identity, ok, RoleModelAdmin)
+	}
+
+	if _, err := openAuthStore(filepath.Join(t.TempDir(), "auth.json"), AuthConfig{Users: []UserConfig{{Username: "dave", PasswordHash: hash, Role: "root"}}}); err == nil {
+		t.Error("openAuthStore accepted a config user with an unknown role")
+	}
+	if _, err := openAuthStore(filepath.Join(t.TempDir(), "auth.json"), AuthConfig{AnonymousRole: "root"}); err == nil {
+		t.Error("openAuthStore accepted an unknown anonymous role")
+	}
+}
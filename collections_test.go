@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"empty", " \n\n ", 100, 10, nil},
+		{"paragraphs that fit share a chunk", "one\n\ntwo\n\n\n\nthree", 100, 10, []string{"one\n\ntwo\n\nthree"}},
+		{"windows line endings", "one\r\n\r\ntwo", 100, 10, []string{"one\n\ntwo"}},
+		{"full chunk is flushed", "aaaaaa\n\nbbbbbb\n\ncc", 10, 2, []string{"aaaaaa", "bbbbbb\n\ncc"}},
+		{"heading starts a chunk", "intro\n\n# Setup\n\nsteps", 100, 10, []string{"intro", "# Setup\n\nsteps"}},
+		{
+			name:    "long paragraph overlaps",
+			text:    "abcdefghijklmnopqrstuvwxy",
+			size:    10,
+			overlap: 3,
+			want:    []string{"abcdefghij", "hijklmnopq", "opqrstuvwx", "vwxy"},
+		},
+		{
+			name:    "heading stays with a long paragraph",
+			text:    "# Title\n\nabcdefghijkl",
+			size:    8,
+			overlap: 2,
+			want:    []string{"# Title\n\nabcdefgh", "ghijkl"},
+		},
+		{
+			name:    "long paragraph is split by characters",
+			text:    "äöüäöüäö",
+			size:    4,
+			overlap: 1,
+			want:    []string{"äöüä", "äöüä", "äö"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkText(tt.text, tt.size, tt.overlap); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testPDF builds a minimal PDF with one object per stream. Streams whose dict
+// contains "/Filter /FlateDecode" are compressed; other spellings are not.
+func testPDF(t *testing.T, streams ...[2]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, s := range streams {
+		dict, content := s[0], []byte(s[1])
+		if strings.Contains(dict, "/Filter /FlateDecode") {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			if _, err := zw.Write(content); err != nil {
+				t.Fatal(err)
+			}
+			zw.Close()
+			content = z.Bytes()
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length %d %s >>\nstream\r\n", i+1, len(content), dict)
+		b.Write(content)
+		b.WriteString("\nendstream\nendobj\n")
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestExtractPDFText(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams [][2]string
+		want    string
+	}{
+		{"literal string", [][2]string{{"", "BT /F1 12 Tf 72 712 Td (Hello World) Tj ET"}}, "Hello World"},
+		{"escapes", [][2]string{{"", `BT (a \(b\) c\\d) Tj ET`}}, `a (b) c\d`},
+		{"hex string", [][2]string{{"", "BT <48656C6C6F> Tj ET"}}, "Hello"},
+		{"TJ spacing", [][2]string{{"", "BT [(Hel) -20 (lo) -400 (World)] TJ ET"}}, "Hello World"},
+		{"line moves", [][2]string{{"", "BT (One) Tj 0 -14 Td (Two) Tj T* (Three) Tj ET"}}, "One\nTwo\nThree"},
+		{"text outside BT is ignored", [][2]string{{"", "(Hidden) Tj BT (Shown) Tj ET"}}, "Shown"},
+		{"flate stream", [][2]string{{"/Filter /FlateDecode", "BT (Compressed text) Tj ET"}}, "Compressed text"},
+		{
+			name:    "image and font streams are skipped",
+			streams: [][2]string{{"/Subtype /Image", "BT (Image) Tj ET"}, {"/Length1 10", "BT (Font) Tj ET"}, {"", "BT (Page) Tj ET"}},
+			want:    "Page",
+		},
+		{"several pages", [][2]string{{"", "BT (Page one) Tj ET"}, {"/Filter /FlateDecode", "BT (Page two) Tj ET"}}, "Page one\n\nPage two"},
+		{"corrupt flate stream", [][2]string{{"/Filter/FlateDecode", "BT (Garbage) Tj ET"}, {"", "BT (Kept) Tj ET"}}, "Kept"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.TrimSpace(extractPDFText(testPDF(t, tt.streams...))); got != tt.want {
+				t.Errorf("extractPDFText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFTextBoundsInflation(t *testing.T) {
+	// The stream inflates to more than maxExtractedText; what lies beyond is not read.
+	var content bytes.Buffer
+	content.WriteString("BT (Before) Tj ET\n")
+	content.Write(make([]byte, maxExtractedText))
+	content.WriteString("BT (After) Tj ET\n")
+	data := testPDF(t, [2]string{"/Filter /FlateDecode", content.String()})
+	if len(data) > maxDocumentSize {
+		t.Fatalf("test PDF is %d bytes, want it below maxDocumentSize", len(data))
+	}
+
+	got := extractPDFText(data)
+	if !strings.Contains(got, "Before") || strings.Contains(got, "After") {
+		t.Errorf("extractPDFText = %q, want the text before the cap only", got)
+	}
+}
+
+func TestExtractPDFTextManyStreamKeywords(t *testing.T) {
+	// Every keyword without an object header used to search back to the start of the file.
+	data := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("xstream\n"), 50000)...)
+	data = append(data, testPDF(t, [2]string{"", "BT (Last) Tj ET"})...)
+	start := time.Now()
+	got := strings.TrimSpace(extractPDFText(data))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("extractPDFText took %v", elapsed)
+	}
+	if got != "Last" {
+		t.Errorf("extractPDFText = %q, want %q", got, "Last")
+	}
+}
+
+func TestExtractDocumentText(t *testing.T) {
+	if kind, text, err := extractDocumentText("notes.MD", []byte("# Notes\xff")); err != nil || kind != "markdown" || text != "# Notes" {
+		t.Errorf("extractDocumentText(notes.MD) = %q, %q, %v; want markdown with invalid UTF-8 dropped", kind, text, err)
+	}
+	if kind, _, err := extractDocumentText("README", []byte("text")); err != nil || kind != "text" {
+		t.Errorf("extractDocumentText(README) = %q, %v; want text", kind, err)
+	}
+	if _, _, err := extractDocumentText("scan.pdf", testPDF(t, [2]string{"/Subtype /Image", "binary"})); err == nil {
+		t.Error("extractDocumentText accepted a PDF without text")
+	}
+	if _, _, err := extractDocumentText("slides.pptx", []byte("PK")); err == nil {
+		t.Error("extractDocumentText accepted an unsupported file type")
+	}
+}
+
+func TestInjectRetrievalContext(t *testing.T) {
+	citations := []Citation{{Text: "Ollamana proxies Ollama."}}
+	messages := []Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "What is it?"}}
+	got := injectRetrievalContext(messages, citations)
+	if len(got) != 3 || !reflect.DeepEqual(got[0], messages[0]) || got[1].Role != "system" || !reflect.DeepEqual(got[2], messages[1]) {
+		t.Errorf("injectRetrievalContext = %+v, want the context right before the question", got)
+	}
+	if !strings.Contains(got[1].Content, "Ollamana proxies Ollama.") {
+		t.Errorf("retrieval context %q does not contain the citation", got[1].Content)
+	}
+
+	if got := injectRetrievalContext(nil, citations); len(got) != 1 || got[0].Role != "system" {
+		t.Errorf("injectRetrievalContext(nil) = %+v, want only the context message", got)
+	}
+}
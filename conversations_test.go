@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImages returns n placeholder images; stored images are not decoded again.
+func testImages(turn, n int) []string {
+	images := make([]string, n)
+	for i := range images {
+		images[i] = fmt.Sprintf("turn%d-image%d", turn, i)
+	}
+	return images
+}
+
+func TestChatReplaysOnlyRecentImages(t *testing.T) {
+	setUpGeneration(t)
+	var err error
+	if conversations, err = openConversationStore(t.TempDir()); err != nil {
+		t.Fatalf("openConversationStore: %v", err)
+	}
+	conv, err := conversations.Create(ConversationRequest{Model: "llava"}, "alice")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// Three earlier turns with four images each: together with the new turn's
+	// two images only the last two of them fit within maxImagesPerRequest.
+	for turn := 1; turn <= 3; turn++ {
+		err := conversations.Append(conv.ID,
+			ConversationMessage{Role: "user", Content: fmt.Sprintf("Picture %d?", turn), Images: testImages(turn, 4), CreatedAt: time.Now()},
+			ConversationMessage{Role: "assistant", Content: "A cat.", CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	var sent OllamaChatRequestPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Errorf("decoding chat request: %v", err)
+		}
+		w.Write([]byte(`{"model":"llava","message":{"role":"assistant","content":"Two cats."},"done":true}` + "\n"))
+	}))
+	defer srv.Close()
+	backend, err := newBackend(BackendConfig{Name: "test", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("newBackend: %v", err)
+	}
+
+	clientReq := ClientRequest{
+		ActionType:     "chat",
+		Model:          "llava",
+		ConversationID: conv.ID,
+		Messages:       []Message{{Role: "user", Content: "And these?", Images: testImages(4, 2)}},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/ollama", nil)
+	r = r.WithContext(context.WithValue(r.Context(), identityKey{}, &Identity{Username: "alice", Role: RoleUser}))
+	w := httptest.NewRecorder()
+	callChatAPI(newSSEOutput(w, r, clientReq), r, clientReq, backend, backend.Client(0))
+	if strings.Contains(w.Body.String(), "event: error") || w.Code != http.StatusOK {
+		t.Fatalf("chat failed with status %d: %s", w.Code, w.Body)
+	}
+
+	var counts []int
+	for _, msg := range sent.Messages {
+		if msg.Role == "user" {
+			counts = append(counts, len(msg.Images))
+		}
+	}
+	if fmt.Sprint(counts) != "[0 4 4 2]" {
+		t.Errorf("images per user turn = %v, want [0 4 4 2]", counts)
+	}
+
+	// The stored conversation keeps every image.
+	stored, err := conversations.Get(conv.ID, "alice")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(stored.Messages) != 8 || len(stored.Messages[0].Images) != 4 || len(stored.Messages[6].Images) != 2 {
+		t.Errorf("stored conversation has %d messages, want all turns with their images", len(stored.Messages))
+	}
+}
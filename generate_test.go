@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// longContext returns a generate context whose JSON encoding is well beyond 64 KB.
+func longContext() []int {
+	tokens := make([]int, 50000)
+	for i := range tokens {
+		tokens[i] = 100000 + i
+	}
+	return tokens
+}
+
+// setUpGeneration sets up the globals a generation records its usage and metrics in.
+func setUpGeneration(t *testing.T) {
+	t.Helper()
+	var err error
+	if usageLog, err = openUsageLog(filepath.Join(t.TempDir(), "usage.json")); err != nil {
+		t.Fatalf("openUsageLog: %v", err)
+	}
+	if catalog, err = newModelCatalog(CatalogConfig{}, ""); err != nil {
+		t.Fatalf("newModelCatalog: %v", err)
+	}
+}
+
+func TestCallGenerateAPILongFinalChunk(t *testing.T) {
+	setUpGeneration(t)
+	final, err := json.Marshal(OllamaResponseChunk{Model: "llama3", Done: true, Context: longContext(), EvalCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(final) <= 64<<10 {
+		t.Fatalf("final chunk is %d bytes, want more than 64 KB", len(final))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s\n%s\n", `{"model":"llama3","response":"Hello","done":false}`, final)
+	}))
+	defer srv.Close()
+	backend, err := newBackend(BackendConfig{Name: "test", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("newBackend: %v", err)
+	}
+
+	clientReq := ClientRequest{ActionType: "generate", Model: "llama3", Prompt: "Hi"}
+	r := httptest.NewRequest(http.MethodPost, "/api/ollama", nil)
+	w := httptest.NewRecorder()
+	callGenerateAPI(newSSEOutput(w, r, clientReq), r, clientReq, backend, backend.Client(0))
+
+	body := w.Body.String()
+	if strings.Contains(body, "event: error") || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("stream did not end cleanly, it ends with %q", body[max(0, len(body)-200):])
+	}
+	if !strings.Contains(body, "data: "+string(final)+"\n\n") {
+		t.Error("the final chunk with the context was not forwarded")
+	}
+}
+
+func TestRelayChatStreamLongLine(t *testing.T) {
+	setUpGeneration(t)
+	content := strings.Repeat("x", 100<<10)
+	line, err := json.Marshal(OllamaResponseChunk{Model: "llama3", Message: &Message{Role: "assistant", Content: content}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := string(line) + "\n" + `{"model":"llama3","message":{"role":"assistant","content":""},"done":true}` + "\n"
+
+	clientReq := ClientRequest{ActionType: "chat", Model: "llama3"}
+	r := httptest.NewRequest(http.MethodPost, "/api/ollama", nil)
+	out := newSSEOutput(httptest.NewRecorder(), r, clientReq)
+	out.start()
+	round, err := relayChatStream(out, strings.NewReader(stream), trackStream("chat", "llama3"))
+	if err != nil {
+		t.Fatalf("relayChatStream: %v", err)
+	}
+	if round.content != content || round.usage == nil {
+		t.Errorf("relayChatStream returned %d bytes of content and usage %v, want %d bytes and the final usage", len(round.content), round.usage, len(content))
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestJobBackend serves /api/pull for job tests: pulls of "slow" models run
+// until they are cancelled, all others fail at once.
+func newTestJobBackend(t *testing.T) *Backend {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaPullRequestPayload
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "slow" {
+			http.Error(w, "pull failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	backend, err := newBackend(BackendConfig{Name: "test", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("newBackend: %v", err)
+	}
+	return backend
+}
+
+// waitForJob polls until the job has finished.
+func waitForJob(t *testing.T, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := jobs.Get(id); ok && job.finished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+// jobRequest calls a job handler as the given user.
+func jobRequest(handler http.HandlerFunc, id string, identity *Identity) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/jobs/"+id, nil)
+	r.SetPathValue("id", id)
+	r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestJobCancelAndResumeAuthorization(t *testing.T) {
+	authStore = &AuthStore{enabled: true, policies: map[string]RolePolicy{RoleModelAdmin: {DenyModels: []string{"slow"}}}}
+	jobs = newJobManager(2)
+	backend := newTestJobBackend(t)
+
+	alice := &Identity{Username: "alice", Role: RoleAdmin}
+	bob := &Identity{Username: "bob", Role: RoleUser}           // May not pull
+	carol := &Identity{Username: "carol", Role: RoleModelAdmin} // May pull, but not the "slow" model
+
+	job := jobs.Submit(backend, "pull", "slow", "alice")
+	if w := jobRequest(handleCancelJob, job.ID, bob); w.Code != http.StatusNotFound {
+		t.Errorf("cancel by a user who cannot see the job: status %d, want 404", w.Code)
+	}
+	if w := jobRequest(handleCancelJob, job.ID, carol); w.Code != http.StatusNotFound {
+		t.Errorf("cancel by a user whose policy denies the model: status %d, want 404", w.Code)
+	}
+	if w := jobRequest(handleCancelJob, "missing", alice); w.Code != http.StatusNotFound {
+		t.Errorf("cancel of an unknown job: status %d, want 404", w.Code)
+	}
+	if w := jobRequest(handleCancelJob, job.ID, alice); w.Code != http.StatusOK {
+		t.Fatalf("cancel by the owner: status %d, want 200: %s", w.Code, w.Body)
+	}
+	if state := waitForJob(t, job.ID).State; state != JobCancelled {
+		t.Fatalf("job state = %s, want %s", state, JobCancelled)
+	}
+	if w := jobRequest(handleCancelJob, job.ID, alice); w.Code != http.StatusConflict {
+		t.Errorf("cancel of a cancelled job: status %d, want 409", w.Code)
+	}
+
+	if w := jobRequest(handleResumeJob, job.ID, bob); w.Code != http.StatusNotFound {
+		t.Errorf("resume by a user who cannot see the job: status %d, want 404", w.Code)
+	}
+	if w := jobRequest(handleResumeJob, job.ID, alice); w.Code != http.StatusOK {
+		t.Fatalf("resume by the owner: status %d, want 200: %s", w.Code, w.Body)
+	}
+	if w := jobRequest(handleCancelJob, job.ID, alice); w.Code != http.StatusOK {
+		t.Errorf("cancel of the resumed job: status %d, want 200", w.Code)
+	}
+	waitForJob(t, job.ID)
+
+	// Owning a job does not replace the permission to run it, e.g. after the
+	// owner's role or its model policy changed.
+	denied := jobs.Submit(backend, "pull", "slow", "carol")
+	if w := jobRequest(handleCancelJob, denied.ID, carol); w.Code != http.StatusForbidden {
+		t.Errorf("cancel by an owner whose policy denies the model: status %d, want 403", w.Code)
+	}
+	if w := jobRequest(handleCancelJob, denied.ID, alice); w.Code != http.StatusOK {
+		t.Errorf("cancel by an admin: status %d, want 200", w.Code)
+	}
+	waitForJob(t, denied.ID)
+
+	own := jobs.Submit(backend, "pull", "broken", "bob")
+	if state := waitForJob(t, own.ID).State; state != JobFailed {
+		t.Fatalf("job state = %s, want %s", state, JobFailed)
+	}
+	if w := jobRequest(handleResumeJob, own.ID, bob); w.Code != http.StatusForbidden {
+		t.Errorf("resume by an owner without the pull permission: status %d, want 403", w.Code)
+	}
+	if w := jobRequest(handleResumeJob, own.ID, alice); w.Code != http.StatusOK {
+		t.Errorf("resume by an admin: status %d, want 200", w.Code)
+	}
+	waitForJob(t, own.ID)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeTestValue decodes a JSON instance the way parseStructuredAnswer does.
+func decodeTestValue(t *testing.T, text string) any {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		t.Fatalf("decoding %s: %v", text, err)
+	}
+	return value
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []string // Paths of the expected errors, "" for the root
+	}{
+		{"type ok", `{"type": "string"}`, `"x"`, nil},
+		{"type mismatch", `{"type": "string"}`, `1`, []string{""}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"integer is a number", `{"type": "number"}`, `3`, nil},
+		{"whole float is an integer", `{"type": "integer"}`, `2.0`, nil},
+		{"fraction is not an integer", `{"type": "integer"}`, `2.5`, []string{""}},
+		{"enum", `{"enum": ["red", "green"]}`, `"blue"`, []string{""}},
+		{"enum compares numbers by value", `{"enum": [1, 2]}`, `2.0`, nil},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{
+			name:   "required and additionalProperties",
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name", "age"], "additionalProperties": false}`,
+			value:  `{"name": "x", "extra": true}`,
+			want:   []string{"", ""},
+		},
+		{
+			name:   "additionalProperties schema",
+			schema: `{"type": "object", "additionalProperties": {"type": "integer"}}`,
+			value:  `{"a": 1, "b": "two"}`,
+			want:   []string{"/b"},
+		},
+		{
+			name:   "nested path with escapes",
+			schema: `{"properties": {"a/b": {"properties": {"c~d": {"type": "string"}}}}}`,
+			value:  `{"a/b": {"c~d": 1}}`,
+			want:   []string{"/a~1b/c~0d"},
+		},
+		{
+			name:   "items and array bounds",
+			schema: `{"type": "array", "items": {"type": "integer"}, "minItems": 4, "uniqueItems": true}`,
+			value:  `[1, "x", 1]`,
+			want:   []string{"", "/1", ""},
+		},
+		{
+			name:   "prefixItems",
+			schema: `{"prefixItems": [{"type": "string"}, {"type": "integer"}], "items": false}`,
+			value:  `["a", 1, null]`,
+			want:   []string{"/2"},
+		},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{""}},
+		{"minLength counts characters", `{"minLength": 3}`, `"äöü"`, nil},
+		{"maxLength", `{"maxLength": 2}`, `"abc"`, []string{""}},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc1"`, []string{""}},
+		{"invalid pattern", `{"pattern": "("}`, `"abc"`, []string{""}},
+		{"pattern ignores other types", `{"pattern": "^a$"}`, `1`, nil},
+		{"minimum", `{"minimum": 1, "maximum": 3}`, `0`, []string{""}},
+		{"exclusive bounds", `{"exclusiveMinimum": 1, "exclusiveMaximum": 3}`, `3`, []string{""}},
+		{"multipleOf", `{"multipleOf": 0.1}`, `0.3`, nil},
+		{"not a multiple", `{"multipleOf": 2}`, `3`, []string{""}},
+		{"allOf", `{"allOf": [{"type": "integer"}, {"minimum": 5}]}`, `3`, []string{""}},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, []string{""}},
+		{"anyOf match", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `7`, nil},
+		{"oneOf matching both", `{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`, `7`, []string{""}},
+		{"oneOf matching one", `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, `7`, nil},
+		{"not", `{"not": {"type": "null"}}`, `null`, []string{""}},
+		{
+			name:   "local ref",
+			schema: `{"$defs": {"point": {"type": "object", "required": ["x"]}}, "type": "array", "items": {"$ref": "#/$defs/point"}}`,
+			value:  `[{"x": 1}, {}]`,
+			want:   []string{"/1"},
+		},
+		{"remote ref", `{"$ref": "https://example.com/schema.json"}`, `1`, []string{""}},
+		{"dangling ref", `{"$ref": "#/$defs/missing"}`, `1`, []string{""}},
+		{"false schema", `{"properties": {"a": false}}`, `{"a": 1}`, []string{"/a"}},
+		{"true schema", `{"properties": {"a": true}}`, `{"a": 1}`, nil},
+		{
+			name:   "recursive ref",
+			schema: `{"type": "object", "properties": {"child": {"$ref": "#"}, "name": {"type": "string"}}}`,
+			value:  `{"child": {"child": {"name": 1}}}`,
+			want:   []string{"/child/child/name"},
+		},
+		{"unknown keywords are ignored", `{"format": "email", "title": "x"}`, `"not an email"`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := decodeJSONSchema(json.RawMessage(tt.schema))
+			if err != nil {
+				t.Fatalf("decodeJSONSchema: %v", err)
+			}
+			errs := validateJSONSchema(schema, decodeTestValue(t, tt.value))
+			var paths []string
+			for _, e := range errs {
+				paths = append(paths, e.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("validateJSONSchema error paths = %q, want %q (errors: %+v)", paths, tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONSchema(t *testing.T) {
+	for _, raw := range []string{``, `  `, `true`, `[]`, `{"type":`} {
+		if _, err := decodeJSONSchema(json.RawMessage(raw)); err == nil {
+			t.Errorf("decodeJSONSchema(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestValidateJSONSchemaRefCycle(t *testing.T) {
+	schema, err := decodeJSONSchema(json.RawMessage(`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`))
+	if err != nil {
+		t.Fatalf("decodeJSONSchema: %v", err)
+	}
+	errs := validateJSONSchema(schema, "x")
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "$ref cycle") {
+		t.Errorf("validateJSONSchema = %+v, want one $ref cycle error", errs)
+	}
+}
+
+func TestValidateJSONSchemaStepBudget(t *testing.T) {
+	// Each level doubles the work, so without a budget this would not finish.
+	schema, err := decodeJSONSchema(json.RawMessage(`{"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}`))
+	if err != nil {
+		t.Fatalf("decodeJSONSchema: %v", err)
+	}
+	start := time.Now()
+	errs := validateJSONSchema(schema, "x")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("validateJSONSchema took %v", elapsed)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "too complex") {
+		t.Errorf("validateJSONSchema = %+v, want one error saying the schema is too complex", errs)
+	}
+
+	// The budget is per validation, so a simple schema still validates afterwards.
+	simple, err := decodeJSONSchema(json.RawMessage(`{"type": "string"}`))
+	if err != nil {
+		t.Fatalf("decodeJSONSchema: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if errs := validateJSONSchema(simple, "x"); len(errs) != 0 {
+			t.Errorf("validation %d = %+v, want no errors", i, errs)
+		}
+	}
+}
+
+func TestValidateJSONSchemaCachesPatterns(t *testing.T) {
+	schema, err := decodeJSONSchema(json.RawMessage(`{"type": "array", "items": {"pattern": "^[a-z]+$"}}`))
+	if err != nil {
+		t.Fatalf("decodeJSONSchema: %v", err)
+	}
+	if errs := validateJSONSchema(schema, decodeTestValue(t, `["a", "b", "C"]`)); len(errs) != 1 || errs[0].Path != "/2" {
+		t.Errorf("validateJSONSchema = %+v, want one error at /2", errs)
+	}
+	if len(schema.patterns) != 1 || schema.patterns["^[a-z]+$"] == nil {
+		t.Errorf("compiled patterns = %v, want the one pattern cached", schema.patterns)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModelManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ModelManifest
+	}{
+		{
+			name:  "json",
+			input: `{"prune": true, "models": [{"name": "llama3:8b"}, {"name": "nomic-embed-text", "digest": "0a109f422b47"}]}`,
+			want:  ModelManifest{Prune: true, Models: []ManifestModel{{Name: "llama3:8b"}, {Name: "nomic-embed-text", Digest: "0a109f422b47"}}},
+		},
+		{
+			name: "scalar and mapping items",
+			input: `prune: false
+models:
+  - llama3:8b-instruct-q4_K_M
+  - name: nomic-embed-text
+    digest: 0a109f422b47
+`,
+			want: ModelManifest{Models: []ManifestModel{{Name: "llama3:8b-instruct-q4_K_M"}, {Name: "nomic-embed-text", Digest: "0a109f422b47"}}},
+		},
+		{
+			name: "comments and quotes",
+			input: `# Models every backend needs
+models: # kept in sync hourly
+  - "llama3:8b"  # quoted
+  - 'mistral'
+  - name: "qwen2.5#1"
+prune: true
+`,
+			want: ModelManifest{Prune: true, Models: []ManifestModel{{Name: "llama3:8b"}, {Name: "mistral"}, {Name: "qwen2.5#1"}}},
+		},
+		{
+			name: "bare dash",
+			input: `models:
+  -
+    name: phi3
+    digest: abc123
+`,
+			want: ModelManifest{Models: []ManifestModel{{Name: "phi3", Digest: "abc123"}}},
+		},
+		{
+			name:  "empty list",
+			input: "models: []\nprune: true\n",
+			want:  ModelManifest{Prune: true},
+		},
+		{
+			name: "list at key indentation",
+			input: `models:
+- llama3
+- name: phi3
+  digest: abc123
+`,
+			want: ModelManifest{Models: []ManifestModel{{Name: "llama3"}, {Name: "phi3", Digest: "abc123"}}},
+		},
+		{
+			name:  "windows line endings",
+			input: "models:\r\n  - llama3\r\n",
+			want:  ModelManifest{Models: []ManifestModel{{Name: "llama3"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseModelManifest([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseModelManifest: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseModelManifest = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseModelManifestRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"unknown key", "version: 2\n", `unknown key "version"`},
+		{"unknown model key", "models:\n  - name: llama3\n    tag: 8b\n", `unknown model key "tag"`},
+		{"prune not bool", "prune: sometimes\n", "prune must be true or false"},
+		{"models not list", "models: llama3\n", "models must be a list"},
+		{"tab indentation", "models:\n\t- llama3\n", "tabs are not allowed"},
+		{"bad indentation", "models:\n  - name: llama3\n      digest: abc\n", "unexpected indentation"},
+		{"indented top level", "  prune: true\n", "unexpected indentation"},
+		{"item without name", "models:\n  - digest: abc123\n", "model 1 has no name"},
+		{"unterminated quote", "models:\n  - \"llama3\n", "unterminated quoted value"},
+		{"multi-line plain scalar", "models:\n  - name: llama3\n    instruct\n", "multi-line values are not supported"},
+		{"flow mapping item", "models:\n  - {name: llama3}\n", "flow mappings"},
+		{"flow mapping value", "models: {llama3: {}}\n", "flow mappings"},
+		{"flow list", "models: [llama3, phi3]\n", "flow lists"},
+		{"flow list value", "models:\n  - name: [llama3]\n", "flow lists"},
+		{"anchor", "models:\n  - &base llama3\n", "anchors and aliases"},
+		{"alias", "models:\n  - name: *base\n", "anchors and aliases"},
+		{"tag", "prune: !!bool true\n", "tags are not supported"},
+		{"literal block scalar", "models:\n  - name: |\n      llama3\n", "block scalars"},
+		{"folded block scalar", "models:\n  - name: >\n      llama3\n", "block scalars"},
+		{"document start", "---\nmodels:\n  - llama3\n", "document markers"},
+		{"second document", "models:\n  - llama3\n---\nprune: true\n", "document markers"},
+		{"document end", "models:\n  - llama3\n...\n", "document markers"},
+		{"directive", "%YAML 1.2\nmodels: []\n", "directives"},
+		{"invalid json", `{"models": [}`, "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseModelManifest([]byte(tt.input))
+			if err == nil {
+				t.Fatalf("parseModelManifest = %+v, want an error containing %q", *got, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseModelManifest error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeModelBackend is an Ollama backend for copy and rename tests. Deleting a
+// model listed in failDelete fails; every request is recorded.
+type fakeModelBackend struct {
+	mu         sync.Mutex
+	failDelete map[string]bool
+	calls      []string // "copy a b" or "delete a"
+}
+
+func (f *fakeModelBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case ollamaTagsPath:
+		w.Write([]byte(`{"models":[{"name":"llama3:latest"}]}`))
+	case ollamaCopyPath:
+		var req OllamaCopyRequestPayload
+		json.NewDecoder(r.Body).Decode(&req)
+		f.calls = append(f.calls, "copy "+req.Source+" "+req.Destination)
+	case ollamaDeletePath:
+		var req OllamaModelActionPayload
+		json.NewDecoder(r.Body).Decode(&req)
+		f.calls = append(f.calls, "delete "+req.Model)
+		if f.failDelete[req.Model] {
+			http.Error(w, `{"error":"model is in use"}`, http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// renameModel calls callModelCopyAPI as an admin to rename llama3 to llama3-backup.
+func renameModel(t *testing.T, fake *fakeModelBackend) *httptest.ResponseRecorder {
+	t.Helper()
+	authStore = &AuthStore{enabled: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	backend, err := newBackend(BackendConfig{Name: "test", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("newBackend: %v", err)
+	}
+
+	clientReq := ClientRequest{ActionType: "rename", Model: "llama3", Destination: "llama3-backup"}
+	r := httptest.NewRequest(http.MethodPost, "/api/ollama-action", nil)
+	r = r.WithContext(context.WithValue(r.Context(), identityKey{}, &Identity{Username: "alice", Role: RoleAdmin}))
+	w := httptest.NewRecorder()
+	callModelCopyAPI(w, r, clientReq, backend, backend.Client(0), true)
+	return w
+}
+
+func TestRenameModel(t *testing.T) {
+	fake := &fakeModelBackend{}
+	w := renameModel(t, fake)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"renamed"`) {
+		t.Errorf("rename: status %d, body %s; want the model renamed", w.Code, w.Body)
+	}
+	if want := []string{"copy llama3 llama3-backup", "delete llama3"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("Ollama calls = %q, want %q", fake.calls, want)
+	}
+}
+
+func TestRenameModelRollsBackCopy(t *testing.T) {
+	fake := &fakeModelBackend{failDelete: map[string]bool{"llama3": true}}
+	w := renameModel(t, fake)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("rename with a failing delete: status %d, want 500", w.Code)
+	}
+	if want := []string{"copy llama3 llama3-backup", "delete llama3", "delete llama3-backup"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("Ollama calls = %q, want %q", fake.calls, want)
+	}
+}
@@ -3,71 +3,296 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers the GIF decoder
+	"image/jpeg"
+	"image/png"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 )
 
-// Base URL for the Ollama API
-const ollamaBaseURL = "http://localhost:11434"
-const ollamaGenerateAPI = ollamaBaseURL + "/api/generate"
-const ollamaChatAPI = ollamaBaseURL + "/api/chat"
-const ollamaTagsAPI = ollamaBaseURL + "/api/tags"
-const ollamaPullAPI = ollamaBaseURL + "/api/pull"
-const ollamaDeleteAPI = ollamaBaseURL + "/api/delete"
+// Default base URL for the Ollama API, used when no backend is configured
+const defaultOllamaURL = "http://localhost:11434"
+
+// Default directory for persistent state, relative to the working directory
+const defaultDataDir = "data"
+
+// Paths of the Ollama API endpoints, relative to a backend's base URL
+const ollamaGeneratePath = "/api/generate"
+const ollamaChatPath = "/api/chat"
+const ollamaTagsPath = "/api/tags"
+const ollamaPullPath = "/api/pull"
+const ollamaPushPath = "/api/push"
+const ollamaDeletePath = "/api/delete"
+const ollamaEmbedPath = "/api/embed"
+const ollamaShowPath = "/api/show"
+const ollamaPsPath = "/api/ps"
+const ollamaCreatePath = "/api/create"
+const ollamaCopyPath = "/api/copy"
 
 // --- API Request/Response Structures ---
 
 // OllamaGenerateRequestPayload for /api/generate
 type OllamaGenerateRequestPayload struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model     string          `json:"model"`
+	Prompt    string          `json:"prompt"`
+	Suffix    string          `json:"suffix,omitempty"` // Text after the insertion point, for fill-in-the-middle models
+	Stream    bool            `json:"stream"`
+	System    string          `json:"system,omitempty"`
+	Template  string          `json:"template,omitempty"`
+	Format    json.RawMessage `json:"format,omitempty"` // "json" or a JSON Schema object
+	Options   *ModelOptions   `json:"options,omitempty"`
+	KeepAlive any             `json:"keep_alive,omitempty"` // Duration string or seconds
+	Raw       bool            `json:"raw,omitempty"`
+	Context   []int           `json:"context,omitempty"`
+	Images    []string        `json:"images,omitempty"` // Base64 images for vision models
 }
 
 // OllamaChatRequestPayload for /api/chat
 type OllamaChatRequestPayload struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model     string           `json:"model"`
+	Messages  []Message        `json:"messages"`
+	Tools     []ToolDefinition `json:"tools,omitempty"`
+	Stream    bool             `json:"stream"`
+	Think     any              `json:"think,omitempty"` // true, false or "low", "medium", "high"
+	Format    json.RawMessage  `json:"format,omitempty"`
+	Options   *ModelOptions    `json:"options,omitempty"`
+	KeepAlive any              `json:"keep_alive,omitempty"`
+}
+
+// ModelOptions are the Ollama runtime parameters passed in the "options" field.
+// Unset fields fall back to the model's defaults.
+type ModelOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	MinP             *float64 `json:"min_p,omitempty"`
+	TypicalP         *float64 `json:"typical_p,omitempty"`
+	NumCtx           *int     `json:"num_ctx,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	NumKeep          *int     `json:"num_keep,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	RepeatPenalty    *float64 `json:"repeat_penalty,omitempty"`
+	RepeatLastN      *int     `json:"repeat_last_n,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Mirostat         *int     `json:"mirostat,omitempty"`
+	MirostatTau      *float64 `json:"mirostat_tau,omitempty"`
+	MirostatEta      *float64 `json:"mirostat_eta,omitempty"`
+	NumGPU           *int     `json:"num_gpu,omitempty"`
+	NumThread        *int     `json:"num_thread,omitempty"`
+	NumBatch         *int     `json:"num_batch,omitempty"`
 }
 
 // Message structure for chat API
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`   // Reasoning of thinking models, separate from the answer
+	Images    []string   `json:"images,omitempty"`     // Base64 images for vision models
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"` // Tools the assistant wants to call
+	ToolName  string     `json:"tool_name,omitempty"`  // Tool whose result a "tool" message carries
 }
 
-// OllamaModelActionPayload for /api/pull and /api/delete
+// OllamaModelActionPayload for /api/delete
 type OllamaModelActionPayload struct {
 	Model string `json:"name"` // Ollama uses 'name' for model actions
 }
 
+// OllamaPullRequestPayload for /api/pull
+type OllamaPullRequestPayload struct {
+	Model    string `json:"name"`
+	Stream   bool   `json:"stream"`
+	Insecure bool   `json:"insecure,omitempty"` // Allow HTTP and unverified TLS to the registry
+	Username string `json:"username,omitempty"` // Registry credentials, from the registries config
+	Password string `json:"password,omitempty"`
+}
+
+// OllamaPushRequestPayload for /api/push
+type OllamaPushRequestPayload struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Insecure bool   `json:"insecure,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// OllamaCreateRequestPayload for /api/create
+type OllamaCreateRequestPayload struct {
+	Model      string            `json:"model"`
+	From       string            `json:"from"`
+	System     string            `json:"system,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	Parameters *ModelOptions     `json:"parameters,omitempty"`
+	Adapters   map[string]string `json:"adapters,omitempty"` // File name to blob digest
+	Stream     bool              `json:"stream"`
+}
+
+// OllamaCopyRequestPayload for /api/copy
+type OllamaCopyRequestPayload struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// OllamaProgressChunk is one line of the NDJSON progress stream returned by /api/pull
+type OllamaProgressChunk struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PullProgressEvent is the SSE payload relayed to the browser while a model is pulled.
+type PullProgressEvent struct {
+	JobID          string  `json:"jobId,omitempty"`
+	State          string  `json:"state,omitempty"` // Job state, set on the final event of a job
+	Model          string  `json:"model"`
+	Status         string  `json:"status"`
+	Digest         string  `json:"digest,omitempty"`
+	Total          int64   `json:"total,omitempty"`
+	Completed      int64   `json:"completed,omitempty"`
+	Percent        float64 `json:"percent,omitempty"`
+	BytesPerSecond float64 `json:"bytesPerSecond,omitempty"`
+	ETASeconds     float64 `json:"etaSeconds,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
 // OllamaResponseChunk for streaming responses (generate and chat)
 type OllamaResponseChunk struct {
-	Model     string `json:"model"`
-	CreatedAt string `json:"created_at"`
-	Response  string `json:"response"` // For generate API
+	Model     string   `json:"model"`
+	CreatedAt string   `json:"created_at"`
+	Response  string   `json:"response"` // For generate API
 	Message   *Message `json:"message"`  // For chat API
-	Done      bool   `json:"done"`
+	Done      bool     `json:"done"`
+	Context   []int    `json:"context,omitempty"` // Final generate chunk only
+
+	// Set on the final chunk only; durations are in nanoseconds
+	DoneReason         string `json:"done_reason,omitempty"`
+	PromptEvalCount    int    `json:"prompt_eval_count,omitempty"`
+	EvalCount          int    `json:"eval_count,omitempty"`
+	TotalDuration      int64  `json:"total_duration,omitempty"`
+	LoadDuration       int64  `json:"load_duration,omitempty"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration,omitempty"`
+	EvalDuration       int64  `json:"eval_duration,omitempty"`
 }
 
 // ClientRequest from frontend to Go backend
 type ClientRequest struct {
-	ActionType string    `json:"actionType"` // "generate", "chat", "pull", "delete"
+	ActionType string    `json:"actionType"` // "generate", "chat", "structured", "embed", "pull", "push", "delete", "load", "unload", "create", "copy", "rename"
 	Model      string    `json:"model"`
-	Prompt     string    `json:"prompt"`     // For generate API
+	Prompt     string    `json:"prompt"`   // For generate API
+	Suffix     string    `json:"suffix"`   // Text after the completion, for fill-in-the-middle (generate only)
 	Messages   []Message `json:"messages"` // For chat API
+	Backend    string    `json:"backend"`  // Backend name; empty selects the default backend
+	// Stored conversation this chat turn belongs to; its history replaces Messages except for the new user turn
+	ConversationID string `json:"conversationId"`
+	// Document collection to retrieve context from for a chat turn, and how many chunks to use
+	Collection string `json:"collection"`
+	TopK       int    `json:"topK"`
+	// Names of server-side tools the model may call during a chat turn
+	Tools []string `json:"tools"`
+	// Structured action: the JSON Schema the answer must match, and how often to retry with the validation errors
+	Schema     json.RawMessage `json:"schema"`
+	MaxRetries *int            `json:"maxRetries"`
+
+	// Optional generation parameters, passed through to Ollama
+	System    string          `json:"system"`    // System prompt
+	Template  string          `json:"template"`  // Prompt template override (generate only)
+	Format    json.RawMessage `json:"format"`    // "json" or a JSON Schema object
+	Options   *ModelOptions   `json:"options"`   // Runtime parameters such as temperature
+	KeepAlive string          `json:"keepAlive"` // How long the model stays loaded, e.g. "5m", "0" or "-1"
+	Think     any             `json:"think"`     // Reasoning for thinking models (chat only): true, false or "low", "medium", "high"
+	Raw       bool            `json:"raw"`       // Skip prompt templating (generate only)
+	Context   []int           `json:"context"`   // Context returned by a previous generate call
+	Images    []string        `json:"images"`    // Base64 images or data: URLs for the prompt (generate only; chat messages carry their own)
+
+	// Copy and rename actions: the new name of Model
+	Destination string `json:"destination"`
+
+	// Create action only; System, Template and Options become the new model's defaults
+	From     string            `json:"from"`     // Base model
+	Adapters map[string]string `json:"adapters"` // LoRA adapter file name to the digest of a blob already on the backend
+	Preview  bool              `json:"preview"`  // Validate and return the Modelfile without creating the model
+
+	// Embed action only
+	Input      json.RawMessage `json:"input"`      // String or list of strings to embed
+	Truncate   *bool           `json:"truncate"`   // Truncate inputs longer than the context instead of failing (Ollama defaults to true)
+	Dimensions int             `json:"dimensions"` // Output dimensions, for models that support shortening
+	Encoding   string          `json:"encoding"`   // "json" (default) or "float32" for little-endian binary vectors
 }
 
 // OllamaModel represents a single model returned by the /api/tags endpoint.
 type OllamaModel struct {
-	Name string `json:"name"`
+	Name       string              `json:"name"`
+	Model      string              `json:"model,omitempty"`
+	ModifiedAt string              `json:"modified_at,omitempty"`
+	Size       int64               `json:"size,omitempty"` // Bytes on disk
+	Digest     string              `json:"digest,omitempty"`
+	Details    *OllamaModelDetails `json:"details,omitempty"`
+	Update     *ModelUpdate        `json:"update,omitempty"` // Set by Ollamana from the last update check
+}
+
+// OllamaModelDetails describes a model's format, family and quantization, as reported by /api/tags and /api/show.
+type OllamaModelDetails struct {
+	ParentModel       string   `json:"parent_model,omitempty"`
+	Format            string   `json:"format,omitempty"`
+	Family            string   `json:"family,omitempty"`
+	Families          []string `json:"families,omitempty"`
+	ParameterSize     string   `json:"parameter_size,omitempty"`
+	QuantizationLevel string   `json:"quantization_level,omitempty"`
+}
+
+// OllamaShowRequestPayload is the request body for Ollama's /api/show endpoint.
+type OllamaShowRequestPayload struct {
+	Model string `json:"model"`
+}
+
+// OllamaShowResponse defines the structure of the JSON response from the /api/show endpoint.
+type OllamaShowResponse struct {
+	Modelfile    string             `json:"modelfile"`
+	Parameters   string             `json:"parameters"`
+	Template     string             `json:"template"`
+	System       string             `json:"system"`
+	License      string             `json:"license"`
+	Details      OllamaModelDetails `json:"details"`
+	ModelInfo    map[string]any     `json:"model_info"`
+	Capabilities []string           `json:"capabilities"`
 }
 
 // OllamaTagsResponse defines the structure of the JSON response from the /api/tags endpoint.
@@ -75,12 +300,505 @@ type OllamaTagsResponse struct {
 	Models []OllamaModel `json:"models"`
 }
 
+// OllamaRunningModel is a model loaded in memory, as reported by /api/ps.
+type OllamaRunningModel struct {
+	Name          string              `json:"name"`
+	Model         string              `json:"model,omitempty"`
+	Size          int64               `json:"size"`      // Bytes of memory used in total
+	SizeVRAM      int64               `json:"size_vram"` // Bytes of that held in GPU memory
+	Digest        string              `json:"digest,omitempty"`
+	Details       *OllamaModelDetails `json:"details,omitempty"`
+	ExpiresAt     time.Time           `json:"expires_at"` // When the model is unloaded unless it is used again
+	ContextLength int64               `json:"context_length,omitempty"`
+}
+
+// OllamaPsResponse defines the structure of the JSON response from the /api/ps endpoint.
+type OllamaPsResponse struct {
+	Models []OllamaRunningModel `json:"models"`
+}
+
+// BackendModels lists the models installed on a single backend.
+type BackendModels struct {
+	Backend string        `json:"backend"`
+	URL     string        `json:"url"`
+	Models  []OllamaModel `json:"models"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// ModelsResponse is returned by /api/models.
+type ModelsResponse struct {
+	Models       []OllamaModel   `json:"models"`                 // Models on the requested (or default) backend
+	Backends     []BackendModels `json:"backends"`               // Models per queried backend
+	DefaultModel string          `json:"defaultModel,omitempty"` // The team default alias, preselected by the UI
+}
+
+// --- Backend Configuration ---
+
+// BackendTLSConfig holds optional TLS settings for an HTTPS backend.
+type BackendTLSConfig struct {
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	CAFile             string `json:"caFile"`
+	CertFile           string `json:"certFile"` // Client certificate for mutual TLS
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+}
+
+// BackendConfig describes one Ollama backend in the config file.
+type BackendConfig struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	AuthHeader string            `json:"authHeader,omitempty"` // Defaults to Authorization when AuthValue is set
+	AuthValue  string            `json:"authValue,omitempty"`
+	TLS        *BackendTLSConfig `json:"tls,omitempty"`
+}
+
+// Config is the Ollamana configuration file format.
+type Config struct {
+	DefaultBackend string           `json:"defaultBackend"`
+	Backends       []BackendConfig  `json:"backends"`
+	JobConcurrency int              `json:"jobConcurrency"` // Number of pull jobs run at once
+	DataDir        string           `json:"dataDir"`        // Directory for persistent state such as conversations
+	Auth           AuthConfig       `json:"auth"`
+	Catalog        CatalogConfig    `json:"catalog"`
+	Tools          ToolsConfig      `json:"tools"`
+	Images         ImagesConfig     `json:"images"`
+	DefaultModel   string           `json:"defaultModel"` // Alias of the team's default model, e.g. "team-default"
+	Registries     []RegistryConfig `json:"registries"`   // Settings for private model registries
+	Sync           SyncConfig       `json:"sync"`
+	Updates        UpdatesConfig    `json:"updates"`
+}
+
+// RegistryConfig holds the settings Ollamana passes to Ollama when pulling
+// from or pushing to a registry, so that credentials never reach the browser.
+type RegistryConfig struct {
+	Host     string `json:"host"`     // As in model names, e.g. "registry.internal:5000"
+	Insecure bool   `json:"insecure"` // Allow HTTP and unverified TLS
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Backend is a configured Ollama instance and the transport used to reach it.
+type Backend struct {
+	Name       string
+	URL        string
+	authHeader string
+	authValue  string
+	transport  *http.Transport
+}
+
+// BackendInfo is the public description of a backend returned by /api/backends.
+type BackendInfo struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Default bool   `json:"default"`
+}
+
+// BackendRegistry holds all configured backends in configuration order.
+type BackendRegistry struct {
+	byName      map[string]*Backend
+	order       []*Backend
+	defaultName string
+}
+
+// backends is the registry used by all handlers; it is set up in main.
+var backends *BackendRegistry
+
+// upstreamError is returned when Ollama answers with a non-200 status.
+type upstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("Status %d, Message: %s", e.StatusCode, e.Body)
+}
+
+// backendFlag collects repeated -backend name=url flags.
+type backendFlag []string
+
+func (f *backendFlag) String() string     { return strings.Join(*f, ",") }
+func (f *backendFlag) Set(v string) error { *f = append(*f, v); return nil }
+
+// normalizeOllamaURL accepts the same forms as OLLAMA_HOST ("host", "host:port",
+// "http://host:port") and returns a base URL without a trailing slash.
+func normalizeOllamaURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("empty URL")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host in %q", raw)
+	}
+	if u.Port() == "" && u.Scheme == "http" {
+		u.Host = net.JoinHostPort(u.Hostname(), "11434")
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// parseBackendSpec parses a "name=url" pair as given to -backend or OLLAMANA_BACKENDS.
+func parseBackendSpec(spec string) (BackendConfig, error) {
+	name, rawURL, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return BackendConfig{}, fmt.Errorf("invalid backend %q, expected name=url", spec)
+	}
+	return BackendConfig{Name: strings.TrimSpace(name), URL: rawURL}, nil
+}
+
+// loadConfig builds the configuration from the config file, the environment and
+// command-line flags, in increasing order of precedence. Later sources replace
+// backends with the same name.
+func loadConfig(path string, flagBackends []string, flagDefault string) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	upsert := func(bc BackendConfig) {
+		for i := range cfg.Backends {
+			if cfg.Backends[i].Name == bc.Name {
+				cfg.Backends[i].URL = bc.URL
+				return
+			}
+		}
+		cfg.Backends = append(cfg.Backends, bc)
+	}
+
+	if host := os.Getenv("OLLAMA_HOST"); host != "" {
+		upsert(BackendConfig{Name: "local", URL: host})
+	}
+	if specs := os.Getenv("OLLAMANA_BACKENDS"); specs != "" {
+		for _, spec := range strings.Split(specs, ",") {
+			bc, err := parseBackendSpec(spec)
+			if err != nil {
+				return nil, fmt.Errorf("OLLAMANA_BACKENDS: %w", err)
+			}
+			upsert(bc)
+		}
+	}
+	if def := os.Getenv("OLLAMANA_DEFAULT_BACKEND"); def != "" {
+		cfg.DefaultBackend = def
+	}
+	for _, spec := range flagBackends {
+		bc, err := parseBackendSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("-backend: %w", err)
+		}
+		upsert(bc)
+	}
+	if flagDefault != "" {
+		cfg.DefaultBackend = flagDefault
+	}
+
+	if len(cfg.Backends) == 0 {
+		cfg.Backends = []BackendConfig{{Name: "local", URL: defaultOllamaURL}}
+	}
+	return cfg, nil
+}
+
+// newBackend validates a backend definition and prepares its transport.
+func newBackend(bc BackendConfig) (*Backend, error) {
+	baseURL, err := normalizeOllamaURL(bc.URL)
+	if err != nil {
+		return nil, fmt.Errorf("backend %q: %w", bc.Name, err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if bc.TLS != nil {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: bc.TLS.InsecureSkipVerify,
+			ServerName:         bc.TLS.ServerName,
+		}
+		if bc.TLS.CAFile != "" {
+			pem, err := os.ReadFile(bc.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("backend %q: reading CA file: %w", bc.Name, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("backend %q: no certificates found in %s", bc.Name, bc.TLS.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if bc.TLS.CertFile != "" || bc.TLS.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(bc.TLS.CertFile, bc.TLS.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("backend %q: loading client certificate: %w", bc.Name, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	authHeader := bc.AuthHeader
+	if authHeader == "" && bc.AuthValue != "" {
+		authHeader = "Authorization"
+	}
+
+	return &Backend{
+		Name:       bc.Name,
+		URL:        baseURL,
+		authHeader: authHeader,
+		authValue:  bc.AuthValue,
+		transport:  transport,
+	}, nil
+}
+
+// newBackendRegistry builds the registry from the loaded configuration.
+func newBackendRegistry(cfg *Config) (*BackendRegistry, error) {
+	reg := &BackendRegistry{byName: make(map[string]*Backend)}
+	for _, bc := range cfg.Backends {
+		if bc.Name == "" {
+			return nil, errors.New("backend without a name in configuration")
+		}
+		if _, dup := reg.byName[bc.Name]; dup {
+			return nil, fmt.Errorf("duplicate backend name %q", bc.Name)
+		}
+		b, err := newBackend(bc)
+		if err != nil {
+			return nil, err
+		}
+		reg.byName[b.Name] = b
+		reg.order = append(reg.order, b)
+	}
+
+	reg.defaultName = cfg.DefaultBackend
+	if reg.defaultName == "" {
+		reg.defaultName = reg.order[0].Name
+	}
+	if _, ok := reg.byName[reg.defaultName]; !ok {
+		return nil, fmt.Errorf("default backend %q is not configured", reg.defaultName)
+	}
+	return reg, nil
+}
+
+// Get returns the named backend, or the default backend when name is empty.
+func (reg *BackendRegistry) Get(name string) (*Backend, bool) {
+	if name == "" {
+		name = reg.defaultName
+	}
+	b, ok := reg.byName[name]
+	return b, ok
+}
+
+// All returns every backend in configuration order.
+func (reg *BackendRegistry) All() []*Backend {
+	return reg.order
+}
+
+// Endpoint returns the full URL of an Ollama API path on this backend.
+func (b *Backend) Endpoint(path string) string {
+	return b.URL + path
+}
+
+// Client returns an HTTP client for this backend with the given timeout.
+func (b *Backend) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: &instrumentedTransport{base: b.transport, backend: b.Name}, Timeout: timeout}
+}
+
+// NewRequest creates a JSON request to an Ollama API path bound to ctx, adding the backend's auth header.
+func (b *Backend) NewRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.Endpoint(path), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.authValue != "" {
+		req.Header.Set(b.authHeader, b.authValue)
+	}
+	return req, nil
+}
+
+// resolveBackend looks up the backend selected by a request, writing a 400 response if it is unknown.
+func resolveBackend(w http.ResponseWriter, name string) (*Backend, bool) {
+	b, ok := backends.Get(name)
+	if !ok {
+		http.Error(w, "Unknown backend: "+name, http.StatusBadRequest)
+		return nil, false
+	}
+	return b, true
+}
+
 // --- Main Server Logic ---
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		runHashPassword()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		runSync(os.Args[2:])
+		return
+	}
+
+	configPath := flag.String("config", os.Getenv("OLLAMANA_CONFIG"), "Path to a JSON config file")
+	defaultBackend := flag.String("default-backend", "", "Name of the backend used when a request does not select one")
+	jobConcurrency := flag.Int("job-concurrency", 0, "Number of background pull jobs run at once (overrides the config file)")
+	dataDir := flag.String("data-dir", os.Getenv("OLLAMANA_DATA_DIR"), "Directory for persistent state (overrides the config file)")
+	authEnabled := flag.Bool("auth", os.Getenv("OLLAMANA_AUTH") == "true", "Require users to log in or present an API key")
+	anonymousRole := flag.String("anonymous-role", os.Getenv("OLLAMANA_ANONYMOUS_ROLE"), "Role of every request while authentication is disabled (default \"user\"; overrides the config file)")
+	catalogURL := flag.String("catalog-url", os.Getenv("OLLAMANA_CATALOG_URL"), "URL of an additional model catalog (overrides the config file)")
+	defaultModel := flag.String("default-model", os.Getenv("OLLAMANA_DEFAULT_MODEL"), "Alias of the team's default model (overrides the config file)")
+	var backendSpecs backendFlag
+	flag.Var(&backendSpecs, "backend", "Ollama backend as name=url (repeatable)")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath, backendSpecs, *defaultBackend)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	backends, err = newBackendRegistry(cfg)
+	if err != nil {
+		log.Fatalf("Error configuring backends: %v", err)
+	}
+	for _, b := range backends.All() {
+		log.Printf("Using Ollama backend %q at %s", b.Name, b.URL)
+	}
+	if *jobConcurrency > 0 {
+		cfg.JobConcurrency = *jobConcurrency
+	}
+	jobs = newJobManager(cfg.JobConcurrency)
+
+	if *dataDir != "" {
+		cfg.DataDir = *dataDir
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = defaultDataDir
+	}
+	conversations, err = openConversationStore(filepath.Join(cfg.DataDir, "conversations"))
+	if err != nil {
+		log.Fatalf("Error opening conversation store: %v", err)
+	}
+	collections, err = openCollectionStore(filepath.Join(cfg.DataDir, "collections"))
+	if err != nil {
+		log.Fatalf("Error opening collection store: %v", err)
+	}
+	usageLog, err = openUsageLog(filepath.Join(cfg.DataDir, "usage.json"))
+	if err != nil {
+		log.Fatalf("Error opening usage log: %v", err)
+	}
+
+	if *catalogURL != "" {
+		cfg.Catalog.URL = *catalogURL
+	}
+	catalog, err = newModelCatalog(cfg.Catalog, filepath.Join(cfg.DataDir, "catalog-cache.json"))
+	if err != nil {
+		log.Fatalf("Error loading model catalog: %v", err)
+	}
+	go refreshInstalledModels()
+	go usageLog.flushPeriodically()
+	go flushOnShutdown()
+
+	registries, err = newRegistrySettings(cfg.Registries)
+	if err != nil {
+		log.Fatalf("Error configuring registries: %v", err)
+	}
+	for host, rc := range registries {
+		log.Printf("Using registry settings for %s (insecure: %t, credentials: %t)", host, rc.Insecure, rc.Username != "")
+	}
+
+	if *defaultModel != "" {
+		cfg.DefaultModel = *defaultModel
+	}
+	defaultModelAlias = cfg.DefaultModel
+
+	imageLimits = cfg.Images
+	if imageLimits.MaxBytes <= 0 {
+		imageLimits.MaxBytes = defaultMaxImageBytes
+	}
+
+	manifestSync, err = newManifestSyncer(cfg.Sync, submitPullJob)
+	if err != nil {
+		log.Fatalf("Error setting up model manifest sync: %v", err)
+	}
+
+	modelUpdates, err = newUpdateChecker(cfg.Updates, filepath.Join(cfg.DataDir, "update-policies.json"))
+	if err != nil {
+		log.Fatalf("Error setting up model update checks: %v", err)
+	}
+
+	tools, err = newToolRegistry(cfg.Tools)
+	if err != nil {
+		log.Fatalf("Error setting up tools: %v", err)
+	}
+
+	if *authEnabled {
+		cfg.Auth.Enabled = true
+	}
+	if *anonymousRole != "" {
+		cfg.Auth.AnonymousRole = *anonymousRole
+	}
+	authStore, err = openAuthStore(filepath.Join(cfg.DataDir, "auth.json"), cfg.Auth)
+	if err != nil {
+		log.Fatalf("Error setting up authentication: %v", err)
+	}
+	if cfg.Auth.AnonymousRole != "" {
+		anonymousIdentity.Role = cfg.Auth.AnonymousRole
+	}
+	if !authStore.enabled {
+		log.Printf("Authentication is disabled; every request has the %q role", anonymousIdentity.Role)
+	}
+	authenticator = authChain{sessionAuthenticator{}, apiKeyAuthenticator{}}
+
 	http.HandleFunc("/", serveHTML)
+	http.HandleFunc("/login", serveLoginHTML)
+	http.HandleFunc("/admin", serveAdminHTML)
+	http.HandleFunc("/api/login", handleLogin)
+	http.HandleFunc("/api/logout", handleLogout)
+	http.HandleFunc("/api/me", handleMe)
+	http.HandleFunc("/api/admin/users", handleAdminUsers)
+	http.HandleFunc("/api/admin/users/{username}", handleAdminUser)
+	http.HandleFunc("/api/admin/keys", handleAdminKeys)
+	http.HandleFunc("/api/admin/keys/{id}", handleAdminKey)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/api/usage", handleUsage)
 	http.HandleFunc("/api/ollama-action", handleOllamaAction) // Unified endpoint for all actions
+	http.HandleFunc("/api/embed", handleEmbed)
+	http.HandleFunc("/api/structured", handleStructured)
+	http.HandleFunc("/api/images", handleUploadImages)
 	http.HandleFunc("/api/models", handleListModels)
+	http.HandleFunc("/api/inventory", handleInventory)
+	http.HandleFunc("/api/ps", handleRunningModels)
+	http.HandleFunc("/api/sync", handleSync)
+	http.HandleFunc("/api/updates", handleModelUpdates)
+	http.HandleFunc("/api/updates/check", handleCheckModelUpdates)
+	http.HandleFunc("/api/updates/policy", handleUpdatePolicy)
+	http.HandleFunc("/api/catalog", handleCatalog)
+	http.HandleFunc("/api/catalog/refresh", handleRefreshCatalog)
+	http.HandleFunc("/api/backends", handleListBackends)
+	http.HandleFunc("/api/cancel", handleCancelGeneration)
+	http.HandleFunc("/api/tools", handleListTools)
+	http.HandleFunc("/api/conversations", handleConversations)
+	http.HandleFunc("/api/conversations/{id}", handleConversation)
+	http.HandleFunc("/api/collections", handleCollections)
+	http.HandleFunc("/api/collections/{name}", handleCollection)
+	http.HandleFunc("/api/collections/{name}/documents", handleCollectionDocuments)
+	http.HandleFunc("/api/collections/{name}/documents/{doc}", handleCollectionDocument)
+	http.HandleFunc("/api/collections/{name}/search", handleCollectionSearch)
+	http.HandleFunc("/v1/chat/completions", handleOpenAIChatCompletions)
+	http.HandleFunc("/v1/completions", handleOpenAICompletions)
+	http.HandleFunc("/v1/models", handleOpenAIModels)
+	http.HandleFunc("/v1/embeddings", handleOpenAIEmbeddings)
+	http.HandleFunc("/api/jobs", handleJobs)
+	http.HandleFunc("/api/jobs/{id}", handleJob)
+	http.HandleFunc("/api/jobs/{id}/events", handleJobEvents)
+	http.HandleFunc("/api/jobs/{id}/cancel", handleCancelJob)
+	http.HandleFunc("/api/jobs/{id}/resume", handleResumeJob)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -88,7 +806,7 @@ func main() {
 	}
 
 	log.Printf("Server starting on http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Fatal(http.ListenAndServe(":"+port, withAuth(http.DefaultServeMux)))
 }
 
 // serveHTML serves the main HTML page for the web UI.
@@ -135,6 +853,18 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             font-weight: 500;
             margin-top: 1rem;
         }
+        .progress-track {
+            background-color: #e5e7eb; /* Gray-200 */
+            border-radius: 9999px;
+            height: 0.5rem;
+            overflow: hidden;
+        }
+        .progress-bar {
+            background-color: #16a34a; /* Green-600 */
+            height: 100%;
+            width: 0;
+            transition: width 0.2s ease-out;
+        }
         #custom-alert-modal {
             z-index: 1000;
         }
@@ -145,6 +875,14 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             max-width: 80%;
             word-wrap: break-word; /* Ensure long words wrap */
         }
+        .image-thumb {
+            max-height: 6rem;
+            max-width: 10rem;
+            border-radius: 6px;
+            border: 1px solid #e5e7eb;
+            display: inline-block;
+            margin: 0.25rem 0.25rem 0 0;
+        }
         .chat-message.user {
             background-color: #e0e7ff; /* Indigo-100 */
             text-align: right;
@@ -168,6 +906,57 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             color: #374151; /* gray-700 */
             margin-bottom: 1rem;
         }
+        .inventory-table th, .inventory-table td {
+            padding: 0.35rem 0.5rem;
+            text-align: left;
+            white-space: nowrap;
+        }
+        .inventory-table th {
+            cursor: pointer;
+            user-select: none;
+        }
+        .inventory-table tbody tr {
+            cursor: pointer;
+            border-bottom: 1px solid #e5e7eb;
+        }
+        .inventory-table tbody tr:hover, .inventory-table tbody tr.selected {
+            background-color: #eef2ff; /* Indigo-50 */
+        }
+        .usage-line {
+            font-size: 0.75rem;
+            color: #6b7280; /* Gray-500 */
+            margin-top: 0.25rem;
+        }
+        .citation-list {
+            font-size: 0.75rem;
+            color: #4b5563; /* Gray-600 */
+            margin-top: 0.5rem;
+            border-top: 1px solid #e5e7eb;
+            padding-top: 0.25rem;
+        }
+        .citation-list summary {
+            cursor: pointer;
+        }
+        .thinking-block {
+            font-size: 0.75rem;
+            font-style: italic;
+            color: #d97706; /* Amber-700 */
+            margin-bottom: 0.25rem;
+            white-space: pre-wrap;
+        }
+        .thinking-block summary {
+            cursor: pointer;
+        }
+        .tool-call {
+            font-family: monospace;
+            font-size: 0.75rem;
+            color: #065f46; /* Emerald-800 */
+            background-color: #ecfdf5; /* Emerald-50 */
+            border-radius: 4px;
+            padding: 0.25rem 0.5rem;
+            margin-bottom: 0.25rem;
+            white-space: pre-wrap;
+        }
         #thinking-output {
             background-color: #fffbeb; /* Amber-50 */
             border: 1px dashed #fcd34d; /* Amber-300 */
@@ -184,9 +973,21 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 </head>
 <body class="bg-gray-100 flex items-center justify-center min-h-screen p-4">
     <div class="container w-full">
+        <div id="user-bar" class="hidden flex justify-end items-center gap-3 text-sm text-gray-600 mb-2">
+            <span>Signed in as <strong id="user-name"></strong> (<span id="user-role"></span>)</span>
+            <a id="admin-link" href="/admin" class="hidden text-indigo-600 hover:underline">Admin</a>
+            <button id="logout-button" class="text-indigo-600 hover:underline">Log out</button>
+        </div>
         <h1 class="text-4xl font-extrabold text-center text-gray-900 mb-4">Ollama Go Web UI</h1>
         <p class="text-center text-gray-600 mb-8">Interact with your local Ollama instance for text generation, chat, and model management.</p>
-        <p class="text-center text-gray-500 text-sm mb-8">Make sure Ollama is running on <code class="bg-gray-200 px-1 py-0.5 rounded">http://localhost:11434</code> and you have downloaded models (e.g., <code class="bg-gray-200 px-1 py-0.5 rounded">ollama pull llama2</code>).</p>
+        <p class="text-center text-gray-500 text-sm mb-8">Make sure Ollama is running on the selected backend (<code id="backend-url" class="bg-gray-200 px-1 py-0.5 rounded">http://localhost:11434</code>) and you have downloaded models (e.g., <code class="bg-gray-200 px-1 py-0.5 rounded">ollama pull llama2</code>).</p>
+
+        <div class="mb-6" id="backend-select-container">
+            <label for="backend-select" class="block text-gray-700 text-sm font-medium mb-2">Ollama Backend:</label>
+            <select id="backend-select" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent">
+                <option value="">Default backend</option>
+            </select>
+        </div>
 
 
         <div class="mb-6">
@@ -194,6 +995,8 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             <select id="api-type-select" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent">
                 <option value="generate">Generate Text</option>
                 <option value="chat">Chat</option>
+                <option value="structured">Structured Output</option>
+                <option value="embed">Embeddings</option>
                 <option value="model-management">Model Management</option>
             </select>
         </div>
@@ -203,6 +1006,71 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             <select id="model-select" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent">
                 <option value="">Loading models...</option>
             </select>
+            <details id="advanced-params" class="mt-4 bg-gray-50 border border-gray-200 rounded-lg p-4">
+                <summary class="cursor-pointer text-gray-700 text-sm font-medium">Advanced Parameters</summary>
+                <div class="mt-4 space-y-4">
+                    <div>
+                        <label for="param-system" class="block text-gray-700 text-xs font-medium mb-1">System Prompt:</label>
+                        <textarea id="param-system" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" style="min-height: 60px" placeholder="Optional system prompt"></textarea>
+                    </div>
+                    <div>
+                        <label for="param-template" class="block text-gray-700 text-xs font-medium mb-1">Prompt Template (generate only):</label>
+                        <textarea id="param-template" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" style="min-height: 60px" placeholder="Overrides the model's template, e.g. {{ .System }} {{ .Prompt }}"></textarea>
+                    </div>
+                    <div class="grid grid-cols-2 md:grid-cols-3 gap-3">
+                    <div>
+                        <label for="param-temperature" class="block text-gray-700 text-xs font-medium mb-1">Temperature (0-2)</label>
+                        <input type="number" step="0.05" id="param-temperature" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                    <div>
+                        <label for="param-top_p" class="block text-gray-700 text-xs font-medium mb-1">Top P (0-1)</label>
+                        <input type="number" step="0.01" id="param-top_p" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                    <div>
+                        <label for="param-top_k" class="block text-gray-700 text-xs font-medium mb-1">Top K</label>
+                        <input type="number" step="1" id="param-top_k" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                    <div>
+                        <label for="param-min_p" class="block text-gray-700 text-xs font-medium mb-1">Min P (0-1)</label>
+                        <input type="number" step="0.01" id="param-min_p" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                    <div>
+                        <label for="param-num_ctx" class="block text-gray-700 text-xs font-medium mb-1">Context Window (num_ctx)</label>
+                        <input type="number" step="1" id="param-num_ctx" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                    <div>
+                        <label for="param-num_predict" class="block text-gray-700 text-xs font-medium mb-1">Max Tokens (num_predict)</label>
+                        <input type="number" step="1" id="param-num_predict" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                    <div>
+                        <label for="param-seed" class="block text-gray-700 text-xs font-medium mb-1">Seed</label>
+                        <input type="number" step="1" id="param-seed" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="random">
+                    </div>
+                    <div>
+                        <label for="param-repeat_penalty" class="block text-gray-700 text-xs font-medium mb-1">Repeat Penalty (0-2)</label>
+                        <input type="number" step="0.05" id="param-repeat_penalty" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="model default">
+                    </div>
+                        <div>
+                            <label for="param-keep-alive" class="block text-gray-700 text-xs font-medium mb-1">Keep Alive</label>
+                            <input type="text" id="param-keep-alive" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="5m, 0, -1">
+                        </div>
+                    </div>
+                    <div>
+                        <label for="param-stop" class="block text-gray-700 text-xs font-medium mb-1">Stop Sequences (one per line):</label>
+                        <textarea id="param-stop" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" style="min-height: 60px"></textarea>
+                    </div>
+                    <div class="flex flex-wrap items-center gap-4 text-sm text-gray-700">
+                        <label for="param-format">Format:
+                            <select id="param-format" class="ml-1 border rounded-lg py-1 px-2">
+                                <option value="">Text</option>
+                                <option value="json">JSON</option>
+                            </select>
+                        </label>
+                        <label><input type="checkbox" id="param-raw" class="mr-1">Raw prompt (generate only)</label>
+                        <label><input type="checkbox" id="param-keep-context" class="mr-1">Continue from previous response (generate only)</label>
+                    </div>
+                </div>
+            </details>
         </div>
 
         <!-- Generate Text Section -->
@@ -211,37 +1079,163 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             <div class="mb-6">
                 <label for="prompt-input" class="block text-gray-700 text-sm font-medium mb-2">Prompt:</label>
                 <textarea id="prompt-input" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="Enter your prompt here..."></textarea>
+                <label class="block text-gray-700 text-sm mt-2">Images for vision models: <input type="file" id="generate-images" accept="image/png,image/jpeg,image/gif,image/webp" multiple class="text-sm"></label>
+                <div id="generate-image-preview"></div>
             </div>
             <button id="generate-button" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
                 Generate Response
             </button>
         </div>
 
-        <!-- Chat Section -->
-        <div id="chat-section" class="api-section hidden">
-            <h2 class="text-xl font-semibold text-gray-800 mb-4">Chat with Model</h2>
-            <div id="chat-history-output" class="bg-gray-50 p-4 rounded-lg border border-gray-200 mb-4 h-64 overflow-y-auto flex flex-col space-y-2">
-                <!-- Chat messages will be appended here -->
+        <!-- Structured Output Section -->
+        <div id="structured-section" class="api-section hidden">
+            <h2 class="text-xl font-semibold text-gray-800 mb-4">Structured Output</h2>
+            <div class="mb-4">
+                <label for="structured-prompt" class="block text-gray-700 text-sm font-medium mb-2">Prompt:</label>
+                <textarea id="structured-prompt" rows="3" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="Describe what the JSON should contain..."></textarea>
             </div>
             <div class="mb-4">
-                <input type="checkbox" id="show-thinking-checkbox" class="mr-2">
-                <label for="show-thinking-checkbox" class="text-gray-700 text-sm font-medium">Display Thinking Process</label>
+                <label for="structured-schema" class="block text-gray-700 text-sm font-medium mb-2">JSON Schema:</label>
+                <textarea id="structured-schema" rows="8" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 font-mono text-sm leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder='{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}'></textarea>
             </div>
-            <div id="thinking-output" class="hidden text-sm mb-4">
-                <!-- Thinking process will be streamed here -->
+            <div class="mb-4">
+                <label for="structured-retries" class="block text-gray-700 text-sm font-medium mb-2">Retries:</label>
+                <input type="number" id="structured-retries" min="0" max="5" value="2" class="shadow-sm border rounded-lg w-32 py-2 px-3 text-gray-700">
             </div>
-            <div class="mb-6">
-                <label for="chat-input" class="block text-gray-700 text-sm font-medium mb-2">Your Message:</label>
-                <textarea id="chat-input" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="Type your message..."></textarea>
+            <button id="structured-button" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
+                Generate JSON
+            </button>
+            <pre id="structured-output" class="mt-4 bg-gray-50 p-4 rounded-lg border border-gray-200 whitespace-pre-wrap text-gray-700 text-sm max-h-96 overflow-y-auto"></pre>
+        </div>
+
+        <!-- Embed Section -->
+        <div id="embed-section" class="api-section hidden">
+            <h2 class="text-xl font-semibold text-gray-800 mb-4">Embeddings</h2>
+            <div class="mb-4">
+                <label for="embed-input" class="block text-gray-700 text-sm font-medium mb-2">Inputs (one per line):</label>
+                <textarea id="embed-input" rows="4" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="Enter the texts to embed..."></textarea>
             </div>
-            <button id="send-chat-button" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
-                Send Message
+            <div class="flex gap-4 mb-4 items-end">
+                <div>
+                    <label for="embed-dimensions" class="block text-gray-700 text-sm font-medium mb-2">Dimensions:</label>
+                    <input type="number" id="embed-dimensions" min="1" class="shadow-sm border rounded-lg w-32 py-2 px-3 text-gray-700" placeholder="model default">
+                </div>
+                <label class="flex items-center gap-2 text-sm text-gray-700 pb-2">
+                    <input type="checkbox" id="embed-truncate" checked> Truncate long inputs
+                </label>
+            </div>
+            <button id="embed-button" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
+                Embed
             </button>
+            <pre id="embed-output" class="mt-4 bg-gray-50 p-4 rounded-lg border border-gray-200 whitespace-pre-wrap text-gray-700 text-sm max-h-64 overflow-y-auto"></pre>
+        </div>
+
+        <!-- Chat Section -->
+        <div id="chat-section" class="api-section hidden">
+            <h2 class="text-xl font-semibold text-gray-800 mb-4">Chat with Model</h2>
+            <div class="flex gap-4">
+                <!-- Conversation Sidebar -->
+                <aside class="w-48 flex-shrink-0">
+                    <button id="new-conversation-button" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white text-sm font-bold py-2 px-3 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
+                        + New Chat
+                    </button>
+                    <div id="conversation-list" class="mt-3 space-y-1 max-h-96 overflow-y-auto text-sm">
+                        <!-- Saved conversations will be listed here -->
+                    </div>
+                </aside>
+                <div class="flex-1 min-w-0">
+                    <div id="chat-history-output" class="bg-gray-50 p-4 rounded-lg border border-gray-200 mb-4 h-64 overflow-y-auto flex flex-col space-y-2">
+                        <!-- Chat messages will be appended here -->
+                    </div>
+                    <div class="mb-4 flex gap-2 items-center">
+                        <label for="collection-select" class="text-gray-700 text-sm font-medium">Answer from documents:</label>
+                        <select id="collection-select" class="shadow-sm border rounded-lg py-1 px-2 text-gray-700 text-sm">
+                            <option value="">None</option>
+                        </select>
+                        <button id="manage-collections-button" class="text-sm text-indigo-600 hover:underline">Manage collections</button>
+                    </div>
+                    <div class="mb-4 flex flex-wrap gap-3 items-center text-sm">
+                        <span class="text-gray-700 font-medium">Tools:</span>
+                        <span id="tool-options" class="flex flex-wrap gap-3 text-gray-700"></span>
+                    </div>
+                    <div id="collections-panel" class="hidden mb-4 p-3 bg-gray-50 border border-gray-200 rounded-lg text-sm">
+                        <div id="collection-create" class="flex flex-wrap gap-2 items-end mb-3">
+                            <input type="text" id="collection-name-input" class="border rounded-lg py-1 px-2" placeholder="Collection name">
+                            <input type="text" id="collection-model-input" class="border rounded-lg py-1 px-2" placeholder="Embedding model, e.g. nomic-embed-text">
+                            <input type="text" id="collection-description-input" class="border rounded-lg py-1 px-2 flex-1" placeholder="Description (optional)">
+                            <button id="create-collection-button" class="bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-1 px-3 rounded-lg">Create</button>
+                        </div>
+                        <div id="collection-upload" class="flex gap-2 items-center mb-3">
+                            <input type="file" id="collection-file-input" multiple accept=".txt,.md,.markdown,.pdf">
+                            <button id="upload-documents-button" class="bg-green-600 hover:bg-green-700 text-white font-bold py-1 px-3 rounded-lg">Upload to selected collection</button>
+                        </div>
+                        <div id="collection-documents" class="space-y-1 text-gray-700"></div>
+                    </div>
+                    <div class="mb-4">
+                        <input type="checkbox" id="show-thinking-checkbox" class="mr-2">
+                        <label for="show-thinking-checkbox" class="text-gray-700 text-sm font-medium" title="Asks reasoning models to think before answering and streams their reasoning here">Display Thinking Process</label>
+                    </div>
+                    <div id="thinking-output" class="hidden text-sm mb-4">
+                        <!-- Thinking process will be streamed here -->
+                    </div>
+                    <div class="mb-6">
+                        <label for="chat-input" class="block text-gray-700 text-sm font-medium mb-2">Your Message:</label>
+                        <textarea id="chat-input" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="Type your message..."></textarea>
+                        <label class="block text-gray-700 text-sm mt-2">Attach images: <input type="file" id="chat-images" accept="image/png,image/jpeg,image/gif,image/webp" multiple class="text-sm"></label>
+                        <div id="chat-image-preview"></div>
+                    </div>
+                    <button id="send-chat-button" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
+                        Send Message
+                    </button>
+                </div>
+            </div>
         </div>
 
         <!-- Model Management Section -->
         <div id="model-management-section" class="api-section hidden">
             <h2 class="text-xl font-semibold text-gray-800 mb-4">Model Management</h2>
+            <div class="mb-6">
+                <div class="flex justify-between items-center mb-2">
+                    <h3 class="text-lg font-semibold text-gray-800">Installed Models</h3>
+                    <div class="flex items-center gap-2">
+                        <span id="inventory-total" class="text-sm text-gray-600"></span>
+                        <button id="check-updates-button" class="bg-gray-200 hover:bg-gray-300 text-gray-800 text-sm font-medium py-1 px-3 rounded-lg">Check for Updates</button>
+                    </div>
+                </div>
+                <div class="overflow-x-auto">
+                    <table class="inventory-table w-full text-sm">
+                        <thead class="bg-gray-100"><tr id="inventory-header"></tr></thead>
+                        <tbody id="inventory-body"></tbody>
+                    </table>
+                </div>
+                <div class="flex gap-2 mt-2">
+                    <input type="text" id="copy-destination" class="flex-1 shadow-sm border rounded-lg py-1 px-3 text-sm text-gray-700" placeholder="New name for the selected model">
+                    <button id="copy-model-button" class="bg-gray-200 hover:bg-gray-300 text-gray-800 text-sm font-medium py-1 px-3 rounded-lg">Copy</button>
+                    <button id="rename-model-button" class="bg-gray-200 hover:bg-gray-300 text-gray-800 text-sm font-medium py-1 px-3 rounded-lg">Rename</button>
+                    <button id="set-default-model-button" class="hidden bg-indigo-100 hover:bg-indigo-200 text-indigo-800 text-sm font-medium py-1 px-3 rounded-lg">Make Team Default</button>
+                </div>
+                <pre id="inventory-details" class="hidden mt-2 p-3 bg-gray-100 border border-gray-200 rounded-lg text-xs text-gray-700 whitespace-pre-wrap max-h-64 overflow-y-auto"></pre>
+            </div>
+
+            <div class="mb-6">
+                <div class="flex justify-between items-center mb-2">
+                    <h3 class="text-lg font-semibold text-gray-800">Running Models</h3>
+                    <button id="refresh-running-button" class="bg-gray-200 hover:bg-gray-300 text-gray-800 text-sm font-medium py-1 px-3 rounded-lg">Refresh</button>
+                </div>
+                <div class="overflow-x-auto">
+                    <table class="inventory-table w-full text-sm">
+                        <thead class="bg-gray-100"><tr><th>Name</th><th>Size</th><th>VRAM</th><th>Expires</th><th></th></tr></thead>
+                        <tbody id="running-body"></tbody>
+                    </table>
+                </div>
+                <div class="flex gap-2 mt-2 items-center">
+                    <input type="text" id="load-keep-alive" class="shadow-sm border rounded-lg w-32 py-2 px-3 text-gray-700" placeholder="keep alive">
+                    <button id="load-model-button" class="flex-1 bg-indigo-600 hover:bg-indigo-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:ring-offset-2">
+                        Preload Selected Model
+                    </button>
+                </div>
+            </div>
+
             <div class="mb-4">
                 <label for="model-action-select" class="block text-gray-700 text-sm font-medium mb-2">Select Installed Model for Action:</label>
                 <select id="model-action-select" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent">
@@ -254,6 +1248,7 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 
             <div class="mb-4">
                 <label for="available-model-select" class="block text-gray-700 text-sm font-medium mb-2">Select Model to Install (from Ollama Registry):</label>
+                <input type="text" id="catalog-search" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 mb-2 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="Search the catalog, e.g. code or embedding">
                 <select id="available-model-select" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent">
                     <option value="">Loading available models...</option>
                 </select>
@@ -264,28 +1259,70 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             </div>
 
             <div class="mb-4">
-                <label for="model-action-input" class="block text-gray-700 text-sm font-medium mb-2">Or, Enter Model Name Manually (for Pull/Delete):</label>
+                <label for="model-action-input" class="block text-gray-700 text-sm font-medium mb-2">Or, Enter Model Name Manually (for Pull/Push/Delete):</label>
                 <input type="text" id="model-action-input" class="shadow-sm appearance-none border rounded-lg w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-indigo-500 focus:border-transparent" placeholder="e.g., new-model:latest or llama2:7b-chat">
             </div>
             <div class="flex space-x-4">
                 <button id="pull-manual-model-button" class="flex-1 bg-green-600 hover:bg-green-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-green-500 focus:ring-offset-2">
                     Pull Manual Model
                 </button>
+                <button id="push-model-button" class="flex-1 bg-blue-600 hover:bg-blue-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-offset-2">
+                    Push Model
+                </button>
                 <button id="delete-model-button" class="flex-1 bg-red-600 hover:bg-red-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-red-500 focus:ring-offset-2">
                     Delete Model
                 </button>
             </div>
             <div id="model-action-output" class="mt-4 bg-gray-50 p-4 rounded-lg border border-gray-200 whitespace-pre-wrap text-gray-700 text-base"></div>
+            <div id="pull-progress" class="mt-4 space-y-3 hidden">
+                <!-- Per-layer pull progress bars will be appended here -->
+            </div>
+
+            <details id="create-model-panel" class="mt-6">
+                <summary class="text-lg font-semibold text-gray-800 cursor-pointer">Create Model</summary>
+                <div class="mt-3 space-y-3">
+                    <div class="flex gap-2">
+                        <input type="text" id="create-model-name" class="flex-1 shadow-sm border rounded-lg py-2 px-3 text-gray-700" placeholder="New model name, e.g. code-reviewer">
+                        <input type="text" id="create-model-from" list="create-model-from-options" class="flex-1 shadow-sm border rounded-lg py-2 px-3 text-gray-700" placeholder="Base model, e.g. llama3:8b">
+                        <datalist id="create-model-from-options"></datalist>
+                    </div>
+                    <textarea id="create-model-system" rows="3" class="shadow-sm border rounded-lg w-full py-2 px-3 text-gray-700" placeholder="System prompt"></textarea>
+                    <textarea id="create-model-template" rows="3" class="shadow-sm border rounded-lg w-full py-2 px-3 text-gray-700 font-mono text-sm" placeholder="Template (optional, Go template syntax)"></textarea>
+                    <textarea id="create-model-parameters" rows="3" class="shadow-sm border rounded-lg w-full py-2 px-3 text-gray-700 font-mono text-sm" placeholder="Parameters, one per line, e.g. temperature 0.2"></textarea>
+                    <textarea id="create-model-adapters" rows="2" class="shadow-sm border rounded-lg w-full py-2 px-3 text-gray-700 font-mono text-sm" placeholder="Adapters, one per line: file-name sha256:digest"></textarea>
+                    <div class="flex gap-2">
+                        <button id="preview-modelfile-button" class="flex-1 bg-gray-200 hover:bg-gray-300 text-gray-800 font-bold py-2 px-4 rounded-lg">Preview Modelfile</button>
+                        <button id="create-model-button" class="flex-1 bg-green-600 hover:bg-green-700 text-white font-bold py-2 px-4 rounded-lg focus:outline-none focus:ring-2 focus:ring-green-500 focus:ring-offset-2">Create Model</button>
+                    </div>
+                    <pre id="modelfile-preview" class="hidden p-3 bg-gray-100 border border-gray-200 rounded-lg text-xs text-gray-700 whitespace-pre-wrap max-h-64 overflow-y-auto"></pre>
+                </div>
+            </details>
+
+            <div class="mt-6">
+                <div class="flex justify-between items-center mb-2">
+                    <h3 class="text-lg font-semibold text-gray-800">Pull and Push Jobs</h3>
+                    <button id="refresh-jobs-button" class="bg-gray-200 hover:bg-gray-300 text-gray-800 text-sm font-medium py-1 px-3 rounded-lg">Refresh</button>
+                </div>
+                <div id="jobs-list" class="space-y-2 text-sm">
+                    <!-- Background jobs will be listed here -->
+                </div>
+            </div>
         </div>
 
         <div id="loading-indicator" class="text-center mt-4 text-indigo-600 font-semibold">
             Generating... Please wait.
         </div>
+        <div class="text-center mt-2">
+            <button id="stop-button" class="hidden bg-red-600 hover:bg-red-700 text-white font-bold py-2 px-6 rounded-lg focus:outline-none focus:ring-2 focus:ring-red-500 focus:ring-offset-2">
+                Stop
+            </button>
+        </div>
 
         <!-- Unified Response Output for Generate/Chat -->
         <div id="unified-response-output" class="mt-8 bg-gray-50 p-6 rounded-lg border border-gray-200">
             <h2 class="text-xl font-semibold text-gray-800 mb-4">Response:</h2>
             <div id="response-output" class="whitespace-pre-wrap text-gray-700 text-base"></div>
+            <div id="generate-usage" class="usage-line"></div>
         </div>
     </div>
 
@@ -306,13 +1343,22 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 
     <script>
         const apiTypeSelect = document.getElementById('api-type-select');
+        const backendSelect = document.getElementById('backend-select');
+        const backendUrlLabel = document.getElementById('backend-url');
         const modelSelect = document.getElementById('model-select');
         const promptInput = document.getElementById('prompt-input');
         const generateButton = document.getElementById('generate-button');
         const responseOutput = document.getElementById('response-output');
         const loadingIndicator = document.getElementById('loading-indicator');
+        const stopButton = document.getElementById('stop-button');
+        const catalogSearch = document.getElementById('catalog-search');
+        const generateUsage = document.getElementById('generate-usage');
 
         const generateSection = document.getElementById('generate-section');
+        const embedSection = document.getElementById('embed-section');
+        const embedButton = document.getElementById('embed-button');
+        const structuredSection = document.getElementById('structured-section');
+        const structuredButton = document.getElementById('structured-button');
         const chatSection = document.getElementById('chat-section');
         const modelManagementSection = document.getElementById('model-management-section');
 
@@ -321,6 +1367,14 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         const chatHistoryOutput = document.getElementById('chat-history-output');
         const showThinkingCheckbox = document.getElementById('show-thinking-checkbox'); // New element
         const thinkingOutput = document.getElementById('thinking-output'); // New element
+        const newConversationButton = document.getElementById('new-conversation-button');
+        const conversationList = document.getElementById('conversation-list');
+        const collectionSelect = document.getElementById('collection-select');
+        const collectionsPanel = document.getElementById('collections-panel');
+        const collectionDocuments = document.getElementById('collection-documents');
+        const toolOptions = document.getElementById('tool-options');
+        const generateImages = document.getElementById('generate-images');
+        const chatImages = document.getElementById('chat-images');
 
         const modelActionSelect = document.getElementById('model-action-select');
         const refreshModelsButton = document.getElementById('refresh-models-button');
@@ -331,6 +1385,10 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         const pullManualModelButton = document.getElementById('pull-manual-model-button');
         const deleteModelButton = document.getElementById('delete-model-button');
         const modelActionOutput = document.getElementById('model-action-output');
+        const loadModelButton = document.getElementById('load-model-button');
+        const pullProgress = document.getElementById('pull-progress');
+        const jobsList = document.getElementById('jobs-list');
+        const refreshJobsButton = document.getElementById('refresh-jobs-button');
         const unifiedResponseOutput = document.getElementById('unified-response-output');
         const commonModelSelectContainer = document.getElementById('common-model-select-container');
 
@@ -379,54 +1437,194 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         });
 
         let chatMessages = [];
+        let currentGenerationId = null;
+        let lastGenerateContext = null;
+
+        // Collects the advanced parameters panel into request fields; empty inputs are left to the model defaults.
+        function collectAdvancedParams(actionType) {
+            const params = {};
+            const options = {};
+            const numberFields = {
+                temperature: 'temperature', top_p: 'top_p', top_k: 'top_k', min_p: 'min_p',
+                num_ctx: 'num_ctx', num_predict: 'num_predict', seed: 'seed', repeat_penalty: 'repeat_penalty',
+            };
+            Object.keys(numberFields).forEach(id => {
+                const value = document.getElementById('param-' + id).value.trim();
+                if (value !== '') { options[numberFields[id]] = Number(value); }
+            });
+            const stop = document.getElementById('param-stop').value.split('\n').filter(line => line !== '');
+            if (stop.length > 0) { options.stop = stop; }
+            if (Object.keys(options).length > 0) { params.options = options; }
+
+            const system = document.getElementById('param-system').value.trim();
+            if (system) { params.system = system; }
+            const format = document.getElementById('param-format').value;
+            if (format) { params.format = format; }
+            const keepAlive = document.getElementById('param-keep-alive').value.trim();
+            if (keepAlive) { params.keepAlive = keepAlive; }
+
+            if (actionType === 'generate') {
+                const template = document.getElementById('param-template').value;
+                if (template.trim()) { params.template = template; }
+                params.raw = document.getElementById('param-raw').checked;
+                if (document.getElementById('param-keep-context').checked && lastGenerateContext) {
+                    params.context = lastGenerateContext;
+                }
+            }
+            return params;
+        }
 
-        // Hardcoded list of common Ollama models with descriptions for "available to install"
-        const availableModels = [
-            { name: "llama2", description: "A powerful open-source large language model from Meta." },
-            { name: "mistral", description: "A small, yet powerful, language model from Mistral AI, optimized for performance." },
-            { name: "gemma", description: "Lightweight, state-of-the-art open models from Google, built from the same research and technology used to create the Gemini models." },
-            { name: "phi", description: "A small language model from Microsoft, ideal for research and experimentation." },
-            { name: "codellama", description: "A family of large language models from Meta designed for code generation and understanding." },
-            { name: "neural-chat", description: "Fine-tuned for engaging conversational AI experiences." },
-            { name: "dolphin-phi", description: "A fine-tuned version of Phi-2, designed for helpful and harmless chat." },
-            { name: "openhermes", description: "A powerful model trained on a diverse range of datasets for general conversational tasks." },
-            { name: "tinyllama", description: "A compact language model, great for resource-constrained environments or quick experiments." },
-            { name: "vicuna", description: "A chatbot trained by fine-tuning LLaMA on user-shared conversations." },
-            { name: "wizardlm", description: "An instruction-following LLM, based on LLaMA, fine-tuned with a large amount of instruction data." },
-            { name: "zephyr", description: "A series of language models that are fine-tuned versions of Mistral, optimized for helpfulness." },
-            { name: "stable-beluga", description: "A powerful instruction-tuned model, based on Llama 2, known for strong performance." },
-            { name: "orca-mini", description: "A smaller, fine-tuned version of Orca, designed for efficient performance on various tasks." },
-            { name: "medllama2", description: "A medical domain-specific version of Llama 2, useful for healthcare-related text generation." },
-            { name: "nous-hermes2", description: "A strong conversational model, part of the Nous Research efforts." }
-        ];
+        // Marks a generation as running; the Stop button is enabled once the server reports its ID.
+        function beginGeneration() {
+            currentGenerationId = null;
+            stopButton.classList.remove('hidden');
+            stopButton.disabled = true;
+        }
 
-        async function fetchAndPopulateModels() {
+        // Takes the generation ID assigned by the server from a generate or chat response.
+        function trackGeneration(response) {
+            currentGenerationId = response.headers.get('X-Generation-Id');
+            stopButton.disabled = !currentGenerationId;
+        }
+
+        function endGeneration() {
+            currentGenerationId = null;
+            stopButton.classList.add('hidden');
+        }
+
+        stopButton.addEventListener('click', async () => {
+            if (!currentGenerationId) { return; }
+            stopButton.disabled = true;
             try {
-                const response = await fetch('/api/models');
-                if (!response.ok) {
-                    const errorText = await response.text();
-                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                const response = await fetch('/api/cancel', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ generationId: currentGenerationId }),
+                });
+                if (!response.ok && response.status !== 404) {
+                    throw new Error("HTTP error! status: " + response.status);
                 }
-                const data = await response.json();
-                
-                modelSelect.innerHTML = ''; 
-                modelActionSelect.innerHTML = '';
+            } catch (error) {
+                console.error('Error cancelling generation:', error);
+                stopButton.disabled = false;
+            }
+        });
 
-                if (data.models && data.models.length > 0) {
-                    data.models.forEach(model => {
-                        const option = document.createElement('option');
-                        option.value = model.name;
-                        option.textContent = model.name;
-                        modelSelect.appendChild(option);
+        // Models available to install, loaded from /api/catalog
+        let availableModels = [];
 
-                        const actionOption = document.createElement('option');
-                        actionOption.value = model.name;
-                        actionOption.textContent = model.name;
-                        modelActionSelect.appendChild(actionOption);
-                    });
-                    if (Array.from(modelSelect.options).some(option => option.value === 'llama2')) {
-                        modelSelect.value = 'llama2';
-                    } else {
+        let backendList = [];
+
+        function currentBackendUrl() {
+            const backend = backendList.find(b => b.name === backendSelect.value);
+            return backend ? backend.url : 'the configured Ollama backend';
+        }
+
+        async function fetchCurrentUser() {
+            try {
+                const response = await fetch('/api/me');
+                if (!response.ok) {
+                    throw new Error("HTTP error! status: " + response.status);
+                }
+                const me = await response.json();
+                const can = permission => me.permissions.includes(permission);
+                if (me.authEnabled) {
+                    document.getElementById('user-name').textContent = me.username;
+                    document.getElementById('user-role').textContent = me.role;
+                    document.getElementById('admin-link').classList.toggle('hidden', !can('users:manage'));
+                    document.getElementById('user-bar').classList.remove('hidden');
+                }
+                // Controls for actions the role may not perform are hidden; the server enforces the same rules.
+                generateButton.classList.toggle('hidden', !can('models:use'));
+                sendChatButton.classList.toggle('hidden', !can('models:use'));
+                embedButton.classList.toggle('hidden', !can('models:use'));
+                pullAvailableModelButton.classList.toggle('hidden', !can('models:pull'));
+                pullManualModelButton.classList.toggle('hidden', !can('models:pull'));
+                deleteModelButton.classList.toggle('hidden', !can('models:delete'));
+                document.getElementById('collection-create').classList.toggle('hidden', !can('collections:manage'));
+                document.getElementById('collection-upload').classList.toggle('hidden', !can('collections:manage'));
+            } catch (error) {
+                console.error('Error fetching current user:', error);
+            }
+        }
+
+        document.getElementById('logout-button').addEventListener('click', async () => {
+            await fetch('/api/logout', { method: 'POST' });
+            window.location.href = '/login';
+        });
+
+        async function fetchAndPopulateBackends() {
+            try {
+                const response = await fetch('/api/backends');
+                if (!response.ok) {
+                    throw new Error("HTTP error! status: " + response.status);
+                }
+                backendList = await response.json();
+                backendSelect.innerHTML = '';
+                backendList.forEach(backend => {
+                    const option = document.createElement('option');
+                    option.value = backend.name;
+                    option.textContent = backend.name + ' (' + backend.url + ')';
+                    backendSelect.appendChild(option);
+                    if (backend.default) {
+                        backendSelect.value = backend.name;
+                    }
+                });
+                backendUrlLabel.textContent = currentBackendUrl();
+            } catch (error) {
+                console.error('Error fetching backends:', error);
+            }
+        }
+
+        let teamDefaultModel = '';
+
+        // Reports whether a model name is the team default alias, with or without its :latest tag.
+        function isTeamDefault(name) {
+            const full = n => n.includes(':') ? n : n + ':latest';
+            return teamDefaultModel !== '' && full(name) === full(teamDefaultModel);
+        }
+
+        async function fetchAndPopulateModels() {
+            if (!modelManagementSection.classList.contains('hidden')) { refreshInventory(); refreshRunningModels(); }
+            try {
+                const response = await fetch('/api/models?backend=' + encodeURIComponent(backendSelect.value));
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const data = await response.json();
+                teamDefaultModel = data.defaultModel || '';
+                const setDefaultButton = document.getElementById('set-default-model-button');
+                setDefaultButton.classList.toggle('hidden', !teamDefaultModel);
+                setDefaultButton.textContent = 'Make Team Default (' + teamDefaultModel + ')';
+                
+                modelSelect.innerHTML = ''; 
+                modelActionSelect.innerHTML = '';
+                const baseModelOptions = document.getElementById('create-model-from-options');
+                baseModelOptions.innerHTML = '';
+
+                if (data.models && data.models.length > 0) {
+                    data.models.forEach(model => {
+                        const option = document.createElement('option');
+                        option.value = model.name;
+                        option.textContent = model.name + (isTeamDefault(model.name) ? ' (team default)' : '');
+                        modelSelect.appendChild(option);
+
+                        const actionOption = document.createElement('option');
+                        actionOption.value = model.name;
+                        actionOption.textContent = model.name + (model.update && model.update.state === 'outdated' ? ' (update available)' : '');
+                        modelActionSelect.appendChild(actionOption);
+
+                        const baseOption = document.createElement('option');
+                        baseOption.value = model.name;
+                        baseModelOptions.appendChild(baseOption);
+                    });
+                    const teamDefaultOption = Array.from(modelSelect.options).find(option => isTeamDefault(option.value));
+                    if (teamDefaultOption) {
+                        modelSelect.value = teamDefaultOption.value;
+                    } else if (Array.from(modelSelect.options).some(option => option.value === 'llama2')) {
+                        modelSelect.value = 'llama2';
+                    } else {
                         modelSelect.selectedIndex = 0;
                     }
                     if (Array.from(modelActionSelect.options).some(option => option.value === 'llama2')) {
@@ -466,13 +1664,286 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 sendChatButton.disabled = true;
                 pullManualModelButton.disabled = true;
                 deleteModelButton.disabled = true;
-                let userMessage = 'Failed to load Ollama models. Please ensure Ollama is running on http://localhost:11434. Error: ' + error.message;
+                let userMessage = 'Failed to load Ollama models. Please ensure Ollama is running on ' + currentBackendUrl() + '. Error: ' + error.message;
                 showAlert(userMessage);
             }
         }
 
-        // Function to populate the "Available Models to Install" dropdown
-        function populateAvailableModels() {
+        // Columns of the installed models table; value returns the sort key of a model.
+        const inventoryColumns = [
+            { key: 'name', label: 'Name', value: m => m.name, text: m => m.name },
+            { key: 'family', label: 'Family', value: m => (m.details && m.details.family) || '', text: m => (m.details && m.details.family) || '' },
+            { key: 'parameters', label: 'Parameters', value: m => parseParameterSize(m.details && m.details.parameter_size), text: m => (m.details && m.details.parameter_size) || '' },
+            { key: 'quantization', label: 'Quantization', value: m => (m.details && m.details.quantization_level) || '', text: m => (m.details && m.details.quantization_level) || '' },
+            { key: 'size', label: 'Size', value: m => m.size || 0, text: m => formatBytes(m.size) },
+            { key: 'context', label: 'Context', value: m => m.context_length || 0, text: m => m.context_length ? m.context_length.toLocaleString() : '' },
+            { key: 'modified', label: 'Modified', value: m => m.modified_at || '', text: m => m.modified_at ? new Date(m.modified_at).toLocaleDateString() : '' },
+            { key: 'update', label: 'Update', value: m => updateStateText(m), text: m => updateStateText(m) },
+        ];
+        let inventoryModels = [];
+        let inventorySort = { key: 'name', ascending: true };
+
+        let runningPollTimer = null;
+
+        async function refreshRunningModels() {
+            const body = document.getElementById('running-body');
+            try {
+                const response = await fetch('/api/ps?backend=' + encodeURIComponent(backendSelect.value));
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const data = await response.json();
+                body.innerHTML = '';
+                if (data.models.length === 0) {
+                    const row = document.createElement('tr');
+                    const td = document.createElement('td');
+                    td.colSpan = 5;
+                    td.className = 'text-gray-500';
+                    td.textContent = 'No models loaded';
+                    row.appendChild(td);
+                    body.appendChild(row);
+                }
+                data.models.forEach(model => {
+                    const row = document.createElement('tr');
+                    const expires = model.expires_in_seconds < 0 ? 'never' : (model.expires_in_seconds > 0 ? 'in ' + formatDuration(model.expires_in_seconds) : 'now');
+                    [model.name, formatBytes(model.size), model.vram_percent + '% (' + formatBytes(model.size_vram) + ')', expires].forEach(text => {
+                        const td = document.createElement('td');
+                        td.textContent = text;
+                        row.appendChild(td);
+                    });
+                    const actions = document.createElement('td');
+                    const unloadButton = document.createElement('button');
+                    unloadButton.className = 'bg-red-600 hover:bg-red-700 text-white text-xs font-medium py-1 px-2 rounded';
+                    unloadButton.textContent = 'Unload';
+                    unloadButton.addEventListener('click', () => loadModel(model.name, 'unload'));
+                    actions.appendChild(unloadButton);
+                    row.appendChild(actions);
+                    body.appendChild(row);
+                });
+            } catch (error) {
+                console.error('Error fetching running models:', error);
+                body.innerHTML = '';
+                const row = document.createElement('tr');
+                const td = document.createElement('td');
+                td.colSpan = 5;
+                td.className = 'text-red-600';
+                td.textContent = 'Could not load running models';
+                row.appendChild(td);
+                body.appendChild(row);
+            }
+        }
+
+        // Preloads a model into memory or unloads it through the load and unload actions.
+        async function loadModel(model, actionType) {
+            const request = { actionType, model, backend: backendSelect.value };
+            const keepAlive = document.getElementById('load-keep-alive').value.trim();
+            if (actionType === 'load' && keepAlive) { request.keepAlive = keepAlive; }
+            modelActionOutput.textContent = (actionType === 'load' ? 'Loading ' : 'Unloading ') + model + '...';
+            loadModelButton.disabled = true;
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(request),
+                });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const result = await response.json();
+                modelActionOutput.textContent = 'Model ' + result.model + ' ' + result.status + (result.load_duration_ms ? ' in ' + (result.load_duration_ms / 1000).toFixed(1) + 's' : '') + '.';
+            } catch (error) {
+                console.error('Error changing loaded models:', error);
+                modelActionOutput.textContent = 'Failed to ' + actionType + ' model ' + model + '. Error: ' + error.message;
+            } finally {
+                loadModelButton.disabled = false;
+                refreshRunningModels();
+            }
+        }
+
+        loadModelButton.addEventListener('click', () => {
+            const model = modelActionInput.value.trim() || modelActionSelect.value;
+            if (!model) { showAlert('Please enter or select a model name to preload.'); return; }
+            loadModel(model, 'load');
+        });
+        document.getElementById('refresh-running-button').addEventListener('click', refreshRunningModels);
+
+        // Converts sizes such as "7B", "70.6B" or "137M" to a number for sorting.
+        function parseParameterSize(size) {
+            const match = /^([0-9.]+)\s*([KMBT]?)/i.exec(size || '');
+            if (!match) { return 0; }
+            const scale = { '': 1, K: 1e3, M: 1e6, B: 1e9, T: 1e12 }[match[2].toUpperCase()];
+            return parseFloat(match[1]) * scale;
+        }
+
+        async function refreshInventory() {
+            try {
+                const response = await fetch('/api/inventory?backend=' + encodeURIComponent(backendSelect.value));
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const data = await response.json();
+                inventoryModels = data.models;
+                document.getElementById('inventory-total').textContent = data.models.length + ' models, ' + formatBytes(data.total_size) + ' on disk';
+                renderInventory();
+            } catch (error) {
+                console.error('Error fetching model inventory:', error);
+                document.getElementById('inventory-total').textContent = 'Could not load inventory';
+            }
+        }
+
+        function renderInventory() {
+            const header = document.getElementById('inventory-header');
+            header.innerHTML = '';
+            inventoryColumns.forEach(column => {
+                const th = document.createElement('th');
+                th.textContent = column.label + (inventorySort.key === column.key ? (inventorySort.ascending ? ' \u25b2' : ' \u25bc') : '');
+                th.addEventListener('click', () => {
+                    inventorySort = { key: column.key, ascending: inventorySort.key === column.key ? !inventorySort.ascending : true };
+                    renderInventory();
+                });
+                header.appendChild(th);
+            });
+
+            const column = inventoryColumns.find(c => c.key === inventorySort.key);
+            const sorted = inventoryModels.slice().sort((a, b) => {
+                const x = column.value(a);
+                const y = column.value(b);
+                const order = x < y ? -1 : (x > y ? 1 : 0);
+                return inventorySort.ascending ? order : -order;
+            });
+
+            const body = document.getElementById('inventory-body');
+            body.innerHTML = '';
+            sorted.forEach(model => {
+                const row = document.createElement('tr');
+                inventoryColumns.forEach(c => {
+                    const td = document.createElement('td');
+                    td.textContent = c.text(model);
+                    if (c.key === 'update') { appendUpdateControls(td, model); }
+                    row.appendChild(td);
+                });
+                row.addEventListener('click', () => {
+                    body.querySelectorAll('tr.selected').forEach(r => r.classList.remove('selected'));
+                    row.classList.add('selected');
+                    modelActionSelect.value = model.name;
+                    showInventoryDetails(model);
+                });
+                body.appendChild(row);
+            });
+        }
+
+        // Describes the result of the last update check for a model.
+        function updateStateText(model) {
+            if (!model.update) { return ''; }
+            return { current: 'up to date', outdated: 'available', unknown: 'not in a registry', error: 'check failed' }[model.update.state] || '';
+        }
+
+        // Adds the Update button of an outdated model and its auto-update checkbox.
+        function appendUpdateControls(td, model) {
+            if (!model.update || model.update.state === 'unknown') { return; }
+            if (model.update.error) { td.title = model.update.error; }
+            if (model.update.state === 'outdated') {
+                const updateButton = document.createElement('button');
+                updateButton.className = 'ml-2 bg-green-600 hover:bg-green-700 text-white text-xs font-medium py-1 px-2 rounded';
+                updateButton.textContent = 'Update';
+                updateButton.addEventListener('click', (event) => {
+                    event.stopPropagation();
+                    performPullModel(model.name);
+                });
+                td.appendChild(updateButton);
+            }
+            const label = document.createElement('label');
+            label.className = 'ml-2 text-xs text-gray-600';
+            label.title = 'Pull this model whenever a check finds it outdated';
+            const checkbox = document.createElement('input');
+            checkbox.type = 'checkbox';
+            checkbox.className = 'mr-1';
+            checkbox.checked = model.update.autoUpdate;
+            checkbox.addEventListener('click', (event) => event.stopPropagation());
+            checkbox.addEventListener('change', () => setAutoUpdate(model.name, checkbox.checked, checkbox));
+            label.appendChild(checkbox);
+            label.appendChild(document.createTextNode('auto'));
+            label.addEventListener('click', (event) => event.stopPropagation());
+            td.appendChild(label);
+        }
+
+        async function setAutoUpdate(model, autoUpdate, checkbox) {
+            try {
+                const response = await fetch('/api/updates/policy', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ model, autoUpdate }),
+                });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                modelActionOutput.textContent = 'Automatic updates ' + (autoUpdate ? 'enabled' : 'disabled') + ' for ' + model + '.';
+            } catch (error) {
+                console.error('Error changing update policy:', error);
+                checkbox.checked = !autoUpdate;
+                showAlert('Failed to change the update policy of ' + model + '. Error: ' + error.message);
+            }
+        }
+
+        // Compares the installed models with their registries now, then shows the results.
+        async function checkForUpdates() {
+            const button = document.getElementById('check-updates-button');
+            button.disabled = true;
+            modelActionOutput.textContent = 'Checking installed models for updates...';
+            try {
+                const response = await fetch('/api/updates/check', { method: 'POST' });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const data = await response.json();
+                const outdated = data.models.filter(u => u.state === 'outdated').length;
+                const failed = data.models.filter(u => u.state === 'error').length;
+                modelActionOutput.textContent = outdated + ' of ' + data.models.length + ' models have updates' + (failed ? ', ' + failed + ' could not be checked' : '') + '.';
+                await fetchAndPopulateModels();
+            } catch (error) {
+                console.error('Error checking for updates:', error);
+                modelActionOutput.textContent = 'Failed to check for updates. Error: ' + error.message;
+            } finally {
+                button.disabled = false;
+            }
+        }
+
+        document.getElementById('check-updates-button').addEventListener('click', checkForUpdates);
+
+        function showInventoryDetails(model) {
+            const details = document.getElementById('inventory-details');
+            const lines = [model.name + '  ' + (model.digest || '')];
+            if (model.error) { lines.push('Error: ' + model.error); }
+            if (model.update && model.update.remoteDigest) { lines.push('Registry digest: ' + model.update.remoteDigest + ' (checked ' + new Date(model.update.checkedAt).toLocaleString() + ')'); }
+            if (model.capabilities && model.capabilities.length) { lines.push('Capabilities: ' + model.capabilities.join(', ')); }
+            if (model.context_length) { lines.push('Context length: ' + model.context_length); }
+            if (model.parameters) { lines.push('', 'Parameters:', model.parameters); }
+            if (model.system) { lines.push('', 'System:', model.system); }
+            if (model.template) { lines.push('', 'Template:', model.template); }
+            if (model.license) { lines.push('', 'License:', model.license.split('\n').slice(0, 5).join('\n') + '\n...'); }
+            if (model.modelfile) { lines.push('', 'Modelfile:', model.modelfile); }
+            details.textContent = lines.join('\n');
+            details.classList.remove('hidden');
+        }
+
+        // Function to populate the "Available Models to Install" dropdown from the server-side catalog
+        async function populateAvailableModels() {
+            try {
+                const response = await fetch('/api/catalog?q=' + encodeURIComponent(catalogSearch.value.trim()));
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                availableModels = (await response.json()).models;
+            } catch (error) {
+                console.error('Error fetching model catalog:', error);
+                availableModels = [];
+            }
             availableModelSelect.innerHTML = ''; // Clear existing options
             if (availableModels.length > 0) {
                 availableModels.forEach(model => {
@@ -501,7 +1972,18 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             const selectedModelName = availableModelSelect.value;
             const selectedModel = availableModels.find(model => model.name === selectedModelName);
             if (selectedModel && selectedModel.description) {
-                availableModelDescription.textContent = selectedModel.description;
+                const lines = [selectedModel.description];
+                if (selectedModel.capabilities && selectedModel.capabilities.length) {
+                    lines.push('Capabilities: ' + selectedModel.capabilities.join(', '));
+                }
+                (selectedModel.tags || []).forEach(variant => {
+                    let line = selectedModel.name + ':' + variant.tag + ' - ' + formatBytes(variant.size);
+                    if (variant.parameters) { line += ', ' + variant.parameters + ' parameters'; }
+                    if (variant.minMemoryGB) { line += ', needs ' + variant.minMemoryGB + ' GB RAM/VRAM'; }
+                    lines.push(line);
+                });
+                availableModelDescription.textContent = lines.join('\n');
+                availableModelDescription.classList.add('whitespace-pre-line');
                 availableModelDescription.classList.remove('hidden');
             } else {
                 availableModelDescription.textContent = '';
@@ -510,8 +1992,14 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         });
 
 
+        let catalogSearchTimer = null;
+        catalogSearch.addEventListener('input', () => {
+            clearTimeout(catalogSearchTimer);
+            catalogSearchTimer = setTimeout(populateAvailableModels, 300);
+        });
+
         function showSection(sectionId) {
-            const sections = [generateSection, chatSection, modelManagementSection];
+            const sections = [generateSection, chatSection, structuredSection, embedSection, modelManagementSection];
             sections.forEach(section => {
                 if (section.id === sectionId) {
                     section.classList.remove('hidden');
@@ -524,9 +2012,16 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 commonModelSelectContainer.classList.add('hidden');
                 unifiedResponseOutput.classList.add('hidden');
                 populateAvailableModels(); // Populate available models when showing this section
+                refreshInventory();
+                refreshRunningModels();
+                refreshJobs();
+                if (!jobsPollTimer) { jobsPollTimer = setInterval(refreshJobs, 3000); }
+                if (!runningPollTimer) { runningPollTimer = setInterval(refreshRunningModels, 10000); }
             } else {
                 commonModelSelectContainer.classList.remove('hidden');
-                unifiedResponseOutput.classList.remove('hidden');
+                unifiedResponseOutput.classList.toggle('hidden', sectionId === 'embed-section' || sectionId === 'structured-section');
+                if (jobsPollTimer) { clearInterval(jobsPollTimer); jobsPollTimer = null; }
+                if (runningPollTimer) { clearInterval(runningPollTimer); runningPollTimer = null; }
             }
         }
 
@@ -540,14 +2035,24 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             thinkingOutput.classList.add('hidden');
             showThinkingCheckbox.checked = false; // Uncheck checkbox
             if (selectedType === 'chat') {
-                chatMessages = [];
-                chatHistoryOutput.innerHTML = '';
+                startNewConversation();
+                refreshConversations();
             }
         });
 
-        document.addEventListener('DOMContentLoaded', () => {
-            fetchAndPopulateModels();
+        document.addEventListener('DOMContentLoaded', async () => {
             showSection(apiTypeSelect.value + '-section');
+            fetchCurrentUser();
+            refreshConversations();
+            refreshCollections();
+            fetchTools();
+            await fetchAndPopulateBackends();
+            fetchAndPopulateModels();
+        });
+
+        backendSelect.addEventListener('change', () => {
+            backendUrlLabel.textContent = currentBackendUrl();
+            fetchAndPopulateModels();
         });
 
         refreshModelsButton.addEventListener('click', fetchAndPopulateModels);
@@ -557,19 +2062,30 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             const model = modelSelect.value;
             if (!prompt) { showAlert('Please enter a prompt.'); return; }
             if (!model) { showAlert('Please select an Ollama model.'); return; }
+            let images;
+            try {
+                images = await uploadImages(generateImages);
+            } catch (error) {
+                showAlert('Could not attach images: ' + error.message);
+                return;
+            }
 
             responseOutput.textContent = '';
+            generateUsage.textContent = '';
             loadingIndicator.style.display = 'block';
+            beginGeneration();
             generateButton.disabled = true;
             modelSelect.disabled = true;
             apiTypeSelect.disabled = true;
+            backendSelect.disabled = true;
 
             try {
                 const response = await fetch('/api/ollama-action', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ actionType: 'generate', prompt, model }),
+                    body: JSON.stringify(Object.assign({ actionType: 'generate', prompt, images, model, backend: backendSelect.value }, collectAdvancedParams('generate'))),
                 });
+                trackGeneration(response);
 
                 if (!response.ok) {
                     const errorText = await response.text();
@@ -579,6 +2095,7 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 const reader = response.body.getReader();
                 const decoder = new TextDecoder('utf-8');
                 let buffer = '';
+                let eventName = '';
 
                 while (true) {
                     const { done, value } = await reader.read();
@@ -588,14 +2105,23 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                     buffer = lines.pop();
 
                     for (const line of lines) {
+                        if (line === '') { eventName = ''; continue; }
+                        if (line.startsWith('event: ')) { eventName = line.substring(7); continue; }
                         if (line.startsWith('data: ')) {
-                            const data = line.substring(5);
+                            const data = line.substring(6);
                             if (data === '[DONE]') { reader.cancel(); return; }
                             try {
                                 const jsonChunk = JSON.parse(data);
+                                if (eventName === 'usage') {
+                                    generateUsage.textContent = formatUsage(jsonChunk);
+                                    continue;
+                                }
                                 if (jsonChunk.response) {
                                     responseOutput.textContent += jsonChunk.response;
                                 }
+                                if (jsonChunk.context) {
+                                    lastGenerateContext = jsonChunk.context;
+                                }
                             } catch (e) { console.warn('Could not parse JSON chunk:', data, e); }
                         }
                     }
@@ -614,11 +2140,11 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 console.error('Error:', error);
                 let userMessage = 'An unexpected error occurred: ' + error.message;
                 if (error.message.includes("Could not connect to Ollama")) {
-                    userMessage = "Could could not connect to Ollama. Please ensure Ollama is running on http://localhost:11434 and the model '" + model + "' is available (e.g., 'ollama run " + model + "').";
+                    userMessage = "Could could not connect to Ollama. Please ensure Ollama is running on " + currentBackendUrl() + " and the model '" + model + "' is available (e.g., 'ollama run " + model + "').";
                 } else if (error.message.includes("404")) {
                     userMessage = "Ollama API error: Model '" + model + "' not found. Please ensure the model is installed (e.g., 'ollama run " + model + "').";
                 } else if (error.message.includes("400")) {
-                    userMessage = "Ollama API error: Bad request. Check your prompt or model name.";
+                    userMessage = "Ollama API error: Bad request. Check your prompt, model name and advanced parameters. " + error.message;
                 } else if (error.message.includes("500")) {
                     userMessage = "Internal server error. Please check the Go application logs for details.";
                 }
@@ -626,41 +2152,140 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 responseOutput.textContent = userMessage;
             } finally {
                 loadingIndicator.style.display = 'none';
+                endGeneration();
                 generateButton.disabled = false;
                 modelSelect.disabled = false;
                 apiTypeSelect.disabled = false;
+                backendSelect.disabled = false;
+            }
+        });
+
+        embedButton.addEventListener('click', async () => {
+            const inputs = document.getElementById('embed-input').value.split('\n').map(line => line.trim()).filter(line => line);
+            const model = modelSelect.value;
+            const embedOutput = document.getElementById('embed-output');
+            if (inputs.length === 0) { showAlert('Please enter at least one input.'); return; }
+            if (!model) { showAlert('Please select an Ollama model.'); return; }
+
+            const request = { actionType: 'embed', model, input: inputs, backend: backendSelect.value, truncate: document.getElementById('embed-truncate').checked };
+            const dimensions = parseInt(document.getElementById('embed-dimensions').value, 10);
+            if (dimensions > 0) { request.dimensions = dimensions; }
+
+            embedOutput.textContent = '';
+            loadingIndicator.style.display = 'block';
+            embedButton.disabled = true;
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(request),
+                });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const data = await response.json();
+                const lines = [data.embeddings.length + ' vectors, ' + data.dimensions + ' dimensions, ' + data.prompt_eval_count + ' tokens', ''];
+                data.embeddings.forEach((vector, i) => {
+                    lines.push(inputs[i].substring(0, 40) + ': [' + vector.slice(0, 6).map(v => v.toFixed(4)).join(', ') + (vector.length > 6 ? ', ...' : '') + ']');
+                });
+                embedOutput.textContent = lines.join('\n');
+            } catch (error) {
+                console.error('Error:', error);
+                embedOutput.textContent = 'Embedding failed: ' + error.message;
+            } finally {
+                loadingIndicator.style.display = 'none';
+                embedButton.disabled = false;
+            }
+        });
+
+        structuredButton.addEventListener('click', async () => {
+            const prompt = document.getElementById('structured-prompt').value.trim();
+            const model = modelSelect.value;
+            const structuredOutput = document.getElementById('structured-output');
+            if (!prompt) { showAlert('Please enter a prompt.'); return; }
+            if (!model) { showAlert('Please select an Ollama model.'); return; }
+            let schema;
+            try {
+                schema = JSON.parse(document.getElementById('structured-schema').value);
+            } catch (error) {
+                showAlert('The schema is not valid JSON: ' + error.message);
+                return;
+            }
+
+            const request = { actionType: 'structured', model, prompt, schema, backend: backendSelect.value };
+            const retries = parseInt(document.getElementById('structured-retries').value, 10);
+            if (retries >= 0) { request.maxRetries = retries; }
+
+            structuredOutput.textContent = '';
+            loadingIndicator.style.display = 'block';
+            structuredButton.disabled = true;
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(request),
+                });
+                if (!response.ok && response.status !== 422) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const data = await response.json();
+                const attempts = data.attempts + (data.attempts === 1 ? ' attempt' : ' attempts');
+                const lines = [(data.valid ? 'Valid after ' : 'No valid answer after ') + attempts, '', JSON.stringify(data.object, null, 2)];
+                (data.diagnostics || []).forEach(d => {
+                    lines.push('', 'Attempt ' + d.attempt + ' rejected:');
+                    if (d.parseError) { lines.push('  ' + d.parseError); }
+                    (d.errors || []).forEach(e => lines.push('  ' + (e.path || '(root)') + ': ' + e.message));
+                });
+                structuredOutput.textContent = lines.join('\n');
+            } catch (error) {
+                console.error('Error:', error);
+                structuredOutput.textContent = 'Structured generation failed: ' + error.message;
+            } finally {
+                loadingIndicator.style.display = 'none';
+                structuredButton.disabled = false;
             }
         });
 
         sendChatButton.addEventListener('click', async () => {
             const userMessageContent = chatInput.value.trim();
             const model = modelSelect.value;
-            if (!userMessageContent) { showAlert('Please enter a message.'); return; }
+            if (!userMessageContent && chatImages.files.length === 0) { showAlert('Please enter a message.'); return; }
             if (!model) { showAlert('Please select an Ollama model.'); return; }
+            let images;
+            try {
+                images = await uploadImages(chatImages);
+            } catch (error) {
+                showAlert('Could not attach images: ' + error.message);
+                return;
+            }
 
-            chatMessages.push({ role: "user", content: userMessageContent });
-            appendChatMessage("user", userMessageContent);
+            chatMessages.push({ role: "user", content: userMessageContent, images });
+            appendChatMessage("user", userMessageContent, { images });
             chatInput.value = '';
+            chatImages.value = '';
+            renderImagePreview(chatImages, 'chat-image-preview');
 
-            // Clear thinking output and show it if checkbox is checked
+            // Clear thinking output; it is shown once the model streams reasoning
             thinkingOutput.textContent = '';
-            if (showThinkingCheckbox.checked) {
-                thinkingOutput.classList.remove('hidden');
-            } else {
-                thinkingOutput.classList.add('hidden');
-            }
+            thinkingOutput.classList.add('hidden');
 
             loadingIndicator.style.display = 'block';
+            beginGeneration();
             sendChatButton.disabled = true;
             modelSelect.disabled = true;
             apiTypeSelect.disabled = true;
+            backendSelect.disabled = true;
 
             try {
+                const conversationId = await ensureConversation(model);
                 const response = await fetch('/api/ollama-action', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ actionType: 'chat', messages: chatMessages, model }),
+                    body: JSON.stringify(Object.assign({ actionType: 'chat', messages: messagesForRequest(), model, backend: backendSelect.value, conversationId, collection: collectionSelect.value, tools: selectedTools(), think: showThinkingCheckbox.checked || undefined }, collectAdvancedParams('chat'))),
                 });
+                trackGeneration(response);
 
                 if (!response.ok) {
                     const errorText = await response.text();
@@ -670,7 +2295,13 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 const reader = response.body.getReader();
                 const decoder = new TextDecoder('utf-8');
                 let buffer = '';
+                let eventName = '';
                 let assistantResponseContent = '';
+                let replyUsage = null;
+                let replyCitations = null;
+                let replyThinking = '';
+                const replyToolCalls = [];
+                let streamError = null;
 
                 // Create a temporary div for the assistant's final message (will be populated later)
                 const assistantMessageDiv = document.createElement('div');
@@ -686,25 +2317,60 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                     buffer = lines.pop();
 
                     for (const line of lines) {
+                        if (line === '') { eventName = ''; continue; }
+                        if (line.startsWith('event: ')) { eventName = line.substring(7); continue; }
                         if (line.startsWith('data: ')) {
-                            const data = line.substring(5);
+                            const data = line.substring(6);
                             if (data === '[DONE]') { reader.cancel(); break; }
                             try {
                                 const jsonChunk = JSON.parse(data);
-                                if (jsonChunk.message && jsonChunk.message.content) {
-                                    assistantResponseContent += jsonChunk.message.content;
-                                    // Update thinking output with streamed content
+                                if (eventName === 'usage') {
+                                    replyUsage = jsonChunk;
+                                    continue;
+                                }
+                                if (eventName === 'citations') {
+                                    replyCitations = jsonChunk;
+                                    continue;
+                                }
+                                if (eventName === 'tool_call') {
+                                    assistantMessageDiv.textContent = 'Calling ' + jsonChunk.name + '...';
+                                    continue;
+                                }
+                                if (eventName === 'tool_result') {
+                                    replyToolCalls.push(jsonChunk);
+                                    continue;
+                                }
+                                if (eventName === 'error') {
+                                    streamError = jsonChunk.error;
+                                    continue;
+                                }
+                                if (eventName === 'thinking') {
+                                    replyThinking += jsonChunk.message.thinking;
+                                    // Stream the model's reasoning into the thinking output
                                     if (showThinkingCheckbox.checked) {
-                                        thinkingOutput.textContent += jsonChunk.message.content;
+                                        thinkingOutput.textContent += jsonChunk.message.thinking;
+                                        thinkingOutput.classList.remove('hidden');
                                         thinkingOutput.scrollTop = thinkingOutput.scrollHeight; // Scroll thinking output
                                     }
+                                    continue;
+                                }
+                                if (jsonChunk.message && jsonChunk.message.content) {
+                                    assistantResponseContent += jsonChunk.message.content;
                                 }
                             } catch (e) { console.warn('Could not parse JSON chunk:', data, e); }
                         }
                     }
                 }
+                if (streamError) {
+                    assistantMessageDiv.remove();
+                    throw new Error(streamError);
+                }
                 // After streaming, set the final content for the assistant's message
                 assistantMessageDiv.textContent = assistantResponseContent;
+                appendToolCalls(assistantMessageDiv, replyToolCalls);
+                appendThinking(assistantMessageDiv, replyThinking);
+                appendCitations(assistantMessageDiv, replyCitations);
+                appendUsageLine(assistantMessageDiv, replyUsage);
                 chatHistoryOutput.scrollTop = chatHistoryOutput.scrollHeight; // Scroll main chat history
 
                 // Add the complete assistant response to chatMessages
@@ -716,11 +2382,11 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 console.error('Error:', error);
                 let userMessage = 'An unexpected error occurred during chat: ' + error.message;
                 if (error.message.includes("Could not connect to Ollama")) {
-                    userMessage = "Could not connect to Ollama. Please ensure Ollama is running on http://localhost:11434 and the model '" + model + "' is available (e.g., 'ollama run " + model + "').";
+                    userMessage = "Could not connect to Ollama. Please ensure Ollama is running on " + currentBackendUrl() + " and the model '" + model + "' is available (e.g., 'ollama run " + model + "').";
                 } else if (error.message.includes("404")) {
                     userMessage = "Ollama API error: Model '" + model + "' not found. Please ensure the model is installed (e.g., 'ollama run " + model + "').";
                 } else if (error.message.includes("400")) {
-                    userMessage = "Ollama API error: Bad request. Check your message or model name.";
+                    userMessage = "Ollama API error: Bad request. Check your message, model name and advanced parameters. " + error.message;
                 } else if (error.message.includes("500")) {
                     userMessage = "Internal server error. Please check the Go application logs for details.";
                 }
@@ -728,455 +2394,9941 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 appendChatMessage("error", userMessage);
             } finally {
                 loadingIndicator.style.display = 'none';
+                endGeneration();
                 sendChatButton.disabled = false;
                 modelSelect.disabled = false;
                 apiTypeSelect.disabled = false;
+                backendSelect.disabled = false;
                 // Always clear and hide thinking output after response (or error)
                 thinkingOutput.textContent = '';
                 thinkingOutput.classList.add('hidden');
+                refreshConversations();
             }
         });
 
-        // Event listener for the "Display Thinking Process" checkbox
-        showThinkingCheckbox.addEventListener('change', () => {
-            if (showThinkingCheckbox.checked) {
-                // If already hidden and checked, show it (e.g., if streaming is ongoing)
-                if (thinkingOutput.textContent !== '') { // Only show if there's content
-                    thinkingOutput.classList.remove('hidden');
-                }
-            } else {
-                thinkingOutput.classList.add('hidden');
-            }
-        });
-
+        let currentConversationId = null;
 
-        function appendChatMessage(role, content) {
-            const messageDiv = document.createElement('div');
-            messageDiv.classList.add('chat-message', role);
-            messageDiv.textContent = content;
-            chatHistoryOutput.appendChild(messageDiv);
-            chatHistoryOutput.scrollTop = chatHistoryOutput.scrollHeight;
+        function startNewConversation() {
+            currentConversationId = null;
+            chatMessages = [];
+            chatHistoryOutput.innerHTML = '';
+            highlightCurrentConversation();
         }
 
-        // Unified pull function for both manual input and dropdown selection
-        async function performPullModel(modelName) {
-            modelActionOutput.textContent = 'Pulling model ' + modelName + '... This may take a while.';
-            loadingIndicator.style.display = 'block';
-            pullManualModelButton.disabled = true;
-            pullAvailableModelButton.disabled = true;
-            deleteModelButton.disabled = true;
-            modelSelect.disabled = true;
-            apiTypeSelect.disabled = true;
-            refreshModelsButton.disabled = true;
-            availableModelSelect.disabled = true;
+        function highlightCurrentConversation() {
+            Array.from(conversationList.children).forEach(item => {
+                item.classList.toggle('bg-indigo-100', item.dataset.id === currentConversationId);
+            });
+        }
 
+        async function refreshConversations() {
             try {
-                const response = await fetch('/api/ollama-action', {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ actionType: 'pull', model: modelName }),
+                const response = await fetch('/api/conversations');
+                if (!response.ok) { throw new Error("HTTP error! status: " + response.status); }
+                const data = await response.json();
+                renderConversations(data.conversations || []);
+            } catch (error) {
+                console.error('Error fetching conversations:', error);
+            }
+        }
+
+        function renderConversations(list) {
+            conversationList.innerHTML = '';
+            if (list.length === 0) {
+                conversationList.innerHTML = '<p class="text-gray-500 text-xs">No saved conversations.</p>';
+                return;
+            }
+            list.forEach(conversation => {
+                const item = document.createElement('div');
+                item.dataset.id = conversation.id;
+                item.className = 'group flex items-center justify-between rounded-lg px-2 py-1 hover:bg-gray-200 cursor-pointer';
+
+                const title = document.createElement('span');
+                title.className = 'truncate text-gray-700';
+                title.textContent = conversation.title;
+                title.title = conversation.title + (conversation.model ? ' (' + conversation.model + ')' : '');
+                title.addEventListener('click', () => openConversation(conversation.id));
+                item.appendChild(title);
+
+                const actions = document.createElement('span');
+                actions.className = 'flex-shrink-0 hidden group-hover:inline';
+                const renameButton = document.createElement('button');
+                renameButton.textContent = '✎';
+                renameButton.title = 'Rename';
+                renameButton.className = 'text-gray-500 hover:text-indigo-600 px-1';
+                renameButton.addEventListener('click', (event) => {
+                    event.stopPropagation();
+                    beginRenameConversation(item, title, conversation);
                 });
+                const deleteButton = document.createElement('button');
+                deleteButton.textContent = '✕';
+                deleteButton.title = 'Delete';
+                deleteButton.className = 'text-gray-500 hover:text-red-600 px-1';
+                deleteButton.addEventListener('click', (event) => {
+                    event.stopPropagation();
+                    deleteConversation(conversation);
+                });
+                actions.appendChild(renameButton);
+                actions.appendChild(deleteButton);
+                item.appendChild(actions);
 
-                const result = await response.text();
+                conversationList.appendChild(item);
+            });
+            highlightCurrentConversation();
+        }
+
+        function beginRenameConversation(item, title, conversation) {
+            const input = document.createElement('input');
+            input.type = 'text';
+            input.value = conversation.title;
+            input.className = 'w-full border rounded px-1 text-sm';
+            item.replaceChild(input, title);
+            input.focus();
+            input.select();
+
+            let finished = false;
+            const finish = async (save) => {
+                if (finished) { return; }
+                finished = true;
+                const newTitle = input.value.trim();
+                if (save && newTitle && newTitle !== conversation.title) {
+                    try {
+                        const response = await fetch('/api/conversations/' + encodeURIComponent(conversation.id), {
+                            method: 'PATCH',
+                            headers: { 'Content-Type': 'application/json' },
+                            body: JSON.stringify({ title: newTitle }),
+                        });
+                        if (!response.ok) {
+                            const errorText = await response.text();
+                            throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                        }
+                    } catch (error) {
+                        showAlert('Failed to rename conversation: ' + error.message);
+                    }
+                }
+                refreshConversations();
+            };
+            input.addEventListener('keydown', (event) => {
+                if (event.key === 'Enter') { finish(true); }
+                if (event.key === 'Escape') { finish(false); }
+            });
+            input.addEventListener('blur', () => finish(true));
+        }
+
+        async function deleteConversation(conversation) {
+            const confirmed = await showConfirm('Delete conversation "' + conversation.title + '"? This action cannot be undone.');
+            if (!confirmed) { return; }
+            try {
+                const response = await fetch('/api/conversations/' + encodeURIComponent(conversation.id), { method: 'DELETE' });
                 if (!response.ok) {
-                    throw new Error("HTTP error! status: " + response.status + ", message: " + result);
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                if (currentConversationId === conversation.id) {
+                    startNewConversation();
                 }
-                modelActionOutput.textContent = 'Pull successful for ' + modelName + ':\n' + result;
-                await fetchAndPopulateModels(); // Refresh installed models list
             } catch (error) {
-                console.error('Error pulling model:', error);
-                let userMessage = 'Failed to pull model ' + modelName + '. Error: ' + error.message;
-                showAlert(userMessage);
-                modelActionOutput.textContent = userMessage;
-            } finally {
-                loadingIndicator.style.display = 'none';
-                pullManualModelButton.disabled = false;
-                pullAvailableModelButton.disabled = false;
-                deleteModelButton.disabled = false;
-                modelSelect.disabled = false;
-                apiTypeSelect.disabled = false;
-                refreshModelsButton.disabled = false;
-                availableModelSelect.disabled = false;
+                showAlert('Failed to delete conversation: ' + error.message);
             }
+            refreshConversations();
         }
 
-        // Event listener for pulling from the "Available Models" dropdown
-        pullAvailableModelButton.addEventListener('click', async () => {
-            const model = availableModelSelect.value;
-            if (!model) {
-                showAlert('Please select a model from the list to pull.');
+        async function openConversation(conversationId) {
+            try {
+                const response = await fetch('/api/conversations/' + encodeURIComponent(conversationId));
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const conversation = await response.json();
+                currentConversationId = conversation.id;
+                chatMessages = [];
+                chatHistoryOutput.innerHTML = '';
+                conversation.messages.forEach(message => {
+                    chatMessages.push({ role: message.role, content: message.content, images: message.images });
+                    appendChatMessage(message.role, message.content, message);
+                });
+                if (conversation.model && Array.from(modelSelect.options).some(option => option.value === conversation.model)) {
+                    modelSelect.value = conversation.model;
+                }
+                highlightCurrentConversation();
+            } catch (error) {
+                showAlert('Failed to open conversation: ' + error.message);
+            }
+        }
+
+        // Creates the server-side conversation for the first message of a new chat.
+        async function ensureConversation(model) {
+            if (currentConversationId) { return currentConversationId; }
+            const response = await fetch('/api/conversations', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ model, backend: backendSelect.value }),
+            });
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+            }
+            const conversation = await response.json();
+            currentConversationId = conversation.id;
+            return currentConversationId;
+        }
+
+        newConversationButton.addEventListener('click', startNewConversation);
+
+        // Event listener for the "Display Thinking Process" checkbox
+        showThinkingCheckbox.addEventListener('change', () => {
+            if (showThinkingCheckbox.checked) {
+                // If already hidden and checked, show it (e.g., if streaming is ongoing)
+                if (thinkingOutput.textContent !== '') { // Only show if there's content
+                    thinkingOutput.classList.remove('hidden');
+                }
+            } else {
+                thinkingOutput.classList.add('hidden');
+            }
+        });
+
+
+        // details may carry the images, usage, citations, toolCalls and thinking of a stored message.
+        function appendChatMessage(role, content, details) {
+            details = details || {};
+            const messageDiv = document.createElement('div');
+            messageDiv.classList.add('chat-message', role);
+            messageDiv.textContent = content;
+            appendImages(messageDiv, details.images);
+            appendToolCalls(messageDiv, details.toolCalls);
+            appendThinking(messageDiv, details.thinking);
+            appendCitations(messageDiv, details.citations);
+            appendUsageLine(messageDiv, details.usage);
+            chatHistoryOutput.appendChild(messageDiv);
+            chatHistoryOutput.scrollTop = chatHistoryOutput.scrollHeight;
+        }
+
+        // Summarizes a usage event as tokens, speed and latency.
+        function formatUsage(usage) {
+            const parts = [usage.promptTokens + ' prompt + ' + usage.completionTokens + ' completion tokens'];
+            if (usage.tokensPerSecond) { parts.push(usage.tokensPerSecond.toFixed(1) + ' tokens/s'); }
+            if (usage.timeToFirstTokenMs) { parts.push('first token ' + Math.round(usage.timeToFirstTokenMs) + ' ms'); }
+            if (usage.totalDurationMs) { parts.push('total ' + (usage.totalDurationMs / 1000).toFixed(2) + ' s'); }
+            return parts.join(' \u00b7 ');
+        }
+
+        function appendUsageLine(messageDiv, usage) {
+            if (!usage) { return; }
+            const usageDiv = document.createElement('div');
+            usageDiv.className = 'usage-line';
+            usageDiv.textContent = formatUsage(usage);
+            messageDiv.appendChild(usageDiv);
+        }
+
+        // Only the new turn carries its images; earlier turns are replayed by the server from the stored conversation.
+        function messagesForRequest() {
+            return chatMessages.map((message, i) => i === chatMessages.length - 1 ? message : { role: message.role, content: message.content });
+        }
+
+        // Validates the selected image files on the server and returns them base64 encoded.
+        async function uploadImages(fileInput) {
+            if (fileInput.files.length === 0) { return []; }
+            const form = new FormData();
+            Array.from(fileInput.files).forEach(file => form.append('image', file));
+            const response = await fetch('/api/images', { method: 'POST', body: form });
+            if (!response.ok) { throw new Error(await response.text()); }
+            const data = await response.json();
+            return data.images.map(image => image.data);
+        }
+
+        // Builds a data: URL for a base64 image, recognising the format from its first bytes.
+        function imageDataURL(data) {
+            let type = 'image/png';
+            if (data.startsWith('/9j/')) { type = 'image/jpeg'; }
+            else if (data.startsWith('R0lG')) { type = 'image/gif'; }
+            else if (data.startsWith('UklG')) { type = 'image/webp'; }
+            return 'data:' + type + ';base64,' + data;
+        }
+
+        function appendImages(messageDiv, images) {
+            if (!images || images.length === 0) { return; }
+            const container = document.createElement('div');
+            images.forEach(data => {
+                const img = document.createElement('img');
+                img.src = imageDataURL(data);
+                img.className = 'image-thumb';
+                container.appendChild(img);
+            });
+            messageDiv.appendChild(container);
+        }
+
+        function renderImagePreview(fileInput, previewId) {
+            const preview = document.getElementById(previewId);
+            preview.innerHTML = '';
+            Array.from(fileInput.files).forEach(file => {
+                const img = document.createElement('img');
+                img.src = URL.createObjectURL(file);
+                img.className = 'image-thumb';
+                img.addEventListener('load', () => URL.revokeObjectURL(img.src));
+                preview.appendChild(img);
+            });
+        }
+
+        generateImages.addEventListener('change', () => renderImagePreview(generateImages, 'generate-image-preview'));
+        chatImages.addEventListener('change', () => renderImagePreview(chatImages, 'chat-image-preview'));
+
+        // Adds the model's reasoning above the answer, collapsed by default.
+        function appendThinking(messageDiv, thinking) {
+            if (!thinking) { return; }
+            const details = document.createElement('details');
+            details.className = 'thinking-block';
+            const summary = document.createElement('summary');
+            summary.textContent = 'Thinking';
+            details.appendChild(summary);
+            const text = document.createElement('div');
+            text.textContent = thinking;
+            details.appendChild(text);
+            messageDiv.insertBefore(details, messageDiv.firstChild);
+        }
+
+        // Shows the tools the model called before answering, above the answer.
+        function appendToolCalls(messageDiv, toolCalls) {
+            if (!toolCalls || toolCalls.length === 0) { return; }
+            const list = document.createElement('div');
+            toolCalls.forEach(call => {
+                const item = document.createElement('div');
+                item.className = 'tool-call';
+                const outcome = call.error ? 'error: ' + call.error : call.result;
+                item.textContent = call.name + '(' + JSON.stringify(call.arguments || {}) + ') \u2192 ' + (outcome.length > 200 ? outcome.substring(0, 200) + '...' : outcome);
+                list.appendChild(item);
+            });
+            messageDiv.insertBefore(list, messageDiv.firstChild);
+        }
+
+        async function fetchTools() {
+            try {
+                const response = await fetch('/api/tools');
+                if (!response.ok) { throw new Error("HTTP error! status: " + response.status); }
+                const data = await response.json();
+                toolOptions.innerHTML = '';
+                (data.tools || []).forEach(tool => {
+                    const label = document.createElement('label');
+                    label.className = 'flex items-center gap-1';
+                    label.title = tool.description;
+                    const checkbox = document.createElement('input');
+                    checkbox.type = 'checkbox';
+                    checkbox.value = tool.name;
+                    label.appendChild(checkbox);
+                    label.appendChild(document.createTextNode(tool.name));
+                    toolOptions.appendChild(label);
+                });
+            } catch (error) {
+                console.error('Error fetching tools:', error);
+            }
+        }
+
+        function selectedTools() {
+            return Array.from(toolOptions.querySelectorAll('input:checked')).map(checkbox => checkbox.value);
+        }
+
+        // Lists the document excerpts an answer was based on, collapsed by default.
+        function appendCitations(messageDiv, citations) {
+            if (!citations || citations.length === 0) { return; }
+            const list = document.createElement('details');
+            list.className = 'citation-list';
+            const summary = document.createElement('summary');
+            summary.textContent = 'Sources: ' + Array.from(new Set(citations.map(c => c.document))).join(', ');
+            list.appendChild(summary);
+            citations.forEach(citation => {
+                const item = document.createElement('div');
+                item.className = 'mt-1';
+                item.textContent = '[' + citation.index + '] ' + citation.document + ', part ' + (citation.chunk + 1) + ' (score ' + citation.score.toFixed(2) + '): ' + citation.text;
+                list.appendChild(item);
+            });
+            messageDiv.appendChild(list);
+        }
+
+        let collectionList = [];
+
+        async function refreshCollections() {
+            try {
+                const response = await fetch('/api/collections');
+                if (!response.ok) { throw new Error("HTTP error! status: " + response.status); }
+                const data = await response.json();
+                collectionList = data.collections || [];
+                const selected = collectionSelect.value;
+                collectionSelect.innerHTML = '<option value="">None</option>';
+                collectionList.forEach(collection => {
+                    const option = document.createElement('option');
+                    option.value = collection.name;
+                    option.textContent = collection.name + ' (' + collection.documents.length + ' docs)';
+                    option.title = collection.description || '';
+                    collectionSelect.appendChild(option);
+                });
+                if (collectionList.some(collection => collection.name === selected)) {
+                    collectionSelect.value = selected;
+                }
+                renderCollectionDocuments();
+            } catch (error) {
+                console.error('Error fetching collections:', error);
+            }
+        }
+
+        function renderCollectionDocuments() {
+            collectionDocuments.innerHTML = '';
+            const collection = collectionList.find(c => c.name === collectionSelect.value);
+            if (!collection) {
+                collectionDocuments.innerHTML = '<p class="text-gray-500 text-xs">Select a collection to see its documents.</p>';
                 return;
             }
-            performPullModel(model);
+            if (collection.documents.length === 0) {
+                collectionDocuments.innerHTML = '<p class="text-gray-500 text-xs">No documents yet. Upload .txt, .md or .pdf files.</p>';
+            }
+            collection.documents.forEach(doc => {
+                const row = document.createElement('div');
+                row.className = 'flex justify-between items-center';
+                const label = document.createElement('span');
+                label.textContent = doc.name + ' \u00b7 ' + formatBytes(doc.size) + ' \u00b7 ' + doc.chunks + ' chunks';
+                const remove = document.createElement('button');
+                remove.textContent = '\u2715';
+                remove.title = 'Remove from collection';
+                remove.className = 'text-gray-500 hover:text-red-600 px-1';
+                remove.addEventListener('click', async () => {
+                    const confirmed = await showConfirm('Remove "' + doc.name + '" from collection "' + collection.name + '"?');
+                    if (!confirmed) { return; }
+                    const response = await fetch('/api/collections/' + encodeURIComponent(collection.name) + '/documents/' + encodeURIComponent(doc.id), { method: 'DELETE' });
+                    if (!response.ok) { showAlert('Failed to remove document: ' + await response.text()); }
+                    refreshCollections();
+                });
+                row.appendChild(label);
+                row.appendChild(remove);
+                collectionDocuments.appendChild(row);
+            });
+        }
+
+        collectionSelect.addEventListener('change', renderCollectionDocuments);
+
+        document.getElementById('manage-collections-button').addEventListener('click', () => {
+            collectionsPanel.classList.toggle('hidden');
+            renderCollectionDocuments();
         });
 
-        // Event listener for pulling from the manual input field
-        pullManualModelButton.addEventListener('click', async () => {
-            const model = modelActionInput.value.trim();
-            if (!model) {
-                showAlert('Please enter a model name in the manual input field.');
+        document.getElementById('create-collection-button').addEventListener('click', async () => {
+            const name = document.getElementById('collection-name-input').value.trim();
+            const embedModel = document.getElementById('collection-model-input').value.trim();
+            const description = document.getElementById('collection-description-input').value.trim();
+            if (!name || !embedModel) { showAlert('Please enter a collection name and an embedding model.'); return; }
+            const response = await fetch('/api/collections', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name, embedModel, description, backend: backendSelect.value }),
+            });
+            if (!response.ok) {
+                showAlert('Failed to create collection: ' + await response.text());
                 return;
             }
-            performPullModel(model);
+            await refreshCollections();
+            collectionSelect.value = name;
+            renderCollectionDocuments();
         });
 
+        document.getElementById('upload-documents-button').addEventListener('click', async () => {
+            const fileInput = document.getElementById('collection-file-input');
+            const name = collectionSelect.value;
+            if (!name) { showAlert('Please select a collection first.'); return; }
+            if (fileInput.files.length === 0) { showAlert('Please choose one or more files.'); return; }
+            const form = new FormData();
+            Array.from(fileInput.files).forEach(file => form.append('file', file));
+            loadingIndicator.style.display = 'block';
+            try {
+                const response = await fetch('/api/collections/' + encodeURIComponent(name) + '/documents', { method: 'POST', body: form });
+                if (!response.ok) { throw new Error(await response.text()); }
+                fileInput.value = '';
+            } catch (error) {
+                showAlert('Failed to upload documents: ' + error.message);
+            } finally {
+                loadingIndicator.style.display = 'none';
+                refreshCollections();
+            }
+        });
 
-        deleteModelButton.addEventListener('click', async () => {
-            let model = modelActionInput.value.trim();
-            if (!model) {
-                model = modelActionSelect.value;
+        // Reads a text/event-stream response and calls onData with every parsed JSON payload until [DONE].
+        async function readSSE(response, onData) {
+            const reader = response.body.getReader();
+            const decoder = new TextDecoder('utf-8');
+            let buffer = '';
+
+            const handleLine = (line) => {
+                if (!line.startsWith('data: ')) { return false; }
+                const data = line.substring(6);
+                if (data === '[DONE]') { return true; }
+                try {
+                    onData(JSON.parse(data));
+                } catch (e) { console.warn('Could not parse JSON chunk:', data, e); }
+                return false;
+            };
+
+            while (true) {
+                const { done, value } = await reader.read();
+                if (done) { break; }
+                buffer += decoder.decode(value, { stream: true });
+                const lines = buffer.split('\n');
+                buffer = lines.pop();
+                for (const line of lines) {
+                    if (handleLine(line)) { reader.cancel(); return; }
+                }
             }
-            if (!model) { showAlert('Please enter or select a model name to delete.'); return; }
+            handleLine(buffer);
+        }
 
-            const confirmed = await showConfirm('Are you sure you want to delete model: ' + model + '? This action cannot be undone.');
-            if (!confirmed) {
+        function formatBytes(bytes) {
+            if (!bytes) { return '0 B'; }
+            const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+            const exponent = Math.min(Math.floor(Math.log(bytes) / Math.log(1024)), units.length - 1);
+            return (bytes / Math.pow(1024, exponent)).toFixed(exponent === 0 ? 0 : 1) + ' ' + units[exponent];
+        }
+
+        function formatDuration(seconds) {
+            if (!seconds || !isFinite(seconds)) { return ''; }
+            seconds = Math.round(seconds);
+            const minutes = Math.floor(seconds / 60);
+            return minutes > 0 ? minutes + 'm ' + (seconds % 60) + 's' : seconds + 's';
+        }
+
+        // Creates or updates the progress bar for one layer of a pull.
+        function renderPullProgress(container, event) {
+            if (!event.digest) { return; }
+            const rowId = 'layer-' + container.id + '-' + event.digest.replace(/[^a-zA-Z0-9]/g, '');
+            let row = document.getElementById(rowId);
+            if (!row) {
+                row = document.createElement('div');
+                row.id = rowId;
+                row.innerHTML = '<div class="flex justify-between text-xs text-gray-600 mb-1"><span class="layer-name"></span><span class="layer-stats"></span></div>' +
+                    '<div class="progress-track"><div class="progress-bar"></div></div>';
+                row.querySelector('.layer-name').textContent = event.digest.substring(0, 19);
+                container.appendChild(row);
+            }
+            const percent = event.percent || 0;
+            row.querySelector('.progress-bar').style.width = percent.toFixed(1) + '%';
+            let stats = formatBytes(event.completed) + ' / ' + formatBytes(event.total) + ' (' + percent.toFixed(1) + '%)';
+            if (event.bytesPerSecond) { stats += ' ' + formatBytes(event.bytesPerSecond) + '/s'; }
+            if (event.etaSeconds) { stats += ', ' + formatDuration(event.etaSeconds) + ' left'; }
+            row.querySelector('.layer-stats').textContent = stats;
+        }
+
+        let attachedJobId = null;
+        let attachedJobController = null;
+        let jobsPollTimer = null;
+
+        // Streams the progress of a background pull or push job into the progress area.
+        async function attachToJob(jobId, jobType = 'pull') {
+            const verb = jobType === 'push' ? 'Push' : 'Pull';
+            if (attachedJobController) { attachedJobController.abort(); }
+            const controller = new AbortController();
+            attachedJobController = controller;
+            attachedJobId = jobId;
+            pullProgress.innerHTML = '';
+            pullProgress.classList.remove('hidden');
+
+            let modelName = '';
+            try {
+                const response = await fetch('/api/jobs/' + encodeURIComponent(jobId) + '/events', { signal: controller.signal });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+
+                let finalEvent = null;
+                await readSSE(response, (event) => {
+                    modelName = event.model;
+                    if (event.state && event.state !== 'queued' && event.state !== 'running') {
+                        finalEvent = event;
+                        return;
+                    }
+                    modelActionOutput.textContent = verb + 'ing model ' + event.model + ': ' + (event.status || event.state);
+                    renderPullProgress(pullProgress, event);
+                });
+                if (finalEvent) {
+                    if (finalEvent.state === 'succeeded') {
+                        modelActionOutput.textContent = verb + ' finished for ' + modelName + ': ' + finalEvent.status;
+                        await fetchAndPopulateModels(); // Refresh installed models list
+                    } else {
+                        modelActionOutput.textContent = verb + ' ' + finalEvent.state + ' for ' + modelName + (finalEvent.error ? ': ' + finalEvent.error : '');
+                    }
+                }
+            } catch (error) {
+                if (error.name === 'AbortError') { return; }
+                console.error('Error following job:', error);
+                modelActionOutput.textContent = 'Lost connection to ' + jobType + ' job ' + jobId + ': ' + error.message;
+            } finally {
+                if (attachedJobController === controller) {
+                    attachedJobController = null;
+                    attachedJobId = null;
+                }
+                refreshJobs();
+            }
+        }
+
+        async function jobAction(jobId, action) {
+            try {
+                const response = await fetch('/api/jobs/' + encodeURIComponent(jobId) + '/' + action, { method: 'POST' });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                if (action === 'resume') { attachToJob(jobId, (await response.json()).type); }
+            } catch (error) {
+                showAlert('Failed to ' + action + ' job: ' + error.message);
+            }
+            refreshJobs();
+        }
+
+        function renderJobs(jobList) {
+            jobsList.innerHTML = '';
+            if (jobList.length === 0) {
+                jobsList.textContent = 'No jobs yet.';
                 return;
             }
+            jobList.forEach(job => {
+                const row = document.createElement('div');
+                row.className = 'flex items-center justify-between bg-white border border-gray-200 rounded-lg px-3 py-2';
+
+                let completed = 0, total = 0;
+                Object.values(job.layers || {}).forEach(layer => {
+                    completed += layer.completed || 0;
+                    total += layer.total || 0;
+                });
+                let details = job.type + ' ' + job.state;
+                if (job.state === 'running' && total > 0) {
+                    details += ' ' + (completed * 100 / total).toFixed(1) + '%';
+                }
+                if (job.error) { details += ': ' + job.error; }
+
+                const label = document.createElement('div');
+                label.innerHTML = '<span class="font-medium text-gray-800"></span> <span class="text-gray-500"></span><div class="text-xs text-gray-600"></div>';
+                label.children[0].textContent = job.model;
+                label.children[1].textContent = '@' + job.backend;
+                label.children[2].textContent = details;
+                row.appendChild(label);
+
+                const actions = document.createElement('div');
+                actions.className = 'flex space-x-2';
+                const addButton = (text, classes, onClick) => {
+                    const button = document.createElement('button');
+                    button.textContent = text;
+                    button.className = classes + ' text-white text-xs font-bold py-1 px-2 rounded';
+                    button.addEventListener('click', onClick);
+                    actions.appendChild(button);
+                };
+                if (job.state === 'queued' || job.state === 'running') {
+                    if (attachedJobId !== job.id) {
+                        addButton('Show Progress', 'bg-blue-600 hover:bg-blue-700', () => attachToJob(job.id, job.type));
+                    }
+                    addButton('Cancel', 'bg-red-600 hover:bg-red-700', () => jobAction(job.id, 'cancel'));
+                }
+                if (job.state === 'failed' || job.state === 'cancelled') {
+                    addButton('Resume', 'bg-green-600 hover:bg-green-700', () => jobAction(job.id, 'resume'));
+                }
+                row.appendChild(actions);
+                jobsList.appendChild(row);
+            });
+        }
 
-            modelActionOutput.textContent = 'Deleting model ' + model + '...';
-            loadingIndicator.style.display = 'block';
-            pullManualModelButton.disabled = true;
-            pullAvailableModelButton.disabled = true; // Disable this too during delete
-            deleteModelButton.disabled = true;
-            modelSelect.disabled = true;
-            apiTypeSelect.disabled = true;
-            refreshModelsButton.disabled = true;
-            availableModelSelect.disabled = true; // Disable this too during delete
+        async function refreshJobs() {
+            try {
+                const response = await fetch('/api/jobs');
+                if (!response.ok) { throw new Error("HTTP error! status: " + response.status); }
+                const data = await response.json();
+                renderJobs(data.jobs || []);
+            } catch (error) {
+                console.error('Error fetching jobs:', error);
+            }
+        }
 
+        // Unified pull function for both manual input and dropdown selection; pushes use it too.
+        // The transfer runs as a background job, so it survives closing or reloading the page.
+        async function performPullModel(modelName, jobType = 'pull') {
+            modelActionOutput.textContent = 'Queueing ' + jobType + ' of ' + modelName + '...';
             try {
-                const response = await fetch('/api/ollama-action', {
+                const response = await fetch('/api/jobs', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ actionType: 'delete', model }),
+                    body: JSON.stringify({ type: jobType, model: modelName, backend: backendSelect.value }),
                 });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                const job = await response.json();
+                await refreshJobs();
+                attachToJob(job.id, jobType);
+            } catch (error) {
+                console.error('Error starting ' + jobType + ':', error);
+                let userMessage = 'Failed to ' + jobType + ' model ' + modelName + '. Error: ' + error.message;
+                showAlert(userMessage);
+                modelActionOutput.textContent = userMessage;
+            }
+        }
 
-                const result = await response.text();
+        refreshJobsButton.addEventListener('click', refreshJobs);
+
+        // Copies or renames a model through the copy and rename actions.
+        async function copyModel(source, destination, actionType) {
+            modelActionOutput.textContent = (actionType === 'rename' ? 'Renaming ' : 'Copying ') + source + ' to ' + destination + '...';
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ actionType, model: source, destination, backend: backendSelect.value }),
+                });
                 if (!response.ok) {
-                    throw new Error("HTTP error! status: " + response.status + ", message: " + result);
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
                 }
-                modelActionOutput.textContent = 'Delete successful for ' + model + ':\n' + result;
+                const result = await response.json();
+                modelActionOutput.textContent = 'Model ' + result.source + ' ' + result.status + ' to ' + result.destination + '.';
                 await fetchAndPopulateModels();
             } catch (error) {
-                console.error('Error deleting model:', error);
-                let userMessage = 'Failed to delete model ' + model + '. Error: ' + error.message;
+                console.error('Error copying model:', error);
+                const userMessage = 'Failed to ' + actionType + ' model ' + source + '. Error: ' + error.message;
                 showAlert(userMessage);
                 modelActionOutput.textContent = userMessage;
-            } finally {
-                loadingIndicator.style.display = 'none';
-                pullManualModelButton.disabled = false;
-                pullAvailableModelButton.disabled = false; // Re-enable
-                deleteModelButton.disabled = false;
-                modelSelect.disabled = false;
-                apiTypeSelect.disabled = false;
-                refreshModelsButton.disabled = false;
-                availableModelSelect.disabled = false; // Re-enable
             }
+        }
+
+        document.getElementById('copy-model-button').addEventListener('click', () => {
+            const destination = document.getElementById('copy-destination').value.trim();
+            if (!modelActionSelect.value || !destination) { showAlert('Select a model in the table and enter a new name.'); return; }
+            copyModel(modelActionSelect.value, destination, 'copy');
         });
 
-    </script>
-</body>
-</html>
-`)
-}
+        document.getElementById('rename-model-button').addEventListener('click', () => {
+            const destination = document.getElementById('copy-destination').value.trim();
+            if (!modelActionSelect.value || !destination) { showAlert('Select a model in the table and enter a new name.'); return; }
+            copyModel(modelActionSelect.value, destination, 'rename');
+        });
 
-// handleOllamaAction is a unified handler for all Ollama API interactions.
-func handleOllamaAction(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+        document.getElementById('set-default-model-button').addEventListener('click', async () => {
+            const source = modelActionSelect.value;
+            if (!source) { showAlert('Select a model in the table first.'); return; }
+            const confirmed = await showConfirm('Point the team default ' + teamDefaultModel + ' at ' + source + '?');
+            if (confirmed) { copyModel(source, teamDefaultModel, 'copy'); }
+        });
+
+        // Builds a create action from the Create Model form. Parameters are
+        // "name value" lines; repeated stop lines collect into a list.
+        function createModelRequest(preview) {
+            const options = {};
+            const problems = [];
+            document.getElementById('create-model-parameters').value.split('\n').map(line => line.trim()).filter(line => line).forEach(line => {
+                const space = line.search(/\s/);
+                if (space < 0) { problems.push('Parameter "' + line + '" has no value'); return; }
+                const name = line.substring(0, space);
+                let value = line.substring(space).trim();
+                if (name === 'stop') {
+                    if (value.startsWith('"') && value.endsWith('"') && value.length > 1) { value = value.slice(1, -1); }
+                    (options.stop = options.stop || []).push(value);
+                } else if (isNaN(Number(value))) {
+                    problems.push('Parameter ' + name + ' needs a number');
+                } else {
+                    options[name] = Number(value);
+                }
+            });
+            const adapters = {};
+            document.getElementById('create-model-adapters').value.split('\n').map(line => line.trim()).filter(line => line).forEach(line => {
+                const parts = line.split(/\s+/);
+                if (parts.length !== 2) { problems.push('Adapter line "' + line + '" needs a file name and a digest'); return; }
+                adapters[parts[0]] = parts[1];
+            });
+            if (problems.length) { throw new Error(problems.join('\n')); }
+            return {
+                actionType: 'create',
+                backend: backendSelect.value,
+                model: document.getElementById('create-model-name').value.trim(),
+                from: document.getElementById('create-model-from').value.trim(),
+                system: document.getElementById('create-model-system').value,
+                template: document.getElementById('create-model-template').value,
+                options: Object.keys(options).length ? options : undefined,
+                adapters: Object.keys(adapters).length ? adapters : undefined,
+                preview,
+            };
+        }
+
+        document.getElementById('preview-modelfile-button').addEventListener('click', async () => {
+            const previewOutput = document.getElementById('modelfile-preview');
+            previewOutput.classList.remove('hidden');
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(createModelRequest(true)),
+                });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(errorText);
+                }
+                previewOutput.textContent = (await response.json()).modelfile;
+            } catch (error) {
+                previewOutput.textContent = 'Invalid model definition:\n' + error.message;
+            }
+        });
+
+        document.getElementById('create-model-button').addEventListener('click', async () => {
+            const createButton = document.getElementById('create-model-button');
+            let request;
+            try {
+                request = createModelRequest(false);
+            } catch (error) {
+                showAlert(error.message);
+                return;
+            }
+            if (!request.model || !request.from) { showAlert('Please enter a name and a base model.'); return; }
+
+            modelActionOutput.textContent = 'Creating model ' + request.model + '...';
+            pullProgress.innerHTML = '';
+            pullProgress.classList.remove('hidden');
+            createButton.disabled = true;
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(request),
+                });
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + errorText);
+                }
+                let failure = null;
+                await readSSE(response, (event) => {
+                    if (event.error) { failure = event.error; return; }
+                    modelActionOutput.textContent = 'Creating model ' + request.model + ': ' + event.status;
+                    renderPullProgress(pullProgress, event);
+                });
+                if (failure) { throw new Error(failure); }
+                modelActionOutput.textContent = 'Created model ' + request.model + '.';
+                await fetchAndPopulateModels();
+            } catch (error) {
+                console.error('Error creating model:', error);
+                modelActionOutput.textContent = 'Failed to create model ' + request.model + '. Error: ' + error.message;
+            } finally {
+                createButton.disabled = false;
+            }
+        });
+
+        // Event listener for pulling from the "Available Models" dropdown
+        pullAvailableModelButton.addEventListener('click', async () => {
+            const model = availableModelSelect.value;
+            if (!model) {
+                showAlert('Please select a model from the list to pull.');
+                return;
+            }
+            performPullModel(model);
+        });
+
+        // Event listener for pulling from the manual input field
+        pullManualModelButton.addEventListener('click', async () => {
+            const model = modelActionInput.value.trim();
+            if (!model) {
+                showAlert('Please enter a model name in the manual input field.');
+                return;
+            }
+            performPullModel(model);
+        });
+
+
+        // Pushes the manually entered or selected model, e.g. registry.internal:5000/team/reviewer
+        document.getElementById('push-model-button').addEventListener('click', () => {
+            const model = modelActionInput.value.trim() || modelActionSelect.value;
+            if (!model) {
+                showAlert('Please enter or select a model name to push.');
+                return;
+            }
+            performPullModel(model, 'push');
+        });
+
+        deleteModelButton.addEventListener('click', async () => {
+            let model = modelActionInput.value.trim();
+            if (!model) {
+                model = modelActionSelect.value;
+            }
+            if (!model) { showAlert('Please enter or select a model name to delete.'); return; }
+
+            const confirmed = await showConfirm('Are you sure you want to delete model: ' + model + '? This action cannot be undone.');
+            if (!confirmed) {
+                return;
+            }
+
+            modelActionOutput.textContent = 'Deleting model ' + model + '...';
+            loadingIndicator.style.display = 'block';
+            pullManualModelButton.disabled = true;
+            pullAvailableModelButton.disabled = true; // Disable this too during delete
+            deleteModelButton.disabled = true;
+            modelSelect.disabled = true;
+            apiTypeSelect.disabled = true;
+            backendSelect.disabled = true;
+            refreshModelsButton.disabled = true;
+            availableModelSelect.disabled = true; // Disable this too during delete
+
+            try {
+                const response = await fetch('/api/ollama-action', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ actionType: 'delete', model, backend: backendSelect.value }),
+                });
+
+                const result = await response.text();
+                if (!response.ok) {
+                    throw new Error("HTTP error! status: " + response.status + ", message: " + result);
+                }
+                modelActionOutput.textContent = 'Delete successful for ' + model + ':\n' + result;
+                await fetchAndPopulateModels();
+            } catch (error) {
+                console.error('Error deleting model:', error);
+                let userMessage = 'Failed to delete model ' + model + '. Error: ' + error.message;
+                showAlert(userMessage);
+                modelActionOutput.textContent = userMessage;
+            } finally {
+                loadingIndicator.style.display = 'none';
+                pullManualModelButton.disabled = false;
+                pullAvailableModelButton.disabled = false; // Re-enable
+                deleteModelButton.disabled = false;
+                modelSelect.disabled = false;
+                apiTypeSelect.disabled = false;
+                backendSelect.disabled = false;
+                refreshModelsButton.disabled = false;
+                availableModelSelect.disabled = false; // Re-enable
+            }
+        });
+
+    </script>
+</body>
+</html>
+`)
+}
+
+// serveLoginHTML serves the login page.
+func serveLoginHTML(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign in - Ollama Go Web UI</title>
+    <script src="https://cdn.tailwindcss.com"></script>
+    <link href="https://fonts.googleapis.com/css2?family=Inter:wght@400;500;600;700&display=swap" rel="stylesheet">
+    <style>
+        body {
+            font-family: 'Inter', sans-serif;
+            background-color: #f3f4f6;
+        }
+    </style>
+</head>
+<body class="bg-gray-100 flex items-center justify-center min-h-screen p-4">
+    <form id="login-form" class="bg-white rounded-xl shadow-lg p-8 w-full max-w-sm">
+        <h1 class="text-2xl font-extrabold text-center text-gray-900 mb-6">Ollama Go Web UI</h1>
+        <label for="username" class="block text-gray-700 text-sm font-medium mb-2">Username:</label>
+        <input id="username" autocomplete="username" class="shadow-sm border rounded-lg w-full py-2 px-3 text-gray-700 mb-4 focus:outline-none focus:ring-2 focus:ring-indigo-500">
+        <label for="password" class="block text-gray-700 text-sm font-medium mb-2">Password:</label>
+        <input id="password" type="password" autocomplete="current-password" class="shadow-sm border rounded-lg w-full py-2 px-3 text-gray-700 mb-4 focus:outline-none focus:ring-2 focus:ring-indigo-500">
+        <p id="login-error" class="hidden text-red-600 text-sm mb-4"></p>
+        <button type="submit" class="w-full bg-indigo-600 hover:bg-indigo-700 text-white font-semibold py-2 px-4 rounded-lg">Sign in</button>
+    </form>
+    <script>
+        document.getElementById('login-form').addEventListener('submit', async (event) => {
+            event.preventDefault();
+            const errorLabel = document.getElementById('login-error');
+            errorLabel.classList.add('hidden');
+            const response = await fetch('/api/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    username: document.getElementById('username').value,
+                    password: document.getElementById('password').value
+                })
+            });
+            if (response.ok) {
+                window.location.href = '/';
+                return;
+            }
+            errorLabel.textContent = (await response.text()).trim();
+            errorLabel.classList.remove('hidden');
+        });
+    </script>
+</body>
+</html>
+`)
+}
+
+// serveAdminHTML serves the admin page for managing users and API keys.
+func serveAdminHTML(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermManageUsers, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Admin - Ollama Go Web UI</title>
+    <script src="https://cdn.tailwindcss.com"></script>
+    <link href="https://fonts.googleapis.com/css2?family=Inter:wght@400;500;600;700&display=swap" rel="stylesheet">
+    <style>
+        body {
+            font-family: 'Inter', sans-serif;
+            background-color: #f3f4f6;
+        }
+        .container {
+            max-width: 900px;
+            margin: 2rem auto;
+            padding: 2rem;
+            background-color: #ffffff;
+            border-radius: 12px;
+            box-shadow: 0 4px 12px rgba(0, 0, 0, 0.08);
+        }
+        th, td {
+            padding: 0.4rem 0.6rem;
+            text-align: left;
+        }
+    </style>
+</head>
+<body class="bg-gray-100 min-h-screen p-4">
+    <div class="container w-full">
+        <div class="flex justify-between items-center mb-6">
+            <h1 class="text-3xl font-extrabold text-gray-900">Administration</h1>
+            <a href="/" class="text-indigo-600 hover:underline text-sm">Back to the UI</a>
+        </div>
+
+        <h2 class="text-xl font-semibold text-gray-800 mb-3">Users</h2>
+        <table class="w-full text-sm mb-4">
+            <thead class="bg-gray-100"><tr><th>Username</th><th>Role</th><th>Source</th><th></th></tr></thead>
+            <tbody id="users-body"></tbody>
+        </table>
+        <form id="user-form" class="flex flex-wrap gap-2 mb-8">
+            <input id="new-username" placeholder="Username" class="border rounded-lg py-1 px-2 text-sm">
+            <input id="new-password" type="password" placeholder="Password" autocomplete="new-password" class="border rounded-lg py-1 px-2 text-sm">
+            <select id="new-role" class="border rounded-lg py-1 px-2 text-sm">
+                <option value="viewer">viewer</option>
+                <option value="user" selected>user</option>
+                <option value="model-admin">model-admin</option>
+                <option value="admin">admin</option>
+            </select>
+            <button type="submit" class="bg-indigo-600 hover:bg-indigo-700 text-white font-semibold py-1 px-3 rounded-lg text-sm">Add user</button>
+        </form>
+
+        <h2 class="text-xl font-semibold text-gray-800 mb-3">API Keys</h2>
+        <table class="w-full text-sm mb-4">
+            <thead class="bg-gray-100"><tr><th>Name</th><th>User</th><th>Key</th><th>Created</th><th>Last used</th><th></th></tr></thead>
+            <tbody id="keys-body"></tbody>
+        </table>
+        <form id="key-form" class="flex flex-wrap gap-2 mb-4">
+            <input id="key-name" placeholder="Key name" class="border rounded-lg py-1 px-2 text-sm">
+            <select id="key-user" class="border rounded-lg py-1 px-2 text-sm"></select>
+            <button type="submit" class="bg-indigo-600 hover:bg-indigo-700 text-white font-semibold py-1 px-3 rounded-lg text-sm">Issue key</button>
+        </form>
+        <div id="new-key" class="hidden bg-yellow-50 border border-yellow-300 rounded-lg p-3 text-sm">
+            Copy this key now, it will not be shown again:
+            <code id="new-key-value" class="block bg-gray-100 rounded px-2 py-1 mt-2 break-all"></code>
+        </div>
+        <p id="admin-error" class="hidden text-red-600 text-sm mt-4"></p>
+    </div>
+    <script>
+        const errorLabel = document.getElementById('admin-error');
+
+        function showError(message) {
+            errorLabel.textContent = message;
+            errorLabel.classList.toggle('hidden', !message);
+        }
+
+        function formatTime(value) {
+            return value ? new Date(value).toLocaleString() : '-';
+        }
+
+        function cell(row, text) {
+            const td = document.createElement('td');
+            td.textContent = text;
+            row.appendChild(td);
+            return td;
+        }
+
+        function actionButton(label, onClick) {
+            const button = document.createElement('button');
+            button.textContent = label;
+            button.className = 'text-red-600 hover:underline';
+            button.addEventListener('click', onClick);
+            return button;
+        }
+
+        async function request(method, url, body) {
+            const response = await fetch(url, {
+                method: method,
+                headers: { 'Content-Type': 'application/json' },
+                body: body ? JSON.stringify(body) : undefined
+            });
+            if (!response.ok) {
+                throw new Error((await response.text()).trim());
+            }
+            return response.status === 204 ? null : response.json();
+        }
+
+        async function refreshUsers() {
+            const data = await request('GET', '/api/admin/users');
+            const body = document.getElementById('users-body');
+            const keyUser = document.getElementById('key-user');
+            body.innerHTML = '';
+            keyUser.innerHTML = '';
+            data.users.forEach(user => {
+                const row = document.createElement('tr');
+                row.className = 'border-b';
+                cell(row, user.username);
+                cell(row, user.role);
+                cell(row, user.source);
+                const actions = cell(row, '');
+                if (user.source === 'local') {
+                    actions.appendChild(actionButton('Delete', async () => {
+                        if (!confirm('Delete user ' + user.username + ' and revoke their keys?')) return;
+                        try {
+                            await request('DELETE', '/api/admin/users/' + encodeURIComponent(user.username));
+                            showError('');
+                            refreshAll();
+                        } catch (error) {
+                            showError(error.message);
+                        }
+                    }));
+                }
+                body.appendChild(row);
+                const option = document.createElement('option');
+                option.value = user.username;
+                option.textContent = user.username;
+                keyUser.appendChild(option);
+            });
+        }
+
+        async function refreshKeys() {
+            const data = await request('GET', '/api/admin/keys');
+            const body = document.getElementById('keys-body');
+            body.innerHTML = '';
+            data.keys.forEach(key => {
+                const row = document.createElement('tr');
+                row.className = 'border-b' + (key.revokedAt ? ' text-gray-400 line-through' : '');
+                cell(row, key.name);
+                cell(row, key.username);
+                cell(row, key.prefix + '...');
+                cell(row, formatTime(key.createdAt));
+                cell(row, formatTime(key.lastUsedAt));
+                const actions = cell(row, '');
+                if (!key.revokedAt) {
+                    actions.appendChild(actionButton('Revoke', async () => {
+                        if (!confirm('Revoke key ' + key.name + '?')) return;
+                        try {
+                            await request('DELETE', '/api/admin/keys/' + encodeURIComponent(key.id));
+                            showError('');
+                            refreshKeys();
+                        } catch (error) {
+                            showError(error.message);
+                        }
+                    }));
+                }
+                body.appendChild(row);
+            });
+        }
+
+        function refreshAll() {
+            refreshUsers().catch(error => showError(error.message));
+            refreshKeys().catch(error => showError(error.message));
+        }
+
+        document.getElementById('user-form').addEventListener('submit', async (event) => {
+            event.preventDefault();
+            try {
+                await request('POST', '/api/admin/users', {
+                    username: document.getElementById('new-username').value,
+                    password: document.getElementById('new-password').value,
+                    role: document.getElementById('new-role').value
+                });
+                document.getElementById('new-username').value = '';
+                document.getElementById('new-password').value = '';
+                showError('');
+                refreshUsers();
+            } catch (error) {
+                showError(error.message);
+            }
+        });
+
+        document.getElementById('key-form').addEventListener('submit', async (event) => {
+            event.preventDefault();
+            try {
+                const data = await request('POST', '/api/admin/keys', {
+                    name: document.getElementById('key-name').value,
+                    username: document.getElementById('key-user').value
+                });
+                document.getElementById('key-name').value = '';
+                document.getElementById('new-key-value').textContent = data.key;
+                document.getElementById('new-key').classList.remove('hidden');
+                showError('');
+                refreshKeys();
+            } catch (error) {
+                showError(error.message);
+            }
+        });
+
+        refreshAll();
+    </script>
+</body>
+</html>
+`)
+}
+
+// handleOllamaAction is a unified handler for all Ollama API interactions.
+func handleOllamaAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var clientReq ClientRequest
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { recordRequest(clientReq.ActionType, clientReq.Model, rec.status) }()
+
+	if err := json.NewDecoder(r.Body).Decode(&clientReq); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	runAction(w, r, clientReq)
+}
+
+// runAction checks permissions, selects the backend and performs an action.
+func runAction(w http.ResponseWriter, r *http.Request, clientReq ClientRequest) {
+	if perm, known := actionPermissions[clientReq.ActionType]; known && !authorize(w, r, perm, clientReq.Model) {
+		return
+	}
+
+	backend, ok := resolveBackend(w, clientReq.Backend)
+	if !ok {
+		return
+	}
+	client := backend.Client(300 * time.Second) // Long timeout for LLM operations
+
+	if clientReq.ActionType == "generate" || clientReq.ActionType == "chat" || clientReq.ActionType == "structured" {
+		if err := prepareRequestImages(&clientReq); err != nil {
+			http.Error(w, "Invalid images: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch clientReq.ActionType {
+	case "generate":
+		callGenerateAPI(newSSEOutput(w, r, clientReq), r, clientReq, backend, client)
+	case "chat":
+		callChatAPI(newSSEOutput(w, r, clientReq), r, clientReq, backend, client)
+	case "structured":
+		callStructuredAPI(w, r, clientReq, backend, client)
+	case "embed":
+		callEmbedAPI(w, r, clientReq, backend, client)
+	case "pull":
+		callModelPullAPI(w, r, clientReq, backend)
+	case "push":
+		callModelPushAPI(w, r, clientReq, backend)
+	case "delete":
+		callModelDeleteAPI(w, r, clientReq, backend, client)
+	case "load":
+		callModelLoadAPI(w, r, clientReq, backend, client, false)
+	case "unload":
+		callModelLoadAPI(w, r, clientReq, backend, client, true)
+	case "create":
+		callModelCreateAPI(w, r, clientReq, backend, client)
+	case "copy":
+		callModelCopyAPI(w, r, clientReq, backend, client, false)
+	case "rename":
+		callModelCopyAPI(w, r, clientReq, backend, client, true)
+	default:
+		http.Error(w, "Unknown action type: "+clientReq.ActionType, http.StatusBadRequest)
+	}
+}
+
+// generationOutput writes what callGenerateAPI and callChatAPI produce in the
+// wire format of the API the client called: the SSE events of
+// /api/ollama-action (sseOutput) or OpenAI completions (openAIOutput).
+type generationOutput interface {
+	action() string // Label of the stream in metrics
+	header() http.Header
+	fail(status int, message string)                      // A request error, before anything was written
+	refuse(permErr *PermissionError)                      // A permission error, before anything was written
+	failUpstream(backend *Backend, api string, err error) // Ollama refused the request or could not be reached
+	start() bool                                          // Ollama accepted the request; false if the response cannot stream
+	citations(citations []Citation)
+	delta(thinking, content string, raw []byte) // raw is the Ollama chunk line, for generate only
+	tool(event string, inv ToolInvocation)      // "tool_call" or "tool_result"
+	finish(final OllamaResponseChunk, usage *GenerationUsage)
+	streamError(message string) // A failure after start
+	cancelled()                 // The generation was cancelled after start
+}
+
+// sseOutput streams a generation as the SSE events of /api/ollama-action.
+type sseOutput struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	name    string // "generate" or "chat"
+	model   string
+	flusher http.Flusher
+}
+
+func newSSEOutput(w http.ResponseWriter, r *http.Request, clientReq ClientRequest) *sseOutput {
+	return &sseOutput{w: w, r: r, name: clientReq.ActionType, model: clientReq.Model}
+}
+
+func (o *sseOutput) action() string      { return o.name }
+func (o *sseOutput) header() http.Header { return o.w.Header() }
+
+func (o *sseOutput) fail(status int, message string) { http.Error(o.w, message, status) }
+
+func (o *sseOutput) refuse(permErr *PermissionError) { writePermissionError(o.w, permErr) }
+
+func (o *sseOutput) failUpstream(backend *Backend, api string, err error) {
+	writeUpstreamError(o.w, backend, api, err)
+}
+
+func (o *sseOutput) start() bool {
+	// Set headers for Server-Sent Events (SSE)
+	o.w.Header().Set("Content-Type", "text/event-stream")
+	o.w.Header().Set("Cache-Control", "no-cache")
+	o.w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := o.w.(http.Flusher)
+	if !ok {
+		log.Printf("Streaming not supported by this connection for %s API.", o.name)
+		return false
+	}
+	o.flusher = flusher
+	return true
+}
+
+func (o *sseOutput) citations(citations []Citation) {
+	if data, err := json.Marshal(citations); err == nil {
+		fmt.Fprintf(o.w, "event: citations\ndata: %s\n\n", data)
+		o.flusher.Flush()
+	}
+}
+
+// delta forwards a generate chunk as is, and chat text as "thinking" and "content" events.
+func (o *sseOutput) delta(thinking, content string, raw []byte) {
+	if raw != nil {
+		fmt.Fprintf(o.w, "data: %s\n\n", raw)
+	}
+	if raw == nil && thinking != "" {
+		writeChatDelta(o.w, "thinking", o.model, Message{Role: "assistant", Thinking: thinking})
+	}
+	if raw == nil && content != "" {
+		writeChatDelta(o.w, "content", o.model, Message{Role: "assistant", Content: content})
+	}
+	o.flusher.Flush()
+}
+
+func (o *sseOutput) tool(event string, inv ToolInvocation) {
+	writeToolEvent(o.w, event, inv)
+	o.flusher.Flush()
+}
+
+func (o *sseOutput) finish(final OllamaResponseChunk, usage *GenerationUsage) {
+	writeUsageEvent(o.w, usage)
+	fmt.Fprintf(o.w, "data: [DONE]\n\n")
+	o.flusher.Flush()
+}
+
+func (o *sseOutput) streamError(message string) { writeStreamError(o.w, o.flusher, message) }
+
+func (o *sseOutput) cancelled() { finishCancelledStream(o.w, o.r, o.flusher) }
+
+// callGenerateAPI handles the /api/generate endpoint
+func callGenerateAPI(out generationOutput, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client) {
+	ollamaReq, err := buildGeneratePayload(clientReq)
+	if err != nil {
+		out.fail(http.StatusBadRequest, "Invalid generation parameters: "+err.Error())
+		return
+	}
+	// The upstream request lives as long as the client connection, unless it is cancelled through /api/cancel.
+	ctx, generationID, finish := generations.Start(r.Context(), identityFrom(r.Context()).Username)
+	defer finish()
+	out.header().Set("X-Generation-Id", generationID)
+
+	stats := trackStream(out.action(), clientReq.Model)
+	defer stats.Done()
+
+	resp, err := startOllamaStream(ctx, backend, client, ollamaGeneratePath, ollamaReq)
+	if err != nil {
+		out.failUpstream(backend, "generate", err)
+		return
+	}
+	defer resp.Body.Close()
+	if !out.start() {
+		return
+	}
+
+	scanner := newStreamScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk OllamaResponseChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			log.Printf("Error unmarshalling Ollama generate response chunk: %v, line: %s", err, line)
+			continue
+		}
+		usage := stats.Chunk(chunk)
+
+		// The final chunk is forwarded too, since it carries the context for follow-up prompts
+		if chunk.Response != "" || chunk.Done {
+			out.delta("", chunk.Response, line)
+		}
+
+		if chunk.Done {
+			usageLog.Record(identityFrom(r.Context()).Username, clientReq.Model, "", *usage)
+			out.finish(chunk, usage)
+			return
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("Generate %s cancelled: %v", generationID, context.Cause(ctx))
+		out.cancelled()
+		return
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF // The stream ended without a final chunk
+	}
+	log.Printf("Error reading Ollama generate response stream: %v", err)
+	out.streamError("Error reading Ollama response: " + err.Error())
+}
+
+// callChatAPI handles the /api/chat endpoint
+func callChatAPI(out generationOutput, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client) {
+	identity := identityFrom(r.Context())
+	// In a stored conversation the history comes from the store and the request only adds the new user turn.
+	var userTurn *ConversationMessage
+	if clientReq.ConversationID != "" {
+		history, turn, err := conversations.PrepareTurn(clientReq.ConversationID, identity.Username, clientReq.Messages)
+		if err != nil {
+			out.fail(conversationErrorStatus(err), "Conversation error: "+err.Error())
+			return
+		}
+		clientReq.Messages = history
+		userTurn = &turn
+	}
+
+	ollamaReq, err := buildChatPayload(clientReq)
+	if err != nil {
+		out.fail(http.StatusBadRequest, "Invalid generation parameters: "+err.Error())
+		return
+	}
+	if len(clientReq.Tools) > 0 {
+		ollamaReq.Tools, err = tools.Definitions(clientReq.Tools)
+		if err != nil {
+			out.fail(http.StatusBadRequest, "Invalid tools: "+err.Error())
+			return
+		}
+	}
+	// The upstream request lives as long as the client connection, unless it is cancelled through /api/cancel.
+	ctx, generationID, finish := generations.Start(r.Context(), identity.Username)
+	defer finish()
+	out.header().Set("X-Generation-Id", generationID)
+
+	// With a collection, the chunks most similar to the question are added as system context.
+	var citations []Citation
+	if clientReq.Collection != "" {
+		embedModel, _, err := collections.embedSettings(clientReq.Collection)
+		if err != nil {
+			out.fail(collectionErrorStatus(err), "Collection error: "+err.Error())
+			return
+		}
+		if permErr := authStore.Authorize(identity, PermUseModels, embedModel); permErr != nil {
+			log.Printf("Refused %s for user %q: %v", r.URL.Path, identity.Username, permErr)
+			out.refuse(permErr)
+			return
+		}
+		if len(ollamaReq.Messages) == 0 {
+			out.fail(http.StatusBadRequest, "A question is required when a collection is used")
+			return
+		}
+		question := ollamaReq.Messages[len(ollamaReq.Messages)-1]
+		if question.Role != "user" || strings.TrimSpace(question.Content) == "" {
+			out.fail(http.StatusBadRequest, "The last message must be the user's question when a collection is used")
+			return
+		}
+		citations, err = collections.Search(ctx, clientReq.Collection, question.Content, clientReq.TopK)
+		if err != nil {
+			out.fail(collectionErrorStatus(err), "Error searching collection: "+err.Error())
+			return
+		}
+		if len(citations) > 0 {
+			ollamaReq.Messages = injectRetrievalContext(ollamaReq.Messages, citations)
+			citations = trimCitations(citations)
+		}
+	}
+
+	// Later rounds of a tool-calling turn replace stats and resp.
+	stats := trackStream(out.action(), clientReq.Model)
+	defer func() { stats.Done() }()
+
+	resp, err := startOllamaStream(ctx, backend, client, ollamaChatPath, ollamaReq)
+	if err != nil {
+		out.failUpstream(backend, "chat", err)
+		return
+	}
+	defer func() { resp.Body.Close() }()
+
+	// Ollama accepted the turn, so record it together with whatever reply gets streamed back.
+	var reply, thinking strings.Builder
+	var replyUsage *GenerationUsage
+	var toolLog []ToolInvocation
+	if userTurn != nil {
+		if err := conversations.Append(clientReq.ConversationID, *userTurn); err != nil {
+			log.Printf("Error storing user turn in conversation %s: %v", clientReq.ConversationID, err)
+		}
+		defer func() {
+			if reply.Len() == 0 && thinking.Len() == 0 {
+				return
+			}
+			assistantTurn := ConversationMessage{Role: "assistant", Content: reply.String(), Thinking: thinking.String(), CreatedAt: time.Now(), Usage: replyUsage, Citations: citations, ToolCalls: toolLog}
+			if err := conversations.Append(clientReq.ConversationID, assistantTurn); err != nil {
+				log.Printf("Error storing assistant reply in conversation %s: %v", clientReq.ConversationID, err)
+			}
+		}()
+	}
+
+	if !out.start() {
+		return
+	}
+	if len(citations) > 0 {
+		out.citations(citations)
+	}
+
+	// With tools, the model may answer with tool calls instead of text. They are
+	// run here and their results sent back until the model gives a final answer.
+	for round := 1; ; round++ {
+		result, err := relayChatStream(out, resp.Body, stats)
+		reply.WriteString(result.content)
+		thinking.WriteString(result.thinking)
+		if err == nil && result.usage == nil {
+			err = io.ErrUnexpectedEOF // The stream ended without a final chunk
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Chat %s cancelled: %v", generationID, context.Cause(ctx))
+				out.cancelled()
+				return
+			}
+			log.Printf("Error reading Ollama chat response stream: %v", err)
+			out.streamError("Error reading Ollama response: " + err.Error())
+			return
+		}
+		usageLog.Record(identity.Username, clientReq.Model, clientReq.ConversationID, *result.usage)
+
+		if len(result.toolCalls) == 0 || len(ollamaReq.Tools) == 0 {
+			replyUsage = result.usage
+			out.finish(result.final, result.usage)
+			return
+		}
+
+		ollamaReq.Messages = append(ollamaReq.Messages, Message{Role: "assistant", Content: result.content, Thinking: result.thinking, ToolCalls: result.toolCalls})
+		for _, call := range result.toolCalls {
+			out.tool("tool_call", ToolInvocation{Name: call.Function.Name, Arguments: call.Function.Arguments})
+			inv := tools.Run(ctx, call)
+			if inv.Error != "" {
+				log.Printf("Chat %s: tool %s failed: %s", generationID, inv.Name, inv.Error)
+			}
+			out.tool("tool_result", inv)
+			toolLog = append(toolLog, inv)
+			ollamaReq.Messages = append(ollamaReq.Messages, Message{Role: "tool", Content: inv.modelContent(), ToolName: inv.Name})
+		}
+		// The last round goes without tools so that the model has to answer.
+		if round >= tools.maxRounds {
+			ollamaReq.Tools = nil
+		}
+
+		resp.Body.Close()
+		stats.Done()
+		stats = trackStream(out.action(), clientReq.Model)
+		next, err := startOllamaStream(ctx, backend, client, ollamaChatPath, ollamaReq)
+		if err != nil {
+			if ctx.Err() != nil {
+				out.cancelled()
+				return
+			}
+			log.Printf("Error continuing chat %s after tool calls: %v", generationID, err)
+			out.streamError("Error calling Ollama chat API: " + err.Error())
+			return
+		}
+		resp = next
+	}
+}
+
+// chatRound is what one streamed /api/chat call produced.
+type chatRound struct {
+	content   string
+	thinking  string
+	toolCalls []ToolCall
+	final     OllamaResponseChunk
+	usage     *GenerationUsage // Nil if the stream ended without a final chunk
+}
+
+// relayChatStream forwards an Ollama chat stream to the client and collects the
+// reply, tool calls and usage. Reasoning, whether from the thinking field or
+// from <think> tags in the content, is passed on separately from the answer.
+func relayChatStream(out generationOutput, body io.Reader, stats *streamMetrics) (chatRound, error) {
+	var round chatRound
+	var content, thinking strings.Builder
+	var tags thinkTagSplitter
+	emit := func(thought, text string) {
+		thinking.WriteString(thought)
+		content.WriteString(text)
+		if thought != "" || text != "" {
+			out.delta(thought, text, nil)
+		}
+	}
+
+	scanner := newStreamScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		var chunk OllamaResponseChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			log.Printf("Error unmarshalling Ollama chat response chunk: %v, line: %s", err, line)
+			continue
+		}
+		usage := stats.Chunk(chunk)
+
+		if chunk.Message != nil {
+			round.toolCalls = append(round.toolCalls, chunk.Message.ToolCalls...)
+			tagThought, text := tags.Split(chunk.Message.Content)
+			emit(chunk.Message.Thinking+tagThought, text)
+		}
+
+		if chunk.Done {
+			emit(tags.Flush())
+			round.final = chunk
+			round.usage = usage
+			break
+		}
+	}
+	round.content = content.String()
+	round.thinking = thinking.String()
+	return round, scanner.Err()
+}
+
+// chatDelta is the payload of "content" and "thinking" events. It keeps the
+// shape of an Ollama chat chunk, so clients that ignore event names still find
+// the answer in message.content.
+type chatDelta struct {
+	Model   string  `json:"model"`
+	Message Message `json:"message"`
+	Done    bool    `json:"done"`
+}
+
+// writeChatDelta sends part of a chat reply as a named SSE event.
+func writeChatDelta(w io.Writer, event, model string, msg Message) {
+	data, err := json.Marshal(chatDelta{Model: model, Message: msg})
+	if err != nil {
+		log.Printf("Error marshalling chat delta: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+// thinkTagSplitter separates <think>...</think> reasoning from the answer in a
+// stream of content deltas. A tag split across deltas is held back until the
+// next delta shows whether it is complete.
+type thinkTagSplitter struct {
+	inThink  bool
+	pending  string
+	started  bool // Whether answer text has been returned
+	thoughts bool // Whether reasoning has been returned
+}
+
+// Split returns the reasoning and answer parts of the next content delta.
+func (s *thinkTagSplitter) Split(delta string) (thinking, content string) {
+	text := s.pending + delta
+	s.pending = ""
+	var thought, answer strings.Builder
+	for text != "" {
+		tag := "<think>"
+		if s.inThink {
+			tag = "</think>"
+		}
+		i := strings.Index(text, tag)
+		if i < 0 {
+			keep := partialTagSuffix(text, tag)
+			s.pending = text[len(text)-keep:]
+			text = text[:len(text)-keep]
+		}
+		part := text
+		if i >= 0 {
+			part = text[:i]
+		}
+		if s.inThink {
+			thought.WriteString(part)
+		} else {
+			answer.WriteString(part)
+		}
+		if i < 0 {
+			break
+		}
+		text = text[i+len(tag):]
+		s.inThink = !s.inThink
+	}
+	return s.trim(thought.String(), answer.String())
+}
+
+// Flush returns text held back at the end of the stream.
+func (s *thinkTagSplitter) Flush() (thinking, content string) {
+	text := s.pending
+	s.pending = ""
+	if s.inThink {
+		return s.trim(text, "")
+	}
+	return s.trim("", text)
+}
+
+// trim drops the whitespace models put between the reasoning and the answer.
+func (s *thinkTagSplitter) trim(thinking, content string) (string, string) {
+	if !s.thoughts {
+		thinking = strings.TrimLeft(thinking, " \t\r\n")
+		s.thoughts = thinking != ""
+	}
+	if !s.started {
+		content = strings.TrimLeft(content, " \t\r\n")
+		s.started = content != ""
+	}
+	return thinking, content
+}
+
+// partialTagSuffix returns the length of the longest suffix of text that is a proper prefix of tag.
+func partialTagSuffix(text, tag string) int {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// connectError wraps a failure to reach a backend at all.
+type connectError struct {
+	err error
+}
+
+func (e *connectError) Error() string { return e.err.Error() }
+func (e *connectError) Unwrap() error { return e.err }
+
+// startOllamaStream posts payload to a streaming Ollama endpoint and returns the
+// response once Ollama accepted it. Failures are reported as *connectError when
+// the backend is unreachable and *upstreamError for non-200 answers.
+func startOllamaStream(ctx context.Context, backend *Backend, client *http.Client, path string, payload any) (*http.Response, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling Ollama request: %w", err)
+	}
+
+	req, err := backend.NewRequest(ctx, http.MethodPost, path, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("creating request to Ollama: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &connectError{err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &upstreamError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(bodyBytes))}
+	}
+	return resp, nil
+}
+
+// maxStreamLine bounds a single line of an Ollama response stream. The final
+// generate chunk carries the whole context, which easily exceeds bufio's 64 KB default.
+const maxStreamLine = 16 << 20
+
+// newStreamScanner returns a line scanner for a generate or chat response stream.
+func newStreamScanner(body io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamLine)
+	return scanner
+}
+
+// writeUpstreamError reports a failed call to the named Ollama API to the client.
+func writeUpstreamError(w http.ResponseWriter, backend *Backend, api string, err error) {
+	var upErr *upstreamError
+	var connErr *connectError
+	switch {
+	case errors.As(err, &upErr):
+		log.Printf("Ollama %s API returned non-200 status: %d, body: %s", api, upErr.StatusCode, upErr.Body)
+		http.Error(w, "Ollama API error: "+upErr.Error(), upErr.StatusCode)
+	case errors.As(err, &connErr):
+		log.Printf("Error connecting to Ollama %s API on backend %q: %v", api, backend.Name, connErr.err)
+		http.Error(w, "Could not connect to Ollama. Please ensure Ollama is running on "+backend.URL+". "+connErr.err.Error(), http.StatusBadGateway)
+	default:
+		log.Printf("Error calling Ollama %s API: %v", api, err)
+		http.Error(w, "Error calling Ollama "+api+" API: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// finishCancelledStream ends an SSE stream whose upstream request was cancelled.
+// If the client is still connected (the generation was stopped via /api/cancel)
+// it is told that the stream is over.
+func finishCancelledStream(w http.ResponseWriter, r *http.Request, flusher http.Flusher) {
+	if r.Context().Err() != nil {
+		return
+	}
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// callModelPullAPI queues a background pull job and streams its progress to the
+// client as Server-Sent Events. The pull keeps running if the client disconnects.
+func callModelPullAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend) {
+	if clientReq.Model == "" {
+		http.Error(w, "Model name is required for pull", http.StatusBadRequest)
+		return
+	}
+	job := jobs.Submit(backend, "pull", clientReq.Model, identityFrom(r.Context()).Username)
+	streamJobEvents(w, r, job.ID)
+}
+
+// callModelPushAPI queues a background push job and streams its progress to the
+// client like a pull. Registry settings come from the registries config.
+func callModelPushAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend) {
+	if clientReq.Model == "" {
+		http.Error(w, "Model name is required for push", http.StatusBadRequest)
+		return
+	}
+	job := jobs.Submit(backend, "push", clientReq.Model, identityFrom(r.Context()).Username)
+	streamJobEvents(w, r, job.ID)
+}
+
+// runPull performs a streaming /api/pull against a backend, reporting every
+// progress line to emit. It returns once the pull succeeded, failed or ctx is cancelled.
+func runPull(ctx context.Context, backend *Backend, model string, emit func(PullProgressEvent)) error {
+	registry := registryFor(model)
+	// Pulls stream progress for as long as the download takes, so no overall timeout applies.
+	resp, err := startOllamaStream(ctx, backend, backend.Client(0), ollamaPullPath, OllamaPullRequestPayload{
+		Model:    model,
+		Stream:   true,
+		Insecure: registry.Insecure,
+		Username: registry.Username,
+		Password: registry.Password,
+	})
+	if err != nil {
+		var upErr *upstreamError
+		if errors.As(err, &upErr) {
+			log.Printf("Ollama pull API returned non-200 status: %d, body: %s", upErr.StatusCode, upErr.Body)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	return relayProgress(resp.Body, pullBytes, backend.Name, model, emit)
+}
+
+// runPush performs a streaming /api/push against a backend like runPull.
+func runPush(ctx context.Context, backend *Backend, model string, emit func(PullProgressEvent)) error {
+	registry := registryFor(model)
+	// Uploads stream progress for as long as they take, so no overall timeout applies.
+	resp, err := startOllamaStream(ctx, backend, backend.Client(0), ollamaPushPath, OllamaPushRequestPayload{
+		Model:    model,
+		Stream:   true,
+		Insecure: registry.Insecure,
+		Username: registry.Username,
+		Password: registry.Password,
+	})
+	if err != nil {
+		var upErr *upstreamError
+		if errors.As(err, &upErr) {
+			log.Printf("Ollama push API returned non-200 status: %d, body: %s", upErr.StatusCode, upErr.Body)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	return relayProgress(resp.Body, pushBytes, backend.Name, model, emit)
+}
+
+// relayProgress reads an Ollama pull or push progress stream and calls emit for
+// every status line, enriched with percentage, transfer rate and ETA; the bytes
+// moved are added to the transferred counter. It returns an error if the stream
+// breaks or Ollama reports a failure.
+func relayProgress(body io.Reader, transferredBytes *metricVec, backendName, model string, emit func(PullProgressEvent)) error {
+	tracker := newPullProgressTracker()
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk OllamaProgressChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			log.Printf("Error unmarshalling Ollama progress chunk: %v, line: %s", err, line)
+			continue
+		}
+		if chunk.Error != "" {
+			return errors.New(chunk.Error)
+		}
+
+		transferred := tracker.transferred
+		event := tracker.Update(chunk, time.Now())
+		event.Model = model
+		transferredBytes.Add(float64(tracker.transferred-transferred), backendName, model)
+		emit(event)
+	}
+	return scanner.Err()
+}
+
+// layerProgress remembers the last observed state of one layer download.
+type layerProgress struct {
+	completed int64
+	at        time.Time
+	rate      float64 // Smoothed bytes per second
+}
+
+// pullProgressTracker derives transfer rates and ETAs from consecutive progress chunks.
+type pullProgressTracker struct {
+	layers      map[string]*layerProgress
+	transferred int64 // Bytes downloaded since the first chunk of each layer
+}
+
+func newPullProgressTracker() *pullProgressTracker {
+	return &pullProgressTracker{layers: make(map[string]*layerProgress)}
+}
+
+// rateSmoothing is the weight of the newest sample in the exponential moving average of the transfer rate.
+const rateSmoothing = 0.3
+
+// Update converts a progress chunk observed at time now into a progress event.
+func (t *pullProgressTracker) Update(chunk OllamaProgressChunk, now time.Time) PullProgressEvent {
+	event := PullProgressEvent{
+		Status:    chunk.Status,
+		Digest:    chunk.Digest,
+		Total:     chunk.Total,
+		Completed: chunk.Completed,
+	}
+	if chunk.Digest == "" || chunk.Total <= 0 {
+		return event
+	}
+
+	event.Percent = float64(chunk.Completed) * 100 / float64(chunk.Total)
+
+	layer, seen := t.layers[chunk.Digest]
+	if !seen {
+		t.layers[chunk.Digest] = &layerProgress{completed: chunk.Completed, at: now}
+		return event
+	}
+	if elapsed := now.Sub(layer.at).Seconds(); elapsed > 0 && chunk.Completed >= layer.completed {
+		t.transferred += chunk.Completed - layer.completed
+		sample := float64(chunk.Completed-layer.completed) / elapsed
+		if layer.rate == 0 {
+			layer.rate = sample
+		} else {
+			layer.rate = rateSmoothing*sample + (1-rateSmoothing)*layer.rate
+		}
+		layer.completed = chunk.Completed
+		layer.at = now
+	}
+
+	event.BytesPerSecond = layer.rate
+	if layer.rate > 0 && chunk.Completed < chunk.Total {
+		event.ETASeconds = float64(chunk.Total-chunk.Completed) / layer.rate
+	}
+	return event
+}
+
+// callModelDeleteAPI handles the /api/delete endpoint
+func callModelDeleteAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client) {
+	bodyBytes, err := deleteModel(r.Context(), backend, client, clientReq.Model)
+	if err != nil {
+		writeUpstreamError(w, backend, "delete", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain") // Delete often returns plain text success
+	w.Write(bodyBytes)
+}
+
+// deleteModel removes a model from a backend with Ollama's /api/delete and
+// returns the response body.
+func deleteModel(ctx context.Context, backend *Backend, client *http.Client, model string) ([]byte, error) {
+	payloadBytes, err := json.Marshal(OllamaModelActionPayload{Model: model})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling Ollama delete request: %w", err)
+	}
+
+	// DELETE request for Ollama's /api/delete
+	req, err := backend.NewRequest(ctx, http.MethodDelete, ollamaDeletePath, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("creating delete request to Ollama: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &connectError{err: err}
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading Ollama delete response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(bodyBytes))}
+	}
+	return bodyBytes, nil
+}
+
+// fetchModelTags queries a backend's /api/tags endpoint.
+func fetchModelTags(ctx context.Context, backend *Backend, client *http.Client) (*OllamaTagsResponse, error) {
+	req, err := backend.NewRequest(ctx, http.MethodGet, ollamaTagsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &upstreamError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(bodyBytes))}
+	}
+
+	var tagsResponse OllamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tagsResponse); err != nil {
+		return nil, fmt.Errorf("parsing Ollama models response: %w", err)
+	}
+	noteInstalledModels(backend.Name, tagsResponse.Models)
+	return &tagsResponse, nil
+}
+
+// handleListModels fetches the installed models of every backend (or only the
+// one selected by the "backend" query parameter) from their /api/tags endpoints.
+func handleListModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	selected := r.URL.Query().Get("backend")
+	primary, ok := resolveBackend(w, selected)
+	if !ok {
+		return
+	}
+	queried := backends.All()
+	if selected != "" {
+		queried = []*Backend{primary}
+	}
+
+	results := make([]BackendModels, len(queried))
+	errs := make([]error, len(queried))
+	var wg sync.WaitGroup
+	for i, b := range queried {
+		wg.Add(1)
+		go func(i int, b *Backend) {
+			defer wg.Done()
+			results[i] = BackendModels{Backend: b.Name, URL: b.URL, Models: []OllamaModel{}}
+			tags, err := fetchModelTags(r.Context(), b, b.Client(10*time.Second)) // Shorter timeout for listing models
+			if err != nil {
+				log.Printf("Error listing models on backend %q: %v", b.Name, err)
+				results[i].Error = err.Error()
+				errs[i] = err
+				return
+			}
+			results[i].Models = filterVisibleModels(identityFrom(r.Context()), tags.Models)
+			modelUpdates.annotate(b.Name, results[i].Models)
+		}(i, b)
+	}
+	wg.Wait()
+
+	// When a single backend was requested, its failure is the response.
+	if selected != "" && errs[0] != nil {
+		var upErr *upstreamError
+		if errors.As(errs[0], &upErr) {
+			http.Error(w, "Ollama API error fetching models: "+upErr.Error(), upErr.StatusCode)
+		} else {
+			http.Error(w, "Could not connect to Ollama to list models. Please ensure Ollama is running on "+primary.URL+".", http.StatusBadGateway)
+		}
+		return
+	}
+
+	response := ModelsResponse{Models: []OllamaModel{}, Backends: results, DefaultModel: defaultModelAlias}
+	for i, b := range queried {
+		if b == primary {
+			response.Models = results[i].Models
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// InventoryModel is an installed model with the details reported by /api/show.
+type InventoryModel struct {
+	OllamaModel
+	Modelfile     string   `json:"modelfile,omitempty"`
+	Parameters    string   `json:"parameters,omitempty"`
+	Template      string   `json:"template,omitempty"`
+	System        string   `json:"system,omitempty"`
+	License       string   `json:"license,omitempty"`
+	ContextLength int64    `json:"context_length,omitempty"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+	Error         string   `json:"error,omitempty"` // Set when /api/show failed for this model
+}
+
+// InventoryResponse is the response of /api/inventory.
+type InventoryResponse struct {
+	Backend   string           `json:"backend"`
+	Models    []InventoryModel `json:"models"`
+	TotalSize int64            `json:"total_size"` // Bytes on disk used by all listed models
+}
+
+// showConcurrency limits the /api/show calls made in parallel for one inventory.
+const showConcurrency = 4
+
+// fetchModelShow calls /api/show for one model.
+func fetchModelShow(ctx context.Context, backend *Backend, client *http.Client, model string) (*OllamaShowResponse, error) {
+	resp, err := startOllamaStream(ctx, backend, client, ollamaShowPath, OllamaShowRequestPayload{Model: model})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var show OllamaShowResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, fmt.Errorf("parsing Ollama show response: %w", err)
+	}
+	return &show, nil
+}
+
+// contextLength extracts the context window from a model_info map, whose key is prefixed with the architecture.
+func contextLength(info map[string]any) int64 {
+	if arch, ok := info["general.architecture"].(string); ok {
+		if v, ok := info[arch+".context_length"].(float64); ok {
+			return int64(v)
+		}
+	}
+	for key, v := range info {
+		if f, ok := v.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			return int64(f)
+		}
+	}
+	return 0
+}
+
+// handleInventory lists the installed models of a backend, merging /api/tags
+// with the Modelfile, template, parameters, license, context length and
+// capabilities from /api/show.
+func handleInventory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	backend, ok := resolveBackend(w, r.URL.Query().Get("backend"))
+	if !ok {
+		return
+	}
+	client := backend.Client(30 * time.Second)
+
+	tags, err := fetchModelTags(r.Context(), backend, client)
+	if err != nil {
+		var upErr *upstreamError
+		if !errors.As(err, &upErr) {
+			err = &connectError{err: err}
+		}
+		writeUpstreamError(w, backend, "tags", err)
+		return
+	}
+
+	visible := filterVisibleModels(identityFrom(r.Context()), tags.Models)
+	modelUpdates.annotate(backend.Name, visible)
+	response := InventoryResponse{Backend: backend.Name, Models: make([]InventoryModel, len(visible))}
+	sem := make(chan struct{}, showConcurrency)
+	var wg sync.WaitGroup
+	for i, m := range visible {
+		response.Models[i].OllamaModel = m
+		response.TotalSize += m.Size
+		wg.Add(1)
+		go func(entry *InventoryModel) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			show, err := fetchModelShow(r.Context(), backend, client, entry.Name)
+			if err != nil {
+				log.Printf("Error showing model %q on backend %q: %v", entry.Name, backend.Name, err)
+				entry.Error = err.Error()
+				return
+			}
+			entry.Modelfile = show.Modelfile
+			entry.Parameters = show.Parameters
+			entry.Template = show.Template
+			entry.System = show.System
+			entry.License = show.License
+			entry.ContextLength = contextLength(show.ModelInfo)
+			entry.Capabilities = show.Capabilities
+			if entry.Details == nil {
+				details := show.Details
+				entry.Details = &details
+			}
+		}(&response.Models[i])
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleListBackends returns the configured backends so the UI can offer a selector.
+func handleListBackends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defaultBackend, _ := backends.Get("")
+	infos := []BackendInfo{}
+	for _, b := range backends.All() {
+		infos = append(infos, BackendInfo{Name: b.Name, URL: b.URL, Default: b == defaultBackend})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// --- Running Models ---
+
+// RunningModel is a loaded model as shown on the running models dashboard.
+type RunningModel struct {
+	OllamaRunningModel
+	VRAMPercent      float64 `json:"vram_percent"`       // Share of the model held in GPU memory
+	ExpiresInSeconds float64 `json:"expires_in_seconds"` // Negative for models kept loaded indefinitely
+}
+
+// RunningModelsResponse is the response of /api/ps.
+type RunningModelsResponse struct {
+	Backend string         `json:"backend"`
+	Models  []RunningModel `json:"models"`
+}
+
+// ModelLoadResponse reports the outcome of a load or unload action.
+type ModelLoadResponse struct {
+	Model          string  `json:"model"`
+	Backend        string  `json:"backend"`
+	Status         string  `json:"status"` // "loaded" or "unloaded"
+	LoadDurationMs float64 `json:"load_duration_ms,omitempty"`
+}
+
+// keepLoadedYears is the remaining time beyond which a model counts as kept
+// loaded indefinitely; Ollama reports keep_alive -1 as an expiry centuries away.
+const keepLoadedYears = 100
+
+// fetchRunningModels queries a backend's /api/ps endpoint.
+func fetchRunningModels(ctx context.Context, backend *Backend, client *http.Client) (*OllamaPsResponse, error) {
+	req, err := backend.NewRequest(ctx, http.MethodGet, ollamaPsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &connectError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &upstreamError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(bodyBytes))}
+	}
+
+	var ps OllamaPsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return nil, fmt.Errorf("parsing Ollama ps response: %w", err)
+	}
+	return &ps, nil
+}
+
+// handleRunningModels lists the models loaded on a backend with their memory
+// use and expiry. Models the caller may not see are left out.
+func handleRunningModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	backend, ok := resolveBackend(w, r.URL.Query().Get("backend"))
+	if !ok {
+		return
+	}
+
+	ps, err := fetchRunningModels(r.Context(), backend, backend.Client(10*time.Second))
+	if err != nil {
+		writeUpstreamError(w, backend, "ps", err)
+		return
+	}
+
+	identity := identityFrom(r.Context())
+	now := time.Now()
+	response := RunningModelsResponse{Backend: backend.Name, Models: []RunningModel{}}
+	for _, m := range ps.Models {
+		if !modelVisible(identity, m.Name) {
+			continue
+		}
+		entry := RunningModel{OllamaRunningModel: m, ExpiresInSeconds: m.ExpiresAt.Sub(now).Seconds()}
+		if m.Size > 0 {
+			entry.VRAMPercent = math.Round(float64(m.SizeVRAM)/float64(m.Size)*1000) / 10
+		}
+		if m.ExpiresAt.After(now.AddDate(keepLoadedYears, 0, 0)) {
+			entry.ExpiresInSeconds = -1
+		}
+		response.Models = append(response.Models, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// callModelLoadAPI loads a model into memory, or unloads it, by sending Ollama
+// an empty generate request. Loading keeps the model for clientReq.KeepAlive
+// (Ollama's default when empty); unloading sets keep_alive to 0.
+func callModelLoadAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client, unload bool) {
+	if clientReq.Model == "" {
+		http.Error(w, "Model is required", http.StatusBadRequest)
+		return
+	}
+	keepAlive, err := parseKeepAlive(clientReq.KeepAlive)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := "loaded"
+	if unload {
+		keepAlive = 0
+		status = "unloaded"
+	}
+
+	resp, err := startOllamaStream(r.Context(), backend, client, ollamaGeneratePath, OllamaGenerateRequestPayload{
+		Model:     clientReq.Model,
+		KeepAlive: keepAlive,
+	})
+	if err != nil {
+		writeUpstreamError(w, backend, "generate", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var chunk OllamaResponseChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		http.Error(w, "Error reading Ollama generate response: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("Model %q %s on backend %q", clientReq.Model, status, backend.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModelLoadResponse{
+		Model:          clientReq.Model,
+		Backend:        backend.Name,
+		Status:         status,
+		LoadDurationMs: float64(chunk.LoadDuration) / float64(time.Millisecond),
+	})
+}
+
+// --- Model Creation ---
+
+// maxModelfileText limits the system prompt and template of a created model.
+const maxModelfileText = 64 << 10
+
+var (
+	// modelNamePattern matches model names such as "reviewer", "team/reviewer:v2" or "registry.example.com:5000/team/reviewer".
+	modelNamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(:[0-9]+(/[A-Za-z0-9][A-Za-z0-9._-]*)+)?(/[A-Za-z0-9][A-Za-z0-9._-]*)*(:[A-Za-z0-9_][A-Za-z0-9._-]{0,127})?$`)
+	blobDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// ModelfilePreview is the response of a create action with preview set.
+type ModelfilePreview struct {
+	Model     string `json:"model"`
+	Modelfile string `json:"modelfile"`
+}
+
+// validateCreateRequest checks the fields of a create action.
+func validateCreateRequest(clientReq ClientRequest) error {
+	var problems []string
+	if !modelNamePattern.MatchString(clientReq.Model) {
+		problems = append(problems, fmt.Sprintf("model name %q is not valid", clientReq.Model))
+	}
+	if clientReq.From == "" {
+		problems = append(problems, "a base model is required")
+	} else if !modelNamePattern.MatchString(clientReq.From) {
+		problems = append(problems, fmt.Sprintf("base model %q is not valid", clientReq.From))
+	}
+	if len(clientReq.System) > maxModelfileText {
+		problems = append(problems, fmt.Sprintf("system prompt is longer than %d bytes", maxModelfileText))
+	} else if !modelfileQuotable(clientReq.System) {
+		problems = append(problems, `system prompt must not contain """ or end with "`)
+	}
+	if len(clientReq.Template) > maxModelfileText {
+		problems = append(problems, fmt.Sprintf("template is longer than %d bytes", maxModelfileText))
+	} else if !modelfileQuotable(clientReq.Template) {
+		problems = append(problems, `template must not contain """ or end with "`)
+	} else if strings.Count(clientReq.Template, "{{") != strings.Count(clientReq.Template, "}}") {
+		problems = append(problems, "template has unbalanced {{ }} delimiters")
+	}
+	if err := clientReq.Options.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for name, digest := range clientReq.Adapters {
+		if name == "" || strings.ContainsAny(name, "/\\ \t\r\n") {
+			problems = append(problems, fmt.Sprintf("adapter file name %q is not valid", name))
+		}
+		if !blobDigestPattern.MatchString(digest) {
+			problems = append(problems, fmt.Sprintf("adapter %q needs a sha256:<hex> blob digest", name))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// modelfileQuotable reports whether text can be written between """ quotes in
+// a Modelfile without ending them early, which would let it add directives.
+func modelfileQuotable(text string) bool {
+	return !strings.Contains(text, `"""`) && !strings.HasSuffix(text, `"`)
+}
+
+// renderModelfile shows a create request as the equivalent Modelfile.
+func renderModelfile(clientReq ClientRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", clientReq.From)
+	names := make([]string, 0, len(clientReq.Adapters))
+	for name := range clientReq.Adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "ADAPTER %s\n", name)
+	}
+
+	if clientReq.Options != nil {
+		var params map[string]json.RawMessage
+		data, _ := json.Marshal(clientReq.Options)
+		json.Unmarshal(data, &params)
+		keys := make([]string, 0, len(params))
+		for key := range params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "stop" {
+				continue
+			}
+			fmt.Fprintf(&b, "PARAMETER %s %s\n", key, params[key])
+		}
+		for _, stop := range clientReq.Options.Stop {
+			fmt.Fprintf(&b, "PARAMETER stop %s\n", strconv.Quote(stop))
+		}
+	}
+
+	if clientReq.Template != "" {
+		fmt.Fprintf(&b, "TEMPLATE \"\"\"%s\"\"\"\n", clientReq.Template)
+	}
+	if clientReq.System != "" {
+		fmt.Fprintf(&b, "SYSTEM \"\"\"%s\"\"\"\n", clientReq.System)
+	}
+	return b.String()
+}
+
+// callModelCreateAPI creates a model from a base model with Ollama's /api/create
+// and relays the progress as SSE events shaped like pull progress. With
+// clientReq.Preview it only returns the Modelfile.
+func callModelCreateAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client) {
+	if err := validateCreateRequest(clientReq); err != nil {
+		http.Error(w, "Invalid model definition: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, PermUseModels, clientReq.From) {
+		return
+	}
+	if clientReq.Preview {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ModelfilePreview{Model: clientReq.Model, Modelfile: renderModelfile(clientReq)})
+		return
+	}
+
+	resp, err := startOllamaStream(r.Context(), backend, client, ollamaCreatePath, OllamaCreateRequestPayload{
+		Model:      clientReq.Model,
+		From:       clientReq.From,
+		System:     clientReq.System,
+		Template:   clientReq.Template,
+		Parameters: clientReq.Options,
+		Adapters:   clientReq.Adapters,
+		Stream:     true,
+	})
+	if err != nil {
+		writeUpstreamError(w, backend, "create", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Set headers for Server-Sent Events (SSE)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("Streaming not supported by this connection for model creation.")
+		return
+	}
+
+	tracker := newPullProgressTracker()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var chunk OllamaProgressChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			log.Printf("Error unmarshalling Ollama create progress chunk: %v, line: %s", err, line)
+			continue
+		}
+		if chunk.Error != "" {
+			log.Printf("Creating model %q on backend %q failed: %s", clientReq.Model, backend.Name, chunk.Error)
+			writeStreamError(w, flusher, chunk.Error)
+			return
+		}
+		event := tracker.Update(chunk, time.Now())
+		event.Model = clientReq.Model
+		eventBytes, _ := json.Marshal(event)
+		fmt.Fprintf(w, "data: %s\n\n", eventBytes)
+		flusher.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		log.Printf("Error reading Ollama create stream: %v", err)
+		writeStreamError(w, flusher, "Lost connection to Ollama: "+err.Error())
+		return
+	}
+	log.Printf("Created model %q from %q on backend %q", clientReq.Model, clientReq.From, backend.Name)
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// --- Model Copies and Aliases ---
+
+// defaultModelAlias is the model name the team uses as its default, from the
+// defaultModel setting. It is an ordinary model, pointed at a concrete version
+// with the copy action.
+var defaultModelAlias string
+
+// ModelCopyResponse reports the outcome of a copy or rename action.
+type ModelCopyResponse struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Backend     string `json:"backend"`
+	Status      string `json:"status"` // "copied" or "renamed"
+}
+
+// fullModelName adds the ":latest" tag Ollama assumes for a name without one.
+func fullModelName(model string) string {
+	if !strings.Contains(path.Base(model), ":") {
+		return model + ":latest"
+	}
+	return model
+}
+
+// copyModel copies a model under a new name with Ollama's /api/copy. An
+// existing model of that name is replaced.
+func copyModel(ctx context.Context, backend *Backend, client *http.Client, source, destination string) error {
+	resp, err := startOllamaStream(ctx, backend, client, ollamaCopyPath, OllamaCopyRequestPayload{Source: source, Destination: destination})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// callModelCopyAPI copies clientReq.Model to clientReq.Destination. A rename
+// also deletes the source; if that fails the copy is deleted again, so the
+// model is left under exactly one of the two names. Renaming onto an existing
+// model is refused, since the rollback would delete it.
+func callModelCopyAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client, rename bool) {
+	source, destination := clientReq.Model, clientReq.Destination
+	if source == "" || destination == "" {
+		http.Error(w, "Model and destination are required", http.StatusBadRequest)
+		return
+	}
+	if !modelNamePattern.MatchString(destination) {
+		http.Error(w, fmt.Sprintf("Destination model name %q is not valid", destination), http.StatusBadRequest)
+		return
+	}
+	if fullModelName(source) == fullModelName(destination) {
+		http.Error(w, "Source and destination are the same model", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, PermCreateModels, destination) {
+		return
+	}
+
+	if rename {
+		tags, err := fetchModelTags(r.Context(), backend, client)
+		if err != nil {
+			var upErr *upstreamError
+			if !errors.As(err, &upErr) {
+				err = &connectError{err: err}
+			}
+			writeUpstreamError(w, backend, "tags", err)
+			return
+		}
+		for _, m := range tags.Models {
+			if fullModelName(m.Name) == fullModelName(destination) {
+				http.Error(w, fmt.Sprintf("Model %q already exists; delete it first or copy instead", destination), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	if err := copyModel(r.Context(), backend, client, source, destination); err != nil {
+		writeUpstreamError(w, backend, "copy", err)
+		return
+	}
+	status := "copied"
+	if rename {
+		if _, err := deleteModel(r.Context(), backend, client, source); err != nil {
+			log.Printf("Renaming %q to %q on backend %q: deleting the source failed, removing the copy: %v", source, destination, backend.Name, err)
+			// The request context may be gone; the rollback must still run.
+			if _, rollbackErr := deleteModel(context.WithoutCancel(r.Context()), backend, client, destination); rollbackErr != nil {
+				log.Printf("Rolling back the copy %q on backend %q failed: %v", destination, backend.Name, rollbackErr)
+			}
+			writeUpstreamError(w, backend, "delete", err)
+			return
+		}
+		status = "renamed"
+	}
+	log.Printf("Model %q %s to %q on backend %q", source, status, destination, backend.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModelCopyResponse{Source: source, Destination: destination, Backend: backend.Name, Status: status})
+}
+
+// --- Registries ---
+
+// defaultRegistryHost is the registry of model names without a host part.
+const defaultRegistryHost = "registry.ollama.ai"
+
+// registries holds the configured registry settings by host; it is set up in main.
+var registries map[string]RegistryConfig
+
+// newRegistrySettings indexes the registries config by host.
+func newRegistrySettings(configs []RegistryConfig) (map[string]RegistryConfig, error) {
+	settings := make(map[string]RegistryConfig, len(configs))
+	for _, rc := range configs {
+		host := strings.ToLower(strings.TrimSpace(rc.Host))
+		if host == "" {
+			return nil, errors.New("registry host is required")
+		}
+		if _, dup := settings[host]; dup {
+			return nil, fmt.Errorf("registry %q is configured twice", host)
+		}
+		if (rc.Username == "") != (rc.Password == "") {
+			return nil, fmt.Errorf("registry %q needs both a username and a password", host)
+		}
+		rc.Host = host
+		settings[host] = rc
+	}
+	return settings, nil
+}
+
+// registryHost returns the registry a model name refers to. As with container
+// images, a first path element containing a dot or port, or "localhost", is a host.
+func registryHost(model string) string {
+	if i := strings.Index(model, "/"); i > 0 {
+		first := model[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			return strings.ToLower(first)
+		}
+	}
+	return defaultRegistryHost
+}
+
+// registryFor returns the settings of the registry a model name refers to; the
+// zero value if it is not configured.
+func registryFor(model string) RegistryConfig {
+	return registries[registryHost(model)]
+}
+
+// --- Model Manifest Sync ---
+
+// SyncConfig configures reconciliation of the backends against a models manifest.
+type SyncConfig struct {
+	Manifest string `json:"manifest"` // Path to models.yaml
+	Interval string `json:"interval"` // How often to reconcile, e.g. "1h"; empty only reconciles on request
+}
+
+// ModelManifest is the desired set of models of every backend.
+type ModelManifest struct {
+	Prune  bool            `json:"prune"` // Delete installed models the manifest does not list
+	Models []ManifestModel `json:"models"`
+}
+
+// ManifestModel is one required model. Digest optionally pins the expected
+// version; a shortened digest of at least 12 hex digits is enough.
+type ManifestModel struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// ModelSyncStatus compares one model on a backend with the manifest.
+type ModelSyncStatus struct {
+	Model      string `json:"model"`
+	State      string `json:"state"` // "ok", "missing", "drift" (digest differs) or "extra" (not listed)
+	WantDigest string `json:"wantDigest,omitempty"`
+	HaveDigest string `json:"haveDigest,omitempty"`
+	Action     string `json:"action,omitempty"` // "pull" or "delete" when something was done
+	JobID      string `json:"jobId,omitempty"`  // Pull job started by the background reconciler
+	Error      string `json:"error,omitempty"`
+}
+
+// BackendSyncReport is the outcome of reconciling one backend.
+type BackendSyncReport struct {
+	Backend string            `json:"backend"`
+	Error   string            `json:"error,omitempty"` // Set when the backend could not be listed
+	Models  []ModelSyncStatus `json:"models"`
+}
+
+// SyncReport is the outcome of a reconciliation run.
+type SyncReport struct {
+	Manifest   string              `json:"manifest"`
+	DryRun     bool                `json:"dryRun"`
+	Prune      bool                `json:"prune"`
+	InSync     bool                `json:"inSync"` // No model was missing, drifted or (when pruning) extra
+	StartedAt  time.Time           `json:"startedAt"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	Error      string              `json:"error,omitempty"` // Set when the manifest could not be read
+	Backends   []BackendSyncReport `json:"backends"`
+}
+
+// failed reports whether anything in the run went wrong.
+func (r *SyncReport) failed() bool {
+	if r.Error != "" {
+		return true
+	}
+	for _, b := range r.Backends {
+		if b.Error != "" {
+			return true
+		}
+		for _, m := range b.Models {
+			if m.Error != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// syncPullFunc pulls a missing model, returning the ID of the job doing so if it runs in the background.
+type syncPullFunc func(ctx context.Context, backend *Backend, model string) (jobID string, err error)
+
+// ManifestSyncer reconciles the backends against a manifest file and keeps the last report.
+type ManifestSyncer struct {
+	path string
+	pull syncPullFunc
+	run  sync.Mutex // Held for the duration of a reconciliation
+
+	mu   sync.Mutex
+	last *SyncReport
+}
+
+// manifestSync is nil unless sync.manifest is configured; it is set up in main.
+var manifestSync *ManifestSyncer
+
+var manifestDigestPattern = regexp.MustCompile(`^(sha256:)?[0-9a-f]{12,64}$`)
+
+var errSyncRunning = errors.New("a reconciliation is already running")
+
+// newManifestSyncer checks the sync config and, when an interval is set,
+// starts reconciling in the background. It returns nil if no manifest is configured.
+func newManifestSyncer(cfg SyncConfig, pull syncPullFunc) (*ManifestSyncer, error) {
+	if cfg.Manifest == "" {
+		if cfg.Interval != "" {
+			return nil, errors.New("sync.interval needs sync.manifest")
+		}
+		return nil, nil
+	}
+	s := &ManifestSyncer{path: cfg.Manifest, pull: pull}
+	if cfg.Interval == "" {
+		return s, nil
+	}
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid sync.interval %q", cfg.Interval)
+	}
+
+	go func() {
+		for {
+			report, err := s.Reconcile(context.Background(), false, false)
+			if err == nil {
+				logSyncReport(report)
+			}
+			time.Sleep(interval)
+		}
+	}()
+	log.Printf("Reconciling models against %s every %s", cfg.Manifest, interval)
+	return s, nil
+}
+
+// Last returns the report of the latest reconciliation, or nil.
+func (s *ManifestSyncer) Last() *SyncReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Reconcile compares every backend with the manifest. Unless dryRun is set it
+// pulls missing models and, if the manifest or forcePrune asks for it, deletes
+// unlisted ones. Digest drift is only reported, since Ollama cannot pull a
+// specific digest.
+func (s *ManifestSyncer) Reconcile(ctx context.Context, dryRun, forcePrune bool) (*SyncReport, error) {
+	if !s.run.TryLock() {
+		return nil, errSyncRunning
+	}
+	defer s.run.Unlock()
+
+	report := &SyncReport{Manifest: s.path, DryRun: dryRun, StartedAt: time.Now(), Backends: []BackendSyncReport{}}
+	defer func() {
+		report.FinishedAt = time.Now()
+		s.mu.Lock()
+		s.last = report
+		s.mu.Unlock()
+	}()
+
+	manifest, err := loadModelManifest(s.path)
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.Prune = manifest.Prune || forcePrune
+	report.InSync = true
+
+	for _, backend := range backends.All() {
+		result := s.reconcileBackend(ctx, backend, manifest, dryRun, report.Prune)
+		for _, m := range result.Models {
+			if m.State != "ok" && (m.State != "extra" || report.Prune) {
+				report.InSync = false
+			}
+		}
+		if result.Error != "" {
+			report.InSync = false
+		}
+		report.Backends = append(report.Backends, result)
+	}
+	return report, nil
+}
+
+func (s *ManifestSyncer) reconcileBackend(ctx context.Context, backend *Backend, manifest *ModelManifest, dryRun, prune bool) BackendSyncReport {
+	result := BackendSyncReport{Backend: backend.Name, Models: []ModelSyncStatus{}}
+	client := backend.Client(30 * time.Second)
+	tags, err := fetchModelTags(ctx, backend, client)
+	if err != nil {
+		log.Printf("Model sync: error listing models on backend %q: %v", backend.Name, err)
+		result.Error = err.Error()
+		return result
+	}
+	installed := make(map[string]OllamaModel, len(tags.Models))
+	for _, m := range tags.Models {
+		installed[fullModelName(m.Name)] = m
+	}
+
+	listed := make(map[string]bool, len(manifest.Models))
+	for _, want := range manifest.Models {
+		name := fullModelName(want.Name)
+		listed[name] = true
+		status := ModelSyncStatus{Model: want.Name, State: "ok", WantDigest: want.Digest}
+		have, ok := installed[name]
+		switch {
+		case !ok:
+			status.State = "missing"
+			if !dryRun {
+				status.Action = "pull"
+				status.JobID, err = s.pull(ctx, backend, want.Name)
+				if err != nil {
+					status.Error = err.Error()
+				}
+			}
+		case want.Digest != "" && !digestMatches(want.Digest, have.Digest):
+			status.State = "drift"
+			status.HaveDigest = have.Digest
+		}
+		result.Models = append(result.Models, status)
+	}
+
+	names := make([]string, 0, len(installed))
+	for name := range installed {
+		if !listed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status := ModelSyncStatus{Model: installed[name].Name, State: "extra", HaveDigest: installed[name].Digest}
+		if prune && !dryRun {
+			status.Action = "delete"
+			if _, err := deleteModel(ctx, backend, client, installed[name].Name); err != nil {
+				status.Error = err.Error()
+			} else {
+				log.Printf("Model sync: deleted %q from backend %q", installed[name].Name, backend.Name)
+			}
+		}
+		result.Models = append(result.Models, status)
+	}
+	return result
+}
+
+// digestMatches compares a manifest digest, possibly shortened or prefixed
+// with "sha256:", with the digest reported by /api/tags.
+func digestMatches(want, have string) bool {
+	want = strings.TrimPrefix(want, "sha256:")
+	have = strings.TrimPrefix(have, "sha256:")
+	return want != "" && strings.HasPrefix(have, want)
+}
+
+// submitPullJob queues a pull job for a model, unless one is already queued or running.
+func submitPullJob(ctx context.Context, backend *Backend, model string) (string, error) {
+	for _, job := range jobs.List() {
+		if job.Type == "pull" && job.Backend == backend.Name && fullModelName(job.Model) == fullModelName(model) && !job.finished() {
+			return job.ID, nil
+		}
+	}
+	return jobs.Submit(backend, "pull", model, "").ID, nil
+}
+
+// logSyncReport logs a summary of a reconciliation.
+func logSyncReport(report *SyncReport) {
+	if report.Error != "" {
+		log.Printf("Model sync against %s failed: %s", report.Manifest, report.Error)
+		return
+	}
+	for _, b := range report.Backends {
+		if b.Error != "" {
+			log.Printf("Model sync: backend %q: %s", b.Backend, b.Error)
+			continue
+		}
+		counts := map[string]int{}
+		for _, m := range b.Models {
+			counts[m.State]++
+		}
+		log.Printf("Model sync: backend %q: %d ok, %d missing, %d drifted, %d extra",
+			b.Backend, counts["ok"], counts["missing"], counts["drift"], counts["extra"])
+	}
+}
+
+// handleSync serves the last sync report (GET) or reconciles now (POST, with
+// dryRun=true to only compare). Reconciling may pull and delete models.
+func handleSync(w http.ResponseWriter, r *http.Request) {
+	if manifestSync == nil {
+		http.Error(w, "No models manifest is configured (sync.manifest)", http.StatusNotFound)
+		return
+	}
+	var report *SyncReport
+	switch r.Method {
+	case http.MethodGet:
+		report = manifestSync.Last()
+		if report == nil {
+			http.Error(w, "No reconciliation has run yet", http.StatusNotFound)
+			return
+		}
+	case http.MethodPost:
+		dryRun := r.URL.Query().Get("dryRun") == "true"
+		if !authorize(w, r, PermPullModels, "") || (!dryRun && !authorize(w, r, PermDeleteModels, "")) {
+			return
+		}
+		var err error
+		report, err = manifestSync.Reconcile(r.Context(), dryRun, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logSyncReport(report)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// runSync implements the "sync" subcommand: one reconciliation of the
+// configured backends against a manifest, pulling in the foreground. It exits
+// with status 1 if anything failed, or, with -dry-run, if a backend is out of sync.
+func runSync(args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("OLLAMANA_CONFIG"), "Path to a JSON config file")
+	manifestPath := flags.String("manifest", "", "Path to the models manifest (default: sync.manifest from the config, or models.yaml)")
+	prune := flags.Bool("prune", false, "Delete models the manifest does not list, even if it does not set prune")
+	dryRun := flags.Bool("dry-run", false, "Only report the differences")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	var backendSpecs backendFlag
+	flags.Var(&backendSpecs, "backend", "Ollama backend as name=url (repeatable)")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, backendSpecs, "")
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	backends, err = newBackendRegistry(cfg)
+	if err != nil {
+		log.Fatalf("Error configuring backends: %v", err)
+	}
+	registries, err = newRegistrySettings(cfg.Registries)
+	if err != nil {
+		log.Fatalf("Error configuring registries: %v", err)
+	}
+	if *manifestPath == "" {
+		*manifestPath = cfg.Sync.Manifest
+	}
+	if *manifestPath == "" {
+		*manifestPath = "models.yaml"
+	}
+
+	syncer := &ManifestSyncer{path: *manifestPath, pull: func(ctx context.Context, backend *Backend, model string) (string, error) {
+		log.Printf("Pulling %q on backend %q", model, backend.Name)
+		status := ""
+		return "", runPull(ctx, backend, model, func(event PullProgressEvent) {
+			if event.Status != status && event.Digest == "" {
+				status = event.Status
+				log.Printf("  %s: %s", model, status)
+			}
+		})
+	}}
+	report, err := syncer.Reconcile(context.Background(), *dryRun, *prune)
+	if err != nil {
+		log.Fatalf("Error reconciling models: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else if report.Error != "" {
+		fmt.Printf("manifest %s: %s\n", report.Manifest, report.Error)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "BACKEND\tMODEL\tSTATE\tACTION\tDETAILS")
+		for _, b := range report.Backends {
+			if b.Error != "" {
+				fmt.Fprintf(tw, "%s\t\t\t\t%s\n", b.Backend, b.Error)
+			}
+			for _, m := range b.Models {
+				details := m.Error
+				if m.State == "drift" {
+					details = fmt.Sprintf("want %s, have %s", m.WantDigest, m.HaveDigest)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", b.Backend, m.Model, m.State, m.Action, details)
+			}
+		}
+		tw.Flush()
+	}
+	if report.failed() || (*dryRun && !report.InSync) {
+		os.Exit(1)
+	}
+}
+
+// loadModelManifest reads and checks a manifest file.
+func loadModelManifest(path string) (*ModelManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := parseModelManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(manifest.Models))
+	for _, m := range manifest.Models {
+		if !modelNamePattern.MatchString(m.Name) {
+			return nil, fmt.Errorf("model name %q is not valid", m.Name)
+		}
+		if seen[fullModelName(m.Name)] {
+			return nil, fmt.Errorf("model %q is listed twice", m.Name)
+		}
+		seen[fullModelName(m.Name)] = true
+		if m.Digest != "" && !manifestDigestPattern.MatchString(m.Digest) {
+			return nil, fmt.Errorf("digest %q of model %q is not a sha256 digest", m.Digest, m.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// parseModelManifest decodes a manifest. JSON is accepted as is; otherwise
+// the file is read as the small YAML subset manifests need:
+//
+//	prune: false
+//	models:
+//	  - llama3:8b-instruct-q4_K_M
+//	  - name: nomic-embed-text
+//	    digest: 0a109f422b47
+//
+// Other YAML constructs (flow collections, anchors, aliases, tags, block
+// scalars, directives and document markers) are rejected, not misread.
+func parseModelManifest(data []byte) (*ModelManifest, error) {
+	manifest := &ModelManifest{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+
+	inModels := false
+	itemIndent := -1 // Indentation of the keys of the current list item
+	dashIndent := -1 // Indentation of a bare "-" whose keys follow on the next lines
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		line := stripYAMLComment(strings.TrimRight(raw, " \r"))
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.ContainsRune(line[:len(line)-len(strings.TrimLeft(line, " \t"))], '\t') {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", lineNo)
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		text := strings.TrimSpace(line)
+
+		if indent == 0 && (text == "---" || text == "..." || strings.HasPrefix(text, "--- ") || strings.HasPrefix(text, "%")) {
+			return nil, fmt.Errorf("line %d: YAML directives and document markers are not supported; use a single document", lineNo)
+		}
+		if indent == 0 && !strings.HasPrefix(text, "- ") && text != "-" {
+			key, value, ok := splitYAMLPair(text)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNo)
+			}
+			inModels, itemIndent = false, -1
+			if value != "[]" {
+				if err := checkYAMLScalar(value); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+			}
+			switch key {
+			case "prune":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: prune must be true or false", lineNo)
+				}
+				manifest.Prune = b
+			case "models":
+				if value != "" && value != "[]" {
+					return nil, fmt.Errorf("line %d: models must be a list", lineNo)
+				}
+				inModels = true
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", lineNo, key)
+			}
+			continue
+		}
+		if !inModels {
+			return nil, fmt.Errorf("line %d: unexpected indentation", lineNo)
+		}
+
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			item := strings.TrimSpace(strings.TrimPrefix(text, "-"))
+			manifest.Models = append(manifest.Models, ManifestModel{})
+			itemIndent, dashIndent = indent+len(text)-len(item), -1
+			if item == "" {
+				itemIndent, dashIndent = -1, indent
+				continue
+			}
+			if err := checkYAMLScalar(item); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if _, _, isPair := splitYAMLPair(item); !isPair {
+				name, err := unquoteYAML(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+				manifest.Models[len(manifest.Models)-1].Name = name
+				itemIndent = -1 // A scalar item has no further keys
+				continue
+			}
+			text = item
+		} else if dashIndent >= 0 && indent > dashIndent {
+			itemIndent, dashIndent = indent, -1
+		} else if indent != itemIndent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", lineNo)
+		}
+
+		key, value, ok := splitYAMLPair(text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"; multi-line values are not supported", lineNo)
+		}
+		if err := checkYAMLScalar(value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		value, err := unquoteYAML(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		model := &manifest.Models[len(manifest.Models)-1]
+		switch key {
+		case "name":
+			model.Name = value
+		case "digest":
+			model.Digest = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown model key %q", lineNo, key)
+		}
+	}
+	for i, m := range manifest.Models {
+		if m.Name == "" {
+			return nil, fmt.Errorf("model %d has no name", i+1)
+		}
+	}
+	return manifest, nil
+}
+
+// splitYAMLPair splits "key: value" (or "key:"). Colons inside a value, as in
+// "llama3:8b", do not count since they are not followed by a space.
+func splitYAMLPair(text string) (key, value string, ok bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		return "", "", false
+	}
+	if k, ok := strings.CutSuffix(text, ":"); ok && !strings.Contains(k, ": ") {
+		return strings.TrimSpace(k), "", true
+	}
+	k, v, found := strings.Cut(text, ": ")
+	if !found {
+		return "", "", false
+	}
+	return strings.TrimSpace(k), strings.TrimSpace(v), true
+}
+
+// checkYAMLScalar rejects values that start a YAML construct the manifest
+// parser does not support, so that they are not taken as plain strings.
+func checkYAMLScalar(value string) error {
+	if value == "" {
+		return nil
+	}
+	switch value[0] {
+	case '{':
+		return errors.New("flow mappings ({...}) are not supported")
+	case '[':
+		return errors.New("flow lists ([...]) are not supported; use one \"- item\" per line")
+	case '&', '*':
+		return errors.New("anchors and aliases are not supported")
+	case '!':
+		return errors.New("tags are not supported")
+	case '|', '>':
+		return errors.New("block scalars (| and >) are not supported")
+	}
+	return nil
+}
+
+// stripYAMLComment removes a trailing "# comment" outside quotes.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return strings.TrimRight(line[:i], " ")
+		}
+	}
+	return line
+}
+
+// unquoteYAML decodes a plain, single-quoted or double-quoted scalar.
+func unquoteYAML(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return strconv.Unquote(value)
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'"):
+		return "", fmt.Errorf("unterminated quoted value %s", value)
+	}
+	return value, nil
+}
+
+// --- Model Updates ---
+
+// UpdatesConfig configures checking installed models against their registries.
+type UpdatesConfig struct {
+	CheckInterval string `json:"checkInterval"` // How often to check, e.g. "24h"; no background checks when empty
+}
+
+// ModelUpdate is the result of comparing an installed model with its registry.
+type ModelUpdate struct {
+	Backend      string    `json:"backend"`
+	Model        string    `json:"model"`
+	State        string    `json:"state"` // "current", "outdated", "unknown" (not in a registry, e.g. created locally) or "error"
+	LocalDigest  string    `json:"localDigest"`
+	RemoteDigest string    `json:"remoteDigest,omitempty"`
+	AutoUpdate   bool      `json:"autoUpdate"`
+	JobID        string    `json:"jobId,omitempty"` // Pull started by the auto-update policy
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checkedAt"`
+}
+
+// ModelUpdatesResponse is the response of /api/updates and /api/updates/check.
+type ModelUpdatesResponse struct {
+	CheckedAt *time.Time    `json:"checkedAt,omitempty"` // Unset until the first check
+	Models    []ModelUpdate `json:"models"`
+}
+
+// UpdatePolicyRequest turns the auto-update policy of a model on or off.
+type UpdatePolicyRequest struct {
+	Model      string `json:"model"`
+	AutoUpdate bool   `json:"autoUpdate"`
+}
+
+// updatePolicyFile is the on-disk format of the auto-update policies.
+type updatePolicyFile struct {
+	AutoUpdate []string `json:"autoUpdate"` // Full model names
+}
+
+// UpdateChecker compares installed models with their registries, keeps the
+// results and pulls outdated models whose policy asks for it.
+type UpdateChecker struct {
+	policyPath string
+	client     *http.Client
+	insecure   *http.Client // For registries configured as insecure
+	run        sync.Mutex   // Held for the duration of a check
+
+	mu         sync.Mutex
+	autoUpdate map[string]bool        // By full model name
+	results    map[string]ModelUpdate // By updateKey
+	checkedAt  time.Time
+}
+
+// modelUpdates is the update checker used by all handlers; it is set up in main.
+var modelUpdates *UpdateChecker
+
+var (
+	errUpdateCheckRunning = errors.New("an update check is already running")
+	errNotInRegistry      = errors.New("not found in its registry")
+)
+
+// registryManifestAccept is the manifest format Ollama pulls, and so the one its digests are computed from.
+const registryManifestAccept = "application/vnd.docker.distribution.manifest.v2+json"
+
+// newUpdateChecker loads the auto-update policies and, when an interval is
+// configured, checks for updates in the background.
+func newUpdateChecker(cfg UpdatesConfig, policyPath string) (*UpdateChecker, error) {
+	c := &UpdateChecker{
+		policyPath: policyPath,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		insecure: &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}},
+		autoUpdate: make(map[string]bool),
+		results:    make(map[string]ModelUpdate),
+	}
+	data, err := os.ReadFile(policyPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var file updatePolicyFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", policyPath, err)
+		}
+		for _, model := range file.AutoUpdate {
+			c.autoUpdate[fullModelName(model)] = true
+		}
+	}
+	if cfg.CheckInterval == "" {
+		return c, nil
+	}
+
+	interval, err := time.ParseDuration(cfg.CheckInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid updates.checkInterval %q", cfg.CheckInterval)
+	}
+	go func() {
+		for {
+			if updates, err := c.Check(context.Background()); err == nil {
+				logModelUpdates(updates)
+			}
+			time.Sleep(interval)
+		}
+	}()
+	log.Printf("Checking installed models for updates every %s", interval)
+	return c, nil
+}
+
+// updateKey identifies a model on a backend in the check results.
+func updateKey(backend, model string) string {
+	return backend + "\x00" + fullModelName(model)
+}
+
+// sameDigest compares digests with or without their "sha256:" prefix.
+func sameDigest(a, b string) bool {
+	a = strings.TrimPrefix(a, "sha256:")
+	return a != "" && a == strings.TrimPrefix(b, "sha256:")
+}
+
+// Check compares the models installed on every backend with their
+// registries. Each model name is looked up once, however many backends have it.
+// Results of a backend that cannot be listed are kept from the previous check.
+func (c *UpdateChecker) Check(ctx context.Context) ([]ModelUpdate, error) {
+	if !c.run.TryLock() {
+		return nil, errUpdateCheckRunning
+	}
+	defer c.run.Unlock()
+
+	type lookup struct {
+		digest string
+		err    error
+	}
+	lookups := make(map[string]lookup)
+	results := make(map[string]ModelUpdate)
+	now := time.Now()
+
+	for _, backend := range backends.All() {
+		tags, err := fetchModelTags(ctx, backend, backend.Client(30*time.Second))
+		if err != nil {
+			log.Printf("Update check: error listing models on backend %q: %v", backend.Name, err)
+			c.mu.Lock()
+			for key, u := range c.results {
+				if u.Backend == backend.Name {
+					results[key] = u
+				}
+			}
+			c.mu.Unlock()
+			continue
+		}
+		for _, m := range tags.Models {
+			name := fullModelName(m.Name)
+			l, ok := lookups[name]
+			if !ok {
+				l.digest, l.err = c.fetchRegistryDigest(ctx, name)
+				lookups[name] = l
+			}
+
+			u := ModelUpdate{Backend: backend.Name, Model: m.Name, LocalDigest: m.Digest, RemoteDigest: l.digest, CheckedAt: now}
+			switch {
+			case errors.Is(l.err, errNotInRegistry):
+				u.State = "unknown"
+			case l.err != nil:
+				u.State = "error"
+				u.Error = l.err.Error()
+			case sameDigest(m.Digest, l.digest):
+				u.State = "current"
+			default:
+				u.State = "outdated"
+				if c.AutoUpdate(name) {
+					u.JobID, err = submitPullJob(ctx, backend, m.Name)
+					if err != nil {
+						u.Error = err.Error()
+					} else {
+						log.Printf("Update check: updating %q on backend %q (job %s)", m.Name, backend.Name, u.JobID)
+					}
+				}
+			}
+			results[updateKey(backend.Name, name)] = u
+		}
+	}
+
+	c.mu.Lock()
+	c.results = results
+	c.checkedAt = now
+	c.mu.Unlock()
+	return c.List(), nil
+}
+
+// fetchRegistryDigest returns the digest Ollama would record for the current
+// manifest of a model's tag: the sha256 of the manifest as served.
+func (c *UpdateChecker) fetchRegistryDigest(ctx context.Context, model string) (string, error) {
+	rc := registryFor(model)
+	client := c.client
+	if rc.Insecure {
+		client = c.insecure
+	}
+	manifestURL := registryManifestURL(model, "https")
+	resp, err := c.getManifest(ctx, client, manifestURL, rc, "")
+	if err != nil && rc.Insecure {
+		manifestURL = registryManifestURL(model, "http")
+		resp, err = c.getManifest(ctx, client, manifestURL, rc, "")
+	}
+	if err != nil {
+		return "", err
+	}
+	// Token-based registries answer with a challenge naming the service that issues pull tokens.
+	if params, ok := parseBearerChallenge(resp.Header.Get("WWW-Authenticate")); ok && resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		token, err := c.fetchRegistryToken(ctx, client, params, rc)
+		if err != nil {
+			return "", fmt.Errorf("registry %s: %w", registryHost(model), err)
+		}
+		if resp, err = c.getManifest(ctx, client, manifestURL, rc, token); err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", errNotInRegistry
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("registry %s refused access (status %d)", registryHost(model), resp.StatusCode)
+	default:
+		return "", fmt.Errorf("registry %s returned status %d", registryHost(model), resp.StatusCode)
+	}
+	manifest, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(manifest)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// getManifest requests a manifest with the bearer token if one is given, else
+// with the registry's basic credentials if configured.
+func (c *UpdateChecker) getManifest(ctx context.Context, client *http.Client, url string, rc RegistryConfig, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", registryManifestAccept)
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case rc.Username != "":
+		req.SetBasicAuth(rc.Username, rc.Password)
+	}
+	return client.Do(req)
+}
+
+// fetchRegistryToken asks the realm of a Bearer challenge for a token with the
+// challenge's service and scope, authenticating with the registry's basic
+// credentials if configured, as the Docker registry token protocol specifies.
+func (c *UpdateChecker) fetchRegistryToken(ctx context.Context, client *http.Client, params map[string]string, rc RegistryConfig) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" || (realm.Scheme != "https" && realm.Scheme != "http") {
+		return "", fmt.Errorf("Bearer challenge has no valid realm %q", params["realm"])
+	}
+	if realm.Scheme == "http" && !rc.Insecure {
+		return "", fmt.Errorf("token service %s uses plain HTTP but the registry is not configured as insecure", realm.Host)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if rc.Username != "" {
+		req.SetBasicAuth(rc.Username, rc.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service %s returned status %d", realm.Host, resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"` // OAuth2 name of the same token
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("parsing token from %s: %w", realm.Host, err)
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	if body.Token == "" {
+		return "", fmt.Errorf("token service %s returned no token", realm.Host)
+	}
+	return body.Token, nil
+}
+
+// parseBearerChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry",scope="repository:library/llama3:pull".
+func parseBearerChallenge(header string) (map[string]string, bool) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, " ,") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				end = len(value) - 1
+			}
+			value, rest = value[1:end+1], value[min(end+2, len(value)):]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return params, true
+}
+
+// registryManifestURL returns the URL of the manifest of a model's tag. Names
+// without a namespace live under "library", as on the Ollama registry.
+func registryManifestURL(model, scheme string) string {
+	host := registryHost(model)
+	repo, tag := model, "latest"
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	if i := strings.Index(repo, "/"); i > 0 && strings.EqualFold(repo[:i], host) {
+		repo = repo[i+1:]
+	}
+	if !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return scheme + "://" + host + "/v2/" + repo + "/manifests/" + tag
+}
+
+// List returns the results of the last check, sorted by backend and model.
+func (c *UpdateChecker) List() []ModelUpdate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	updates := make([]ModelUpdate, 0, len(c.results))
+	for _, u := range c.results {
+		u.AutoUpdate = c.autoUpdate[fullModelName(u.Model)]
+		updates = append(updates, u)
+	}
+	sort.Slice(updates, func(a, b int) bool {
+		if updates[a].Backend != updates[b].Backend {
+			return updates[a].Backend < updates[b].Backend
+		}
+		return updates[a].Model < updates[b].Model
+	})
+	return updates
+}
+
+// CheckedAt returns when the last check finished; zero before the first.
+func (c *UpdateChecker) CheckedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkedAt
+}
+
+// AutoUpdate reports whether a model is pulled again as soon as it is outdated.
+func (c *UpdateChecker) AutoUpdate(model string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.autoUpdate[fullModelName(model)]
+}
+
+// SetAutoUpdate changes the auto-update policy of a model and saves the policies.
+func (c *UpdateChecker) SetAutoUpdate(model string, on bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if on {
+		c.autoUpdate[fullModelName(model)] = true
+	} else {
+		delete(c.autoUpdate, fullModelName(model))
+	}
+	file := updatePolicyFile{AutoUpdate: []string{}}
+	for name := range c.autoUpdate {
+		file.AutoUpdate = append(file.AutoUpdate, name)
+	}
+	sort.Strings(file.AutoUpdate)
+	if err := os.MkdirAll(filepath.Dir(c.policyPath), 0o700); err != nil {
+		return err
+	}
+	return writeJSONFileAtomic(c.policyPath, file)
+}
+
+// annotate sets the Update field of models listed by a backend from the last
+// check. A model pulled again since then is current if it now has the registry's digest.
+func (c *UpdateChecker) annotate(backend string, models []OllamaModel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range models {
+		u, ok := c.results[updateKey(backend, models[i].Name)]
+		if !ok {
+			continue
+		}
+		if u.State == "outdated" && sameDigest(models[i].Digest, u.RemoteDigest) {
+			u.State, u.LocalDigest, u.JobID = "current", models[i].Digest, ""
+		}
+		u.AutoUpdate = c.autoUpdate[fullModelName(models[i].Name)]
+		models[i].Update = &u
+	}
+}
+
+// logModelUpdates logs a summary of an update check.
+func logModelUpdates(updates []ModelUpdate) {
+	counts := map[string]int{}
+	for _, u := range updates {
+		counts[u.State]++
+		if u.State == "outdated" {
+			log.Printf("Update check: %q on backend %q is outdated", u.Model, u.Backend)
+		}
+	}
+	log.Printf("Update check: %d current, %d outdated, %d not in a registry, %d failed",
+		counts["current"], counts["outdated"], counts["unknown"], counts["error"])
+}
+
+// visibleModelUpdates returns the results of the last check that the caller may see.
+func visibleModelUpdates(r *http.Request, updates []ModelUpdate) ModelUpdatesResponse {
+	response := ModelUpdatesResponse{Models: []ModelUpdate{}}
+	identity := identityFrom(r.Context())
+	for _, u := range updates {
+		if modelVisible(identity, u.Model) {
+			response.Models = append(response.Models, u)
+		}
+	}
+	if checkedAt := modelUpdates.CheckedAt(); !checkedAt.IsZero() {
+		response.CheckedAt = &checkedAt
+	}
+	return response
+}
+
+// handleModelUpdates serves the results of the last update check.
+func handleModelUpdates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visibleModelUpdates(r, modelUpdates.List()))
+}
+
+// handleCheckModelUpdates checks for updates now instead of waiting for the next check.
+func handleCheckModelUpdates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(w, r, PermPullModels, "") {
+		return
+	}
+	updates, err := modelUpdates.Check(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	logModelUpdates(updates)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visibleModelUpdates(r, updates))
+}
+
+// handleUpdatePolicy turns automatic updates of a model on or off.
+func handleUpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req UpdatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !modelNamePattern.MatchString(req.Model) {
+		http.Error(w, "A valid model name is required", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, PermPullModels, req.Model) {
+		return
+	}
+	if err := modelUpdates.SetAutoUpdate(req.Model, req.AutoUpdate); err != nil {
+		log.Printf("Error saving update policies: %v", err)
+		http.Error(w, "Error saving update policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// --- Background Jobs ---
+
+// JobState is the lifecycle state of a background job.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// defaultJobConcurrency is used when neither the config file nor flags set a concurrency.
+const defaultJobConcurrency = 2
+
+// maxFinishedJobs bounds how many finished jobs are kept for listing.
+const maxFinishedJobs = 100
+
+// Job is a long-running model operation executed in the background.
+type Job struct {
+	ID         string                       `json:"id"`
+	Type       string                       `json:"type"`
+	Model      string                       `json:"model"`
+	Backend    string                       `json:"backend"`
+	Owner      string                       `json:"owner,omitempty"` // User who submitted the job; empty for jobs started by Ollamana
+	State      JobState                     `json:"state"`
+	Status     string                       `json:"status"` // Last status line reported by Ollama
+	Error      string                       `json:"error,omitempty"`
+	Layers     map[string]PullProgressEvent `json:"layers"` // Latest progress per layer digest
+	CreatedAt  time.Time                    `json:"createdAt"`
+	StartedAt  *time.Time                   `json:"startedAt,omitempty"`
+	FinishedAt *time.Time                   `json:"finishedAt,omitempty"`
+
+	backend     *Backend
+	cancel      context.CancelFunc
+	subscribers map[chan PullProgressEvent]struct{}
+}
+
+// finished reports whether the job reached a terminal state.
+func (j *Job) finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// snapshot returns a copy of the job that is safe to encode outside the manager lock.
+func (j *Job) snapshot() Job {
+	c := *j
+	c.Layers = make(map[string]PullProgressEvent, len(j.Layers))
+	for k, v := range j.Layers {
+		c.Layers[k] = v
+	}
+	c.subscribers = nil
+	return c
+}
+
+// JobManager queues background jobs and runs a bounded number of them at a time.
+type JobManager struct {
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	order       []*Job // Submission order
+	pending     []*Job
+	running     int
+	concurrency int
+}
+
+// jobs is the manager used by all handlers; it is set up in main.
+var jobs *JobManager
+
+func newJobManager(concurrency int) *JobManager {
+	if concurrency <= 0 {
+		concurrency = defaultJobConcurrency
+	}
+	return &JobManager{
+		jobs:        make(map[string]*Job),
+		concurrency: concurrency,
+	}
+}
+
+// newID returns a random identifier for jobs, generations and conversations.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobRunners performs each job type; jobPermissions is the permission needed to start or manage it.
+var (
+	jobRunners = map[string]func(ctx context.Context, backend *Backend, model string, emit func(PullProgressEvent)) error{
+		"pull": runPull,
+		"push": runPush,
+	}
+	jobPermissions = map[string]Permission{
+		"pull": PermPullModels,
+		"push": PermPushModels,
+	}
+)
+
+// Submit queues a job of the given type ("pull" or "push") for model on
+// backend on behalf of owner and returns the new job.
+func (m *JobManager) Submit(backend *Backend, jobType, model, owner string) *Job {
+	job := &Job{
+		ID:          newID(),
+		Type:        jobType,
+		Model:       model,
+		Backend:     backend.Name,
+		Owner:       owner,
+		State:       JobQueued,
+		Layers:      make(map[string]PullProgressEvent),
+		CreatedAt:   time.Now(),
+		backend:     backend,
+		subscribers: make(map[chan PullProgressEvent]struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	m.order = append(m.order, job)
+	m.pending = append(m.pending, job)
+	m.pruneLocked()
+	m.dispatchLocked()
+	log.Printf("Queued %s job %s for model %q on backend %q", jobType, job.ID, model, backend.Name)
+	return job
+}
+
+// dispatchLocked starts pending jobs while there is spare concurrency. m.mu must be held.
+func (m *JobManager) dispatchLocked() {
+	for m.running < m.concurrency && len(m.pending) > 0 {
+		job := m.pending[0]
+		m.pending = m.pending[1:]
+
+		ctx, cancel := context.WithCancel(context.Background())
+		now := time.Now()
+		job.State = JobRunning
+		job.StartedAt = &now
+		job.cancel = cancel
+		m.running++
+		go m.run(ctx, job)
+	}
+}
+
+// pruneLocked drops the oldest finished jobs beyond maxFinishedJobs. m.mu must be held.
+func (m *JobManager) pruneLocked() {
+	finished := 0
+	for _, job := range m.order {
+		if job.finished() {
+			finished++
+		}
+	}
+	kept := m.order[:0]
+	for _, job := range m.order {
+		if job.finished() && finished > maxFinishedJobs {
+			delete(m.jobs, job.ID)
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	m.order = kept
+}
+
+// run executes a job and records its outcome.
+func (m *JobManager) run(ctx context.Context, job *Job) {
+	err := jobRunners[job.Type](ctx, job.backend, job.Model, func(event PullProgressEvent) {
+		m.publish(job, event)
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.cancel = nil
+	switch {
+	case ctx.Err() != nil:
+		job.State = JobCancelled
+		job.Error = "cancelled"
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+	default:
+		job.State = JobSucceeded
+	}
+	if job.Error != "" {
+		log.Printf("%s job %s for model %q %s: %s", job.Type, job.ID, job.Model, job.State, job.Error)
+	} else {
+		log.Printf("%s job %s for model %q %s", job.Type, job.ID, job.Model, job.State)
+	}
+
+	for ch := range job.subscribers {
+		close(ch)
+	}
+	job.subscribers = make(map[chan PullProgressEvent]struct{})
+
+	m.running--
+	m.dispatchLocked()
+}
+
+// publish records a progress event on the job and forwards it to subscribers.
+// Slow subscribers miss intermediate events rather than stalling the pull.
+func (m *JobManager) publish(job *Job, event PullProgressEvent) {
+	event.JobID = job.ID
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.Status = event.Status
+	if event.Digest != "" {
+		job.Layers[event.Digest] = event
+	}
+	for ch := range job.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (m *JobManager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List returns snapshots of all known jobs, newest first.
+func (m *JobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Job, 0, len(m.order))
+	for _, job := range m.order {
+		list = append(list, job.snapshot())
+	}
+	sort.SliceStable(list, func(a, b int) bool { return list[a].CreatedAt.After(list[b].CreatedAt) })
+	return list
+}
+
+// Subscribe returns a snapshot of the job and a channel receiving its progress
+// events. The channel is nil if the job has already finished, and is closed when it finishes.
+func (m *JobManager) Subscribe(id string) (Job, chan PullProgressEvent, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, nil, false
+	}
+	if job.finished() {
+		return job.snapshot(), nil, true
+	}
+	ch := make(chan PullProgressEvent, 64)
+	job.subscribers[ch] = struct{}{}
+	return job.snapshot(), ch, true
+}
+
+// Unsubscribe detaches a channel obtained from Subscribe.
+func (m *JobManager) Unsubscribe(id string, ch chan PullProgressEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		if _, subscribed := job.subscribers[ch]; subscribed {
+			delete(job.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Cancel stops a queued or running job.
+func (m *JobManager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+
+	switch job.State {
+	case JobQueued:
+		for i, pending := range m.pending {
+			if pending == job {
+				m.pending = append(m.pending[:i], m.pending[i+1:]...)
+				break
+			}
+		}
+		now := time.Now()
+		job.State = JobCancelled
+		job.Error = "cancelled"
+		job.FinishedAt = &now
+		for ch := range job.subscribers {
+			close(ch)
+		}
+		job.subscribers = make(map[chan PullProgressEvent]struct{})
+	case JobRunning:
+		job.cancel() // run records the cancelled state once the pull returns
+	default:
+		return job.snapshot(), fmt.Errorf("job %s is already %s", id, job.State)
+	}
+	return job.snapshot(), nil
+}
+
+// Resume re-queues a failed or cancelled job. Ollama keeps partially downloaded
+// layers, so the pull continues where it stopped.
+func (m *JobManager) Resume(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	if job.State != JobFailed && job.State != JobCancelled {
+		return job.snapshot(), fmt.Errorf("job %s is %s and cannot be resumed", id, job.State)
+	}
+
+	job.State = JobQueued
+	job.Error = ""
+	job.StartedAt = nil
+	job.FinishedAt = nil
+	m.pending = append(m.pending, job)
+	m.dispatchLocked()
+	log.Printf("Resumed %s job %s for model %q", job.Type, job.ID, job.Model)
+	return job.snapshot(), nil
+}
+
+var errJobNotFound = errors.New("job not found")
+
+// JobCreateRequest is the body accepted by POST /api/jobs.
+type JobCreateRequest struct {
+	Type    string `json:"type"` // "pull" (the default) or "push"
+	Model   string `json:"model"`
+	Backend string `json:"backend"`
+}
+
+// streamJobEvents streams a job's progress as Server-Sent Events until it
+// finishes or the client goes away. The current per-layer state is sent first so
+// that clients re-attaching to a running job see its progress immediately.
+func streamJobEvents(w http.ResponseWriter, r *http.Request, jobID string) {
+	snapshot, ch, ok := jobs.Subscribe(jobID)
+	if !ok {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if ch != nil {
+		defer jobs.Unsubscribe(jobID, ch)
+	}
+
+	// Set headers for Server-Sent Events (SSE)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("Streaming not supported by this connection for job events.")
+		return
+	}
+
+	writeEvent := func(event PullProgressEvent) {
+		eventBytes, _ := json.Marshal(event)
+		fmt.Fprintf(w, "data: %s\n\n", eventBytes)
+		flusher.Flush()
+	}
+
+	writeEvent(PullProgressEvent{JobID: snapshot.ID, Model: snapshot.Model, Status: snapshot.Status, State: string(snapshot.State)})
+	digests := make([]string, 0, len(snapshot.Layers))
+	for digest := range snapshot.Layers {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	for _, digest := range digests {
+		writeEvent(snapshot.Layers[digest])
+	}
+
+	for ch != nil {
+		select {
+		case event, open := <-ch:
+			if !open {
+				ch = nil
+				continue
+			}
+			writeEvent(event)
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	final, _ := jobs.Get(jobID)
+	writeEvent(PullProgressEvent{JobID: final.ID, Model: final.Model, Status: final.Status, State: string(final.State), Error: final.Error})
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// writeJobJSON encodes a job as the response body.
+func writeJobJSON(w http.ResponseWriter, status int, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
+
+// jobVisible reports whether the caller may see a job: its submitter, or
+// anyone allowed to start jobs of its type for its model.
+func jobVisible(r *http.Request, job Job) bool {
+	identity := identityFrom(r.Context())
+	if job.Owner != "" && job.Owner == identity.Username {
+		return true
+	}
+	return authStore.Authorize(identity, jobPermissions[job.Type], job.Model) == nil
+}
+
+// handleJobs lists the jobs the caller may see (GET) or submits a new one (POST).
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		visible := []Job{}
+		for _, job := range jobs.List() {
+			if jobVisible(r, job) {
+				visible = append(visible, job)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]Job{"jobs": visible})
+	case http.MethodPost:
+		var createReq JobCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+			http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if createReq.Type == "" {
+			createReq.Type = "pull"
+		}
+		perm, known := jobPermissions[createReq.Type]
+		if !known {
+			http.Error(w, "Unknown job type: "+createReq.Type, http.StatusBadRequest)
+			return
+		}
+		if createReq.Model == "" {
+			http.Error(w, "Model name is required for "+createReq.Type, http.StatusBadRequest)
+			return
+		}
+		if !authorize(w, r, perm, createReq.Model) {
+			return
+		}
+		backend, ok := resolveBackend(w, createReq.Backend)
+		if !ok {
+			return
+		}
+		job := jobs.Submit(backend, createReq.Type, createReq.Model, identityFrom(r.Context()).Username)
+		snapshot, _ := jobs.Get(job.ID)
+		writeJobJSON(w, http.StatusAccepted, snapshot)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJob returns a single job.
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := jobs.Get(r.PathValue("id"))
+	if !ok || !jobVisible(r, job) {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJobJSON(w, http.StatusOK, job)
+}
+
+// handleJobEvents re-attaches a client to a job's progress stream.
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if job, ok := jobs.Get(r.PathValue("id")); ok && !jobVisible(r, job) {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	streamJobEvents(w, r, r.PathValue("id"))
+}
+
+// handleCancelJob cancels a queued or running job.
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snapshot, ok := jobs.Get(r.PathValue("id"))
+	if !ok || !jobVisible(r, snapshot) {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !authorize(w, r, jobPermissions[snapshot.Type], snapshot.Model) {
+		return
+	}
+	job, err := jobs.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeJobJSON(w, http.StatusOK, job)
+	}
+}
+
+// handleResumeJob re-queues a failed or cancelled job.
+func handleResumeJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snapshot, ok := jobs.Get(r.PathValue("id"))
+	if !ok || !jobVisible(r, snapshot) {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !authorize(w, r, jobPermissions[snapshot.Type], snapshot.Model) {
+		return
+	}
+	job, err := jobs.Resume(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeJobJSON(w, http.StatusOK, job)
+	}
+}
+
+// --- Generation Cancellation ---
+
+// errGenerationCancelled is the cancellation cause recorded when a client calls /api/cancel.
+var errGenerationCancelled = errors.New("cancelled by client")
+
+// GenerationRegistry tracks running generate and chat streams so they can be cancelled by ID.
+type GenerationRegistry struct {
+	mu     sync.Mutex
+	active map[string]runningGeneration
+}
+
+// runningGeneration is a generation that can be cancelled by its owner.
+type runningGeneration struct {
+	cancel context.CancelCauseFunc
+	owner  string // Username of the caller that started it
+}
+
+// generations is the registry of running generations used by all handlers.
+var generations = &GenerationRegistry{active: make(map[string]runningGeneration)}
+
+var errNotGenerationOwner = errors.New("the generation was started by another user")
+
+// Start registers a generation of owner under a new ID and returns a context
+// derived from parent that is cancelled when Cancel is called with that ID.
+// The returned func must be called when the generation ends.
+func (g *GenerationRegistry) Start(parent context.Context, owner string) (context.Context, string, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	id := newID()
+
+	g.mu.Lock()
+	g.active[id] = runningGeneration{cancel: cancel, owner: owner}
+	g.mu.Unlock()
+
+	return ctx, id, func() {
+		g.mu.Lock()
+		delete(g.active, id)
+		g.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// Cancel stops the generation with the given ID on behalf of identity, which
+// must have started it or be an admin. It reports whether the generation was running.
+func (g *GenerationRegistry) Cancel(id string, identity *Identity) (bool, error) {
+	g.mu.Lock()
+	gen, ok := g.active[id]
+	g.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if gen.owner != identity.Username && identity.Role != RoleAdmin {
+		return true, errNotGenerationOwner
+	}
+	gen.cancel(errGenerationCancelled)
+	return true, nil
+}
+
+// CancelRequest is the body accepted by /api/cancel.
+type CancelRequest struct {
+	GenerationID string `json:"generationId"`
+}
+
+// handleCancelGeneration stops a running generate or chat stream, which also
+// stops Ollama from producing further tokens.
+func handleCancelGeneration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cancelReq CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&cancelReq); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	identity := identityFrom(r.Context())
+	running, err := generations.Cancel(cancelReq.GenerationID, identity)
+	if !running {
+		http.Error(w, "No running generation with ID "+cancelReq.GenerationID, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Refused to cancel generation %s for user %q: %v", cancelReq.GenerationID, identity.Username, err)
+		http.Error(w, "Cannot cancel generation "+cancelReq.GenerationID+": "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "cancelled", "generationId": cancelReq.GenerationID})
+}
+
+// --- Generation Parameters ---
+
+// maxStopSequences bounds the number of stop sequences accepted per request.
+const maxStopSequences = 16
+
+// checkFloatRange records an error if v is set and outside [min, max].
+func checkFloatRange(problems *[]string, name string, v *float64, min, max float64) {
+	if v != nil && (*v < min || *v > max) {
+		*problems = append(*problems, fmt.Sprintf("%s must be between %g and %g, got %g", name, min, max, *v))
+	}
+}
+
+// checkIntRange records an error if v is set and outside [min, max].
+func checkIntRange(problems *[]string, name string, v *int, min, max int) {
+	if v != nil && (*v < min || *v > max) {
+		*problems = append(*problems, fmt.Sprintf("%s must be between %d and %d, got %d", name, min, max, *v))
+	}
+}
+
+// Validate checks that every set option lies within the range Ollama accepts.
+func (o *ModelOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	var problems []string
+	checkFloatRange(&problems, "temperature", o.Temperature, 0, 2)
+	checkFloatRange(&problems, "top_p", o.TopP, 0, 1)
+	checkIntRange(&problems, "top_k", o.TopK, 0, 1000)
+	checkFloatRange(&problems, "min_p", o.MinP, 0, 1)
+	checkFloatRange(&problems, "typical_p", o.TypicalP, 0, 1)
+	checkIntRange(&problems, "num_ctx", o.NumCtx, 1, 1<<20)
+	checkIntRange(&problems, "num_predict", o.NumPredict, -2, 1<<20)
+	checkIntRange(&problems, "num_keep", o.NumKeep, -1, 1<<20)
+	checkFloatRange(&problems, "repeat_penalty", o.RepeatPenalty, 0, 2)
+	checkIntRange(&problems, "repeat_last_n", o.RepeatLastN, -1, 1<<20)
+	checkFloatRange(&problems, "presence_penalty", o.PresencePenalty, -2, 2)
+	checkFloatRange(&problems, "frequency_penalty", o.FrequencyPenalty, -2, 2)
+	checkIntRange(&problems, "mirostat", o.Mirostat, 0, 2)
+	checkFloatRange(&problems, "mirostat_tau", o.MirostatTau, 0, 20)
+	checkFloatRange(&problems, "mirostat_eta", o.MirostatEta, 0, 1)
+	checkIntRange(&problems, "num_gpu", o.NumGPU, -1, 1024)
+	checkIntRange(&problems, "num_thread", o.NumThread, 0, 1024)
+	checkIntRange(&problems, "num_batch", o.NumBatch, 1, 1<<16)
+
+	if len(o.Stop) > maxStopSequences {
+		problems = append(problems, fmt.Sprintf("at most %d stop sequences are allowed, got %d", maxStopSequences, len(o.Stop)))
+	}
+	for _, stop := range o.Stop {
+		if stop == "" {
+			problems = append(problems, "stop sequences must not be empty")
+			break
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateFormat accepts an empty format, the string "json" or a JSON Schema object.
+func validateFormat(format json.RawMessage) error {
+	trimmed := bytes.TrimSpace(format)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(trimmed, &name); err == nil {
+		if name != "" && name != "json" {
+			return fmt.Errorf("format must be \"json\" or a JSON Schema object, got %q", name)
+		}
+		return nil
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(trimmed, &schema); err != nil {
+		return errors.New("format must be \"json\" or a JSON Schema object")
+	}
+	return nil
+}
+
+// normalizeFormat drops empty formats so that they are omitted from the Ollama payload.
+func normalizeFormat(format json.RawMessage) json.RawMessage {
+	trimmed := bytes.TrimSpace(format)
+	if len(trimmed) == 0 || string(trimmed) == "null" || string(trimmed) == `""` {
+		return nil
+	}
+	return trimmed
+}
+
+// parseKeepAlive converts a keep-alive given as a duration ("5m") or a number of
+// seconds ("0", "-1") into the form Ollama expects. It returns nil for an empty value.
+func parseKeepAlive(v string) (any, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		return seconds, nil
+	}
+	if _, err := time.ParseDuration(v); err != nil {
+		return nil, fmt.Errorf("keepAlive %q is neither a duration nor a number of seconds", v)
+	}
+	return v, nil
+}
+
+// validateCommonParams checks the parameters shared by generate and chat and returns the parsed keep-alive.
+func validateCommonParams(clientReq ClientRequest) (any, error) {
+	if clientReq.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if err := clientReq.Options.Validate(); err != nil {
+		return nil, err
+	}
+	if err := validateFormat(clientReq.Format); err != nil {
+		return nil, err
+	}
+	return parseKeepAlive(clientReq.KeepAlive)
+}
+
+// validateThink accepts an unset think option, a boolean or one of the reasoning effort levels.
+func validateThink(think any) error {
+	switch v := think.(type) {
+	case nil, bool:
+		return nil
+	case string:
+		if v == "low" || v == "medium" || v == "high" {
+			return nil
+		}
+	}
+	return fmt.Errorf("think must be true, false, \"low\", \"medium\" or \"high\", got %v", think)
+}
+
+// buildGeneratePayload validates a client request and converts it into an Ollama generate request.
+func buildGeneratePayload(clientReq ClientRequest) (OllamaGenerateRequestPayload, error) {
+	keepAlive, err := validateCommonParams(clientReq)
+	if err != nil {
+		return OllamaGenerateRequestPayload{}, err
+	}
+	return OllamaGenerateRequestPayload{
+		Model:     clientReq.Model,
+		Prompt:    clientReq.Prompt,
+		Suffix:    clientReq.Suffix,
+		Stream:    true,
+		System:    clientReq.System,
+		Template:  clientReq.Template,
+		Format:    normalizeFormat(clientReq.Format),
+		Options:   clientReq.Options,
+		KeepAlive: keepAlive,
+		Raw:       clientReq.Raw,
+		Context:   clientReq.Context,
+		Images:    clientReq.Images,
+	}, nil
+}
+
+// buildChatPayload validates a client request and converts it into an Ollama chat
+// request. A system prompt is sent as a leading system message unless the
+// conversation already starts with one.
+func buildChatPayload(clientReq ClientRequest) (OllamaChatRequestPayload, error) {
+	keepAlive, err := validateCommonParams(clientReq)
+	if err != nil {
+		return OllamaChatRequestPayload{}, err
+	}
+	if err := validateThink(clientReq.Think); err != nil {
+		return OllamaChatRequestPayload{}, err
+	}
+	messages := clientReq.Messages
+	if clientReq.System != "" && (len(messages) == 0 || messages[0].Role != "system") {
+		messages = append([]Message{{Role: "system", Content: clientReq.System}}, messages...)
+	}
+	return OllamaChatRequestPayload{
+		Model:     clientReq.Model,
+		Messages:  messages,
+		Stream:    true,
+		Think:     clientReq.Think,
+		Format:    normalizeFormat(clientReq.Format),
+		Options:   clientReq.Options,
+		KeepAlive: keepAlive,
+	}, nil
+}
+
+// --- Conversation Store ---
+
+// ConversationMessage is one stored turn of a conversation.
+type ConversationMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Thinking  string           `json:"thinking,omitempty"`  // Reasoning of thinking models, kept apart from Content
+	Images    []string         `json:"images,omitempty"`    // Base64 images attached to a user turn
+	Usage     *GenerationUsage `json:"usage,omitempty"`     // Assistant replies only
+	Citations []Citation       `json:"citations,omitempty"` // Assistant replies grounded in a collection
+	ToolCalls []ToolInvocation `json:"toolCalls,omitempty"` // Tools run while producing the reply
+}
+
+// Conversation is a persisted chat history.
+type Conversation struct {
+	ID        string                `json:"id"`
+	Owner     string                `json:"owner,omitempty"` // Username of the creator; empty when authentication is disabled
+	Title     string                `json:"title"`
+	Model     string                `json:"model,omitempty"`
+	Backend   string                `json:"backend,omitempty"`
+	CreatedAt time.Time             `json:"createdAt"`
+	UpdatedAt time.Time             `json:"updatedAt"`
+	Messages  []ConversationMessage `json:"messages"`
+}
+
+// ConversationSummary is the listing form of a conversation, without messages.
+type ConversationSummary struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Model        string    `json:"model,omitempty"`
+	Backend      string    `json:"backend,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	MessageCount int       `json:"messageCount"`
+}
+
+// ConversationRequest is the body accepted when creating or renaming a conversation.
+type ConversationRequest struct {
+	Title   string `json:"title"`
+	Model   string `json:"model"`
+	Backend string `json:"backend"`
+}
+
+// maxTitleLength bounds conversation titles, including ones derived from the first message.
+const maxTitleLength = 80
+
+var (
+	errConversationNotFound = errors.New("conversation not found")
+	errInvalidTurn          = errors.New("the last message must be a non-empty user message")
+	errEmptyTitle           = errors.New("title must not be empty")
+)
+
+// ConversationStore keeps conversations in memory and persists each one as a
+// JSON file in its directory.
+type ConversationStore struct {
+	mu            sync.Mutex
+	dir           string
+	conversations map[string]*Conversation
+}
+
+// conversations is the store used by all handlers; it is set up in main.
+var conversations *ConversationStore
+
+// openConversationStore creates dir if needed and loads every conversation in it.
+func openConversationStore(dir string) (*ConversationStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	store := &ConversationStore{dir: dir, conversations: make(map[string]*Conversation)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var conv Conversation
+		if err := json.Unmarshal(data, &conv); err != nil {
+			log.Printf("Skipping unreadable conversation file %s: %v", entry.Name(), err)
+			continue
+		}
+		store.conversations[conv.ID] = &conv
+	}
+	log.Printf("Loaded %d conversations from %s", len(store.conversations), dir)
+	return store, nil
+}
+
+// path returns the file a conversation is stored in.
+func (s *ConversationStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// saveLocked writes a conversation atomically. s.mu must be held.
+func (s *ConversationStore) saveLocked(conv *Conversation) error {
+	return writeJSONFileAtomic(s.path(conv.ID), conv)
+}
+
+// writeJSONFileAtomic writes v as indented JSON to path through a temporary
+// file, so readers never observe a partially written file.
+func writeJSONFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyConversation returns a copy that does not share the message slice.
+func copyConversation(conv *Conversation) Conversation {
+	c := *conv
+	c.Messages = append([]ConversationMessage(nil), conv.Messages...)
+	return c
+}
+
+// truncateTitle shortens a title to maxTitleLength runes and collapses whitespace.
+func truncateTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength-1]) + "…"
+	}
+	return title
+}
+
+// List returns summaries of the owner's conversations, most recently updated first.
+func (s *ConversationStore) List(owner string) []ConversationSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]ConversationSummary, 0, len(s.conversations))
+	for _, conv := range s.conversations {
+		if conv.Owner != owner {
+			continue
+		}
+		list = append(list, ConversationSummary{
+			ID:           conv.ID,
+			Title:        conv.Title,
+			Model:        conv.Model,
+			Backend:      conv.Backend,
+			CreatedAt:    conv.CreatedAt,
+			UpdatedAt:    conv.UpdatedAt,
+			MessageCount: len(conv.Messages),
+		})
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].UpdatedAt.After(list[b].UpdatedAt) })
+	return list
+}
+
+// lookupLocked returns the conversation if it exists and belongs to owner. s.mu must be held.
+func (s *ConversationStore) lookupLocked(id, owner string) (*Conversation, error) {
+	conv, ok := s.conversations[id]
+	if !ok || conv.Owner != owner {
+		return nil, errConversationNotFound
+	}
+	return conv, nil
+}
+
+// Get returns a copy of the owner's conversation with the given ID.
+func (s *ConversationStore) Get(id, owner string) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, err := s.lookupLocked(id, owner)
+	if err != nil {
+		return Conversation{}, err
+	}
+	return copyConversation(conv), nil
+}
+
+// Create starts a new, empty conversation for owner.
+func (s *ConversationStore) Create(req ConversationRequest, owner string) (Conversation, error) {
+	now := time.Now()
+	conv := &Conversation{
+		ID:        newID(),
+		Owner:     owner,
+		Title:     truncateTitle(req.Title),
+		Model:     req.Model,
+		Backend:   req.Backend,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Messages:  []ConversationMessage{},
+	}
+	if conv.Title == "" {
+		conv.Title = "New conversation"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveLocked(conv); err != nil {
+		return Conversation{}, err
+	}
+	s.conversations[conv.ID] = conv
+	return copyConversation(conv), nil
+}
+
+// Rename changes the title of one of owner's conversations.
+func (s *ConversationStore) Rename(id, owner, title string) (Conversation, error) {
+	title = truncateTitle(title)
+	if title == "" {
+		return Conversation{}, errEmptyTitle
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, err := s.lookupLocked(id, owner)
+	if err != nil {
+		return Conversation{}, err
+	}
+	previous := conv.Title
+	conv.Title = title
+	conv.UpdatedAt = time.Now()
+	if err := s.saveLocked(conv); err != nil {
+		conv.Title = previous
+		return Conversation{}, err
+	}
+	return copyConversation(conv), nil
+}
+
+// Delete removes one of owner's conversations and its file.
+func (s *ConversationStore) Delete(id, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.lookupLocked(id, owner); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.conversations, id)
+	return nil
+}
+
+// Append adds messages to a conversation and persists it. The first user
+// message names a conversation that still has the default title.
+func (s *ConversationStore) Append(id string, messages ...ConversationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[id]
+	if !ok {
+		return errConversationNotFound
+	}
+	for _, msg := range messages {
+		if msg.Role == "user" && conv.Title == "New conversation" && strings.TrimSpace(msg.Content) != "" {
+			conv.Title = truncateTitle(msg.Content)
+		}
+		conv.Messages = append(conv.Messages, msg)
+	}
+	conv.UpdatedAt = time.Now()
+	return s.saveLocked(conv)
+}
+
+// PrepareTurn validates the new user turn (the last of the given messages) and
+// returns the stored history followed by that turn, ready to send to Ollama.
+// Only the images of the most recent turns are replayed, so that the request
+// stays within maxImagesPerRequest; older turns keep their text.
+// The turn itself is not stored yet; callers append it once Ollama accepted it.
+func (s *ConversationStore) PrepareTurn(id, owner string, messages []Message) ([]Message, ConversationMessage, error) {
+	if len(messages) == 0 {
+		return nil, ConversationMessage{}, errInvalidTurn
+	}
+	last := messages[len(messages)-1]
+	if last.Role != "user" || (strings.TrimSpace(last.Content) == "" && len(last.Images) == 0) {
+		return nil, ConversationMessage{}, errInvalidTurn
+	}
+
+	conv, err := s.Get(id, owner)
+	if err != nil {
+		return nil, ConversationMessage{}, err
+	}
+	history := make([]Message, len(conv.Messages), len(conv.Messages)+1)
+	remaining := maxImagesPerRequest - len(last.Images)
+	for i := len(conv.Messages) - 1; i >= 0; i-- {
+		msg := conv.Messages[i]
+		history[i] = Message{Role: msg.Role, Content: msg.Content}
+		if len(msg.Images) > remaining {
+			remaining = 0 // Keep the replayed images to a contiguous run of recent turns
+			continue
+		}
+		history[i].Images = msg.Images
+		remaining -= len(msg.Images)
+	}
+	history = append(history, last)
+	return history, ConversationMessage{Role: last.Role, Content: last.Content, Images: last.Images, CreatedAt: time.Now()}, nil
+}
+
+// conversationErrorStatus maps conversation store errors to HTTP status codes.
+func conversationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errConversationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errInvalidTurn), errors.Is(err, errEmptyTitle):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleConversations lists conversations (GET) or creates one (POST).
+func handleConversations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]ConversationSummary{"conversations": conversations.List(identityFrom(r.Context()).Username)})
+	case http.MethodPost:
+		var convReq ConversationRequest
+		if err := json.NewDecoder(r.Body).Decode(&convReq); err != nil {
+			http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		conv, err := conversations.Create(convReq, identityFrom(r.Context()).Username)
+		if err != nil {
+			log.Printf("Error creating conversation: %v", err)
+			http.Error(w, "Error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(conv)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleConversation returns (GET), renames (PATCH) or deletes (DELETE) a conversation.
+func handleConversation(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	owner := identityFrom(r.Context()).Username
+	switch r.Method {
+	case http.MethodGet:
+		conv, err := conversations.Get(id, owner)
+		if err != nil {
+			http.Error(w, err.Error(), conversationErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conv)
+	case http.MethodPatch:
+		var convReq ConversationRequest
+		if err := json.NewDecoder(r.Body).Decode(&convReq); err != nil {
+			http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		conv, err := conversations.Rename(id, owner, convReq.Title)
+		if err != nil {
+			http.Error(w, err.Error(), conversationErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conv)
+	case http.MethodDelete:
+		if err := conversations.Delete(id, owner); err != nil {
+			http.Error(w, err.Error(), conversationErrorStatus(err))
+			return
+		}
+		usageLog.Forget(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// --- OpenAI-Compatible API ---
+
+// openAIBackendHeader lets OpenAI clients pick a backend; the default backend is used without it.
+const openAIBackendHeader = "X-Ollamana-Backend"
+
+// OpenAIChatMessage is a chat message in the OpenAI wire format. Content is
+// either a string or an array of content parts.
+type OpenAIChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+	Name    string          `json:"name,omitempty"`
+}
+
+// OpenAIContentPart is one element of an array-valued message content.
+type OpenAIContentPart struct {
+	Type     string `json:"type"` // "text" or "image_url"
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+// OpenAIResponseFormat selects JSON output in chat completions.
+type OpenAIResponseFormat struct {
+	Type       string `json:"type"` // "text", "json_object" or "json_schema"
+	JSONSchema *struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+	} `json:"json_schema,omitempty"`
+}
+
+// OpenAIStreamOptions controls extra data in streamed responses.
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAISamplingParams are the sampling fields shared by chat and text completions.
+type OpenAISamplingParams struct {
+	Model               string               `json:"model"`
+	Stream              bool                 `json:"stream"`
+	StreamOptions       *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	Temperature         *float64             `json:"temperature,omitempty"`
+	TopP                *float64             `json:"top_p,omitempty"`
+	MaxTokens           *int                 `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                 `json:"max_completion_tokens,omitempty"`
+	Stop                json.RawMessage      `json:"stop,omitempty"` // String or array of strings
+	Seed                *int                 `json:"seed,omitempty"`
+	PresencePenalty     *float64             `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64             `json:"frequency_penalty,omitempty"`
+	N                   *int                 `json:"n,omitempty"`
+}
+
+// OpenAIChatCompletionRequest is the body of POST /v1/chat/completions.
+type OpenAIChatCompletionRequest struct {
+	OpenAISamplingParams
+	Messages       []OpenAIChatMessage   `json:"messages"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// OpenAICompletionRequest is the body of POST /v1/completions.
+type OpenAICompletionRequest struct {
+	OpenAISamplingParams
+	Prompt json.RawMessage `json:"prompt"` // String or array with a single string
+	Suffix string          `json:"suffix,omitempty"`
+}
+
+// OpenAIUsage reports token counts.
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// OpenAIChatChoice is a choice of a chat completion or one of its stream chunks.
+type OpenAIChatChoice struct {
+	Index        int               `json:"index"`
+	Message      *OpenAIChatOutput `json:"message,omitempty"`
+	Delta        *OpenAIChatOutput `json:"delta,omitempty"`
+	FinishReason *string           `json:"finish_reason"`
+}
+
+// OpenAIChatOutput is the assistant message (or delta) produced by a chat completion.
+type OpenAIChatOutput struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// OpenAITextChoice is a choice of a text completion or one of its stream chunks.
+type OpenAITextChoice struct {
+	Index        int     `json:"index"`
+	Text         string  `json:"text"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// OpenAICompletionResponse is a chat or text completion, or one streamed chunk of it.
+type OpenAICompletionResponse struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices any          `json:"choices"` // []OpenAIChatChoice or []OpenAITextChoice
+	Usage   *OpenAIUsage `json:"usage,omitempty"`
+}
+
+// OpenAIModel is an entry of GET /v1/models.
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// OpenAIEmbeddingRequest is the body of POST /v1/embeddings.
+type OpenAIEmbeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`                     // String or array of strings
+	EncodingFormat string          `json:"encoding_format,omitempty"` // "float" (default) or "base64"
+	Dimensions     int             `json:"dimensions,omitempty"`
+}
+
+// OpenAIEmbedding is one vector of an embeddings response.
+type OpenAIEmbedding struct {
+	Object    string `json:"object"`
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"` // []float64, or a base64 string of little-endian float32 values
+}
+
+// OllamaEmbedRequestPayload for /api/embed
+type OllamaEmbedRequestPayload struct {
+	Model      string        `json:"model"`
+	Input      []string      `json:"input"`
+	Truncate   *bool         `json:"truncate,omitempty"`
+	Dimensions int           `json:"dimensions,omitempty"`
+	Options    *ModelOptions `json:"options,omitempty"`
+	KeepAlive  any           `json:"keep_alive,omitempty"`
+}
+
+// OllamaEmbedResponse from /api/embed
+type OllamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"` // Nanoseconds
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// writeOpenAIError writes an error in the OpenAI error envelope.
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": message, "type": errType, "code": nil},
+	})
+}
+
+// writeOpenAIUpstreamError reports a failed Ollama call in the OpenAI error envelope.
+func writeOpenAIUpstreamError(w http.ResponseWriter, backend *Backend, err error) {
+	var upErr *upstreamError
+	var connErr *connectError
+	switch {
+	case errors.As(err, &upErr):
+		errType := "api_error"
+		if upErr.StatusCode == http.StatusNotFound {
+			errType = "invalid_request_error"
+		}
+		writeOpenAIError(w, upErr.StatusCode, errType, upErr.Body)
+	case errors.As(err, &connErr):
+		log.Printf("Error connecting to Ollama on backend %q: %v", backend.Name, connErr.err)
+		writeOpenAIError(w, http.StatusBadGateway, "api_error", "Could not connect to Ollama on "+backend.URL)
+	default:
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", err.Error())
+	}
+}
+
+// decodeOpenAIRequest decodes a POST body and resolves the backend for an OpenAI endpoint.
+func decodeOpenAIRequest(w http.ResponseWriter, r *http.Request, v any) (*Backend, bool) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return nil, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Invalid request payload: "+err.Error())
+		return nil, false
+	}
+	return resolveOpenAIBackend(w, r)
+}
+
+// resolveOpenAIBackend returns the backend selected by the X-Ollamana-Backend header.
+func resolveOpenAIBackend(w http.ResponseWriter, r *http.Request) (*Backend, bool) {
+	name := r.Header.Get(openAIBackendHeader)
+	b, ok := backends.Get(name)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Unknown backend: "+name)
+		return nil, false
+	}
+	return b, true
+}
+
+// parseStringOrList decodes a JSON value that is either a string or an array of strings.
+func parseStringOrList(raw json.RawMessage) ([]string, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, errors.New("expected a string or an array of strings")
+	}
+	return list, nil
+}
+
+// openAIMessage converts a chat message. Its content is a string or an array
+// of text and image_url parts; images must be given as data: URLs.
+func openAIMessage(msg OpenAIChatMessage) (Message, error) {
+	message := Message{Role: msg.Role}
+	raw := bytes.TrimSpace(msg.Content)
+	if len(raw) == 0 || string(raw) == "null" {
+		return message, nil
+	}
+	if err := json.Unmarshal(raw, &message.Content); err == nil {
+		return message, nil
+	}
+	var parts []OpenAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return Message{}, errors.New("message content must be a string or an array of content parts")
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			b.WriteString(part.Text)
+		case "image_url":
+			if part.ImageURL == nil || !strings.HasPrefix(part.ImageURL.URL, "data:") {
+				return Message{}, errors.New("image_url parts must carry a data: URL")
+			}
+			message.Images = append(message.Images, part.ImageURL.URL)
+		default:
+			return Message{}, fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+	}
+	message.Content = b.String()
+	return message, nil
+}
+
+// toClientRequest maps the shared OpenAI sampling fields onto a ClientRequest.
+func (p OpenAISamplingParams) toClientRequest(actionType string) (ClientRequest, error) {
+	if p.N != nil && *p.N != 1 {
+		return ClientRequest{}, errors.New("only n=1 is supported")
+	}
+	stop, err := parseStringOrList(p.Stop)
+	if err != nil {
+		return ClientRequest{}, fmt.Errorf("stop: %w", err)
+	}
+
+	options := &ModelOptions{
+		Temperature:      p.Temperature,
+		TopP:             p.TopP,
+		NumPredict:       p.MaxTokens,
+		Seed:             p.Seed,
+		Stop:             stop,
+		PresencePenalty:  p.PresencePenalty,
+		FrequencyPenalty: p.FrequencyPenalty,
+	}
+	if p.MaxCompletionTokens != nil {
+		options.NumPredict = p.MaxCompletionTokens
+	}
+	return ClientRequest{ActionType: actionType, Model: p.Model, Options: options}, nil
+}
+
+// openAIFinishReason maps Ollama's done_reason onto OpenAI's finish_reason.
+func openAIFinishReason(doneReason string) *string {
+	reason := "stop"
+	if doneReason == "length" {
+		reason = "length"
+	}
+	return &reason
+}
+
+// openAIStream writes OpenAI-style SSE chunks.
+type openAIStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// startOpenAIStream sets the SSE headers; it returns false if streaming is unsupported.
+func startOpenAIStream(w http.ResponseWriter) (*openAIStream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", "Streaming not supported by this connection")
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	return &openAIStream{w: w, flusher: flusher}, true
+}
+
+func (s *openAIStream) send(v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(s.w, "data: %s\n\n", data)
+	s.flusher.Flush()
+}
+
+func (s *openAIStream) done() {
+	fmt.Fprintf(s.w, "data: [DONE]\n\n")
+	s.flusher.Flush()
+}
+
+// openAIOutput adapts callGenerateAPI and callChatAPI to OpenAI text and chat
+// completions, streamed or returned whole. Reasoning, tool events and
+// citations have no place in these formats and are left out.
+type openAIOutput struct {
+	w            http.ResponseWriter
+	r            *http.Request
+	chat         bool
+	streaming    bool
+	includeUsage bool
+	completion   OpenAICompletionResponse
+	text         strings.Builder // The whole answer, when not streaming
+	stream       *openAIStream
+}
+
+func newOpenAIOutput(w http.ResponseWriter, r *http.Request, chat bool, params OpenAISamplingParams) *openAIOutput {
+	o := &openAIOutput{
+		w:            w,
+		r:            r,
+		chat:         chat,
+		streaming:    params.Stream,
+		includeUsage: params.StreamOptions != nil && params.StreamOptions.IncludeUsage,
+		completion:   OpenAICompletionResponse{ID: "cmpl-" + newID(), Object: "text_completion", Created: time.Now().Unix(), Model: params.Model},
+	}
+	if chat {
+		o.completion.ID, o.completion.Object = "chatcmpl-"+newID(), "chat.completion"
+	}
+	return o
+}
+
+func (o *openAIOutput) action() string {
+	if o.chat {
+		return "v1.chat"
+	}
+	return "v1.completions"
+}
+
+func (o *openAIOutput) header() http.Header { return o.w.Header() }
+
+func (o *openAIOutput) fail(status int, message string) {
+	errType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errType = "api_error"
+	}
+	writeOpenAIError(o.w, status, errType, message)
+}
+
+func (o *openAIOutput) refuse(permErr *PermissionError) {
+	writeOpenAIError(o.w, http.StatusForbidden, "permission_error", permErr.Error())
+}
+
+func (o *openAIOutput) failUpstream(backend *Backend, api string, err error) {
+	writeOpenAIUpstreamError(o.w, backend, err)
+}
+
+func (o *openAIOutput) start() bool {
+	if !o.streaming {
+		return true
+	}
+	stream, ok := startOpenAIStream(o.w)
+	if !ok {
+		return false
+	}
+	o.stream = stream
+	if o.chat {
+		o.completion.Object = "chat.completion.chunk"
+		o.completion.Choices = []OpenAIChatChoice{{Delta: &OpenAIChatOutput{Role: "assistant"}}}
+		o.stream.send(o.completion)
+	}
+	return true
+}
+
+// choices returns the choices of a completion, or of a streamed chunk, carrying text.
+func (o *openAIOutput) choices(text string, finishReason *string) any {
+	if !o.chat {
+		return []OpenAITextChoice{{Text: text, FinishReason: finishReason}}
+	}
+	if o.streaming {
+		return []OpenAIChatChoice{{Delta: &OpenAIChatOutput{Content: text}, FinishReason: finishReason}}
+	}
+	return []OpenAIChatChoice{{Message: &OpenAIChatOutput{Role: "assistant", Content: text}, FinishReason: finishReason}}
+}
+
+func (o *openAIOutput) citations(citations []Citation) {}
+
+func (o *openAIOutput) delta(thinking, content string, raw []byte) {
+	if content == "" {
+		return
+	}
+	if !o.streaming {
+		o.text.WriteString(content)
+		return
+	}
+	o.completion.Choices = o.choices(content, nil)
+	o.stream.send(o.completion)
+}
+
+func (o *openAIOutput) tool(event string, inv ToolInvocation) {}
+
+func (o *openAIOutput) finish(final OllamaResponseChunk, usage *GenerationUsage) {
+	reason := openAIFinishReason(final.DoneReason)
+	oaUsage := &OpenAIUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.PromptTokens + usage.CompletionTokens,
+	}
+	if !o.streaming {
+		o.completion.Choices = o.choices(o.text.String(), reason)
+		o.completion.Usage = oaUsage
+		o.w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(o.w).Encode(o.completion)
+		return
+	}
+
+	// Text completions carry the usage on the last chunk, chat completions in an extra chunk without choices.
+	o.completion.Choices = o.choices("", reason)
+	if o.includeUsage && !o.chat {
+		o.completion.Usage = oaUsage
+	}
+	o.stream.send(o.completion)
+	if o.includeUsage && o.chat {
+		o.completion.Choices = []OpenAIChatChoice{}
+		o.completion.Usage = oaUsage
+		o.stream.send(o.completion)
+	}
+	o.stream.done()
+}
+
+func (o *openAIOutput) streamError(message string) {
+	if !o.streaming {
+		writeOpenAIError(o.w, http.StatusBadGateway, "api_error", message)
+		return
+	}
+	o.stream.send(map[string]any{"error": map[string]any{"message": message, "type": "api_error", "code": nil}})
+	o.stream.done()
+}
+
+// cancelled ends a generation stopped through /api/cancel with what was generated so far.
+func (o *openAIOutput) cancelled() {
+	if o.r.Context().Err() != nil {
+		return
+	}
+	if o.streaming {
+		o.stream.done()
+		return
+	}
+	o.completion.Choices = o.choices(o.text.String(), openAIFinishReason(""))
+	o.w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(o.w).Encode(o.completion)
+}
+
+// runOpenAIGeneration checks the images of a translated request and runs it
+// through the same generate or chat path as /api/ollama-action.
+func runOpenAIGeneration(w http.ResponseWriter, r *http.Request, oaReq OpenAISamplingParams, clientReq ClientRequest, backend *Backend) {
+	out := newOpenAIOutput(w, r, clientReq.ActionType == "chat", oaReq)
+	if err := prepareRequestImages(&clientReq); err != nil {
+		out.fail(http.StatusBadRequest, "Invalid images: "+err.Error())
+		return
+	}
+	client := backend.Client(300 * time.Second)
+	if out.chat {
+		callChatAPI(out, r, clientReq, backend, client)
+	} else {
+		callGenerateAPI(out, r, clientReq, backend, client)
+	}
+}
+
+// handleOpenAIChatCompletions implements POST /v1/chat/completions on top of callChatAPI.
+func handleOpenAIChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var oaReq OpenAIChatCompletionRequest
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { recordRequest("v1.chat", oaReq.Model, rec.status) }()
+
+	backend, ok := decodeOpenAIRequest(w, r, &oaReq)
+	if !ok || !authorizeOpenAI(w, r, oaReq.Model) {
+		return
+	}
+
+	clientReq, err := oaReq.toClientRequest("chat")
+	if err == nil {
+		for _, msg := range oaReq.Messages {
+			var message Message
+			if message, err = openAIMessage(msg); err != nil {
+				break
+			}
+			clientReq.Messages = append(clientReq.Messages, message)
+		}
+	}
+	if err == nil && oaReq.ResponseFormat != nil {
+		switch oaReq.ResponseFormat.Type {
+		case "", "text":
+		case "json_object":
+			clientReq.Format = json.RawMessage(`"json"`)
+		case "json_schema":
+			if oaReq.ResponseFormat.JSONSchema == nil || len(oaReq.ResponseFormat.JSONSchema.Schema) == 0 {
+				err = errors.New("response_format.json_schema.schema is required")
+			} else {
+				clientReq.Format = oaReq.ResponseFormat.JSONSchema.Schema
+			}
+		default:
+			err = fmt.Errorf("unsupported response_format type %q", oaReq.ResponseFormat.Type)
+		}
+	}
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	runOpenAIGeneration(w, r, oaReq.OpenAISamplingParams, clientReq, backend)
+}
+
+// handleOpenAICompletions implements POST /v1/completions on top of callGenerateAPI.
+func handleOpenAICompletions(w http.ResponseWriter, r *http.Request) {
+	var oaReq OpenAICompletionRequest
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { recordRequest("v1.completions", oaReq.Model, rec.status) }()
+
+	backend, ok := decodeOpenAIRequest(w, r, &oaReq)
+	if !ok || !authorizeOpenAI(w, r, oaReq.Model) {
+		return
+	}
+
+	clientReq, err := oaReq.toClientRequest("generate")
+	if err == nil {
+		var prompts []string
+		prompts, err = parseStringOrList(oaReq.Prompt)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("prompt: %w", err)
+		case len(prompts) > 1:
+			err = errors.New("only a single prompt is supported")
+		case len(prompts) == 1:
+			clientReq.Prompt = prompts[0]
+		}
+	}
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	clientReq.Suffix = oaReq.Suffix
+	runOpenAIGeneration(w, r, oaReq.OpenAISamplingParams, clientReq, backend)
+}
+
+// handleOpenAIModels implements GET /v1/models from the backend's /api/tags.
+func handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return
+	}
+	backend, ok := resolveOpenAIBackend(w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := fetchModelTags(r.Context(), backend, backend.Client(10*time.Second))
+	if err != nil {
+		var upErr *upstreamError
+		if !errors.As(err, &upErr) {
+			err = &connectError{err: err}
+		}
+		writeOpenAIUpstreamError(w, backend, err)
+		return
+	}
+
+	identity := identityFrom(r.Context())
+	models := make([]OpenAIModel, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		if authStore.Authorize(identity, PermUseModels, m.Name) == nil {
+			models = append(models, OpenAIModel{ID: m.Name, Object: "model", OwnedBy: "ollama"})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": models})
+}
+
+// encodeFloat32Base64 packs a vector as little-endian float32 values, as OpenAI's base64 encoding format does.
+func encodeFloat32Base64(vector []float64) string {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// callOllamaEmbed calls /api/embed and returns the decoded vectors.
+func callOllamaEmbed(ctx context.Context, backend *Backend, client *http.Client, payload OllamaEmbedRequestPayload) (*OllamaEmbedResponse, error) {
+	resp, err := startOllamaStream(ctx, backend, client, ollamaEmbedPath, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embedResp OllamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("parsing Ollama embed response: %w", err)
+	}
+	if len(embedResp.Embeddings) != len(payload.Input) {
+		return nil, fmt.Errorf("Ollama returned %d embeddings for %d inputs", len(embedResp.Embeddings), len(payload.Input))
+	}
+	return &embedResp, nil
+}
+
+// handleOpenAIEmbeddings implements POST /v1/embeddings on top of Ollama's /api/embed.
+func handleOpenAIEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var oaReq OpenAIEmbeddingRequest
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { recordRequest("v1.embeddings", oaReq.Model, rec.status) }()
+
+	backend, ok := decodeOpenAIRequest(w, r, &oaReq)
+	if !ok || !authorizeOpenAI(w, r, oaReq.Model) {
+		return
+	}
+
+	inputs, err := parseStringOrList(oaReq.Input)
+	switch {
+	case err != nil:
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "input: "+err.Error())
+		return
+	case len(inputs) == 0:
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "input must not be empty")
+		return
+	case oaReq.Model == "":
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "model is required")
+		return
+	case oaReq.EncodingFormat != "" && oaReq.EncodingFormat != "float" && oaReq.EncodingFormat != "base64":
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "encoding_format must be float or base64")
+		return
+	}
+
+	embedResp, err := embedInBatches(r.Context(), backend, backend.Client(300*time.Second), OllamaEmbedRequestPayload{
+		Model:      oaReq.Model,
+		Input:      inputs,
+		Dimensions: oaReq.Dimensions,
+	})
+	if err != nil {
+		writeOpenAIUpstreamError(w, backend, err)
+		return
+	}
+
+	data := make([]OpenAIEmbedding, len(embedResp.Embeddings))
+	for i, vector := range embedResp.Embeddings {
+		data[i] = OpenAIEmbedding{Object: "embedding", Index: i, Embedding: vector}
+		if oaReq.EncodingFormat == "base64" {
+			data[i].Embedding = encodeFloat32Base64(vector)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data":   data,
+		"model":  oaReq.Model,
+		"usage":  OpenAIUsage{PromptTokens: embedResp.PromptEvalCount, TotalTokens: embedResp.PromptEvalCount},
+	})
+}
+
+// --- Authentication ---
+
+// AuthConfig configures authentication of UI users and API clients.
+type AuthConfig struct {
+	Enabled    bool                  `json:"enabled"`
+	SessionTTL string                `json:"sessionTTL"` // Lifetime of a login session, e.g. "12h"
+	Users      []UserConfig          `json:"users"`      // Users defined in the config file, in addition to those managed on the admin page
+	Roles      map[string]RolePolicy `json:"roles"`      // Model restrictions per role
+
+	// AnonymousRole is the role of every request while authentication is
+	// disabled; "user" unless set. "admin" has to be chosen explicitly.
+	AnonymousRole string `json:"anonymousRole"`
+}
+
+// UserConfig is a user defined in the config file.
+type UserConfig struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"` // Output of "ollamana hash-password"
+	Role         string `json:"role"`
+}
+
+// Roles known to the authentication layer, from least to most privileged.
+const (
+	RoleViewer     = "viewer"
+	RoleUser       = "user"
+	RoleModelAdmin = "model-admin"
+	RoleAdmin      = "admin"
+)
+
+// validRole reports whether role is one of the known roles.
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Method   string `json:"method"`          // "session", "apikey" or "anonymous"
+	KeyID    string `json:"keyId,omitempty"` // Set when authenticated with an API key
+}
+
+// anonymousIdentity is used for every request when authentication is
+// disabled. main sets its role from auth.anonymousRole.
+var anonymousIdentity = &Identity{Role: RoleUser, Method: "anonymous"}
+
+type identityKey struct{}
+
+// identityFrom returns the identity resolved by withAuth for a request context.
+func identityFrom(ctx context.Context) *Identity {
+	if identity, ok := ctx.Value(identityKey{}).(*Identity); ok {
+		return identity
+	}
+	return anonymousIdentity
+}
+
+// User is a local account stored in the auth file.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// UserInfo is the public view of a user.
+type UserInfo struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	Source    string    `json:"source"` // "config" or "local"
+}
+
+// APIKey is a bearer token issued to a user. Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to recognize it in listings
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// APIKeyInfo is the public view of an API key.
+type APIKeyInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (k *APIKey) info() APIKeyInfo {
+	return APIKeyInfo{ID: k.ID, Name: k.Name, Username: k.Username, Prefix: k.Prefix, CreatedAt: k.CreatedAt, LastUsedAt: k.LastUsedAt, RevokedAt: k.RevokedAt}
+}
+
+// authFile is the on-disk format of the auth store.
+type authFile struct {
+	Users   []*User   `json:"users"`
+	APIKeys []*APIKey `json:"apiKeys"`
+}
+
+// session is a logged-in browser session.
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// AuthStore holds users, API keys and sessions. Users and keys are persisted to a JSON file.
+type AuthStore struct {
+	mu          sync.Mutex
+	enabled     bool
+	path        string
+	users       map[string]*User
+	configUsers map[string]*User
+	keys        []*APIKey
+	sessions    map[string]*session
+	sessionTTL  time.Duration
+	policies    map[string]RolePolicy
+}
+
+// authStore and authenticator are used by withAuth and the auth handlers; they are set up in main.
+var (
+	authStore     *AuthStore
+	authenticator Authenticator
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid username or password")
+	errInvalidAPIKey      = errors.New("invalid or revoked API key")
+	errUserNotFound       = errors.New("user not found")
+	errKeyNotFound        = errors.New("API key not found")
+)
+
+// Defaults for authentication settings.
+const (
+	defaultSessionTTL = 12 * time.Hour
+	sessionCookieName = "ollamana_session"
+	apiKeyPrefix      = "olk_"
+	pbkdf2Iterations  = 600000
+	minPasswordLength = 8
+)
+
+// hashPassword derives a salted PBKDF2-SHA256 hash in the form
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches a hash produced by hashPassword.
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// hashAPIKey returns the hex SHA-256 of an API key. Keys are random, so a fast hash is sufficient.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// runHashPassword implements the "hash-password" subcommand, which reads a
+// password from standard input and prints its hash for use in the config file.
+func runHashPassword() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("Error reading password: %v", err)
+	}
+	hash, err := hashPassword(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		log.Fatalf("Error hashing password: %v", err)
+	}
+	fmt.Println(hash)
+}
+
+// openAuthStore loads the auth file and config-file users. When authentication
+// is enabled and no user exists, an "admin" account is created with the
+// password from OLLAMANA_ADMIN_PASSWORD, or a random one that is logged once.
+func openAuthStore(path string, cfg AuthConfig) (*AuthStore, error) {
+	store := &AuthStore{
+		enabled:     cfg.Enabled,
+		path:        path,
+		users:       make(map[string]*User),
+		configUsers: make(map[string]*User),
+		sessions:    make(map[string]*session),
+		sessionTTL:  defaultSessionTTL,
+		policies:    cfg.Roles,
+	}
+	for role, policy := range cfg.Roles {
+		if !validRole(role) {
+			return nil, fmt.Errorf("auth.roles: unknown role %q", role)
+		}
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("auth.roles.%s: %w", role, err)
+		}
+	}
+	if cfg.AnonymousRole != "" && !validRole(cfg.AnonymousRole) {
+		return nil, fmt.Errorf("auth.anonymousRole: unknown role %q", cfg.AnonymousRole)
+	}
+	if cfg.SessionTTL != "" {
+		ttl, err := time.ParseDuration(cfg.SessionTTL)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid auth.sessionTTL %q", cfg.SessionTTL)
+		}
+		store.sessionTTL = ttl
+	}
+
+	for _, uc := range cfg.Users {
+		role := uc.Role
+		if role == "" {
+			role = RoleUser
+		}
+		if uc.Username == "" || uc.PasswordHash == "" || !validRole(role) {
+			return nil, fmt.Errorf("config user %q needs a username, a passwordHash and a valid role", uc.Username)
+		}
+		store.configUsers[uc.Username] = &User{Username: uc.Username, PasswordHash: uc.PasswordHash, Role: role}
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var file authFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		for _, u := range file.Users {
+			store.users[u.Username] = u
+		}
+		store.keys = file.APIKeys
+	}
+
+	if cfg.Enabled && len(store.users) == 0 && len(store.configUsers) == 0 {
+		password := os.Getenv("OLLAMANA_ADMIN_PASSWORD")
+		if password == "" {
+			password = newID() + newID()
+			log.Printf("Created initial user \"admin\" with password %s - change it on the admin page", password)
+		}
+		if _, err := store.CreateUser("admin", password, RoleAdmin); err != nil {
+			return nil, fmt.Errorf("creating initial admin user: %w", err)
+		}
+	}
+	if cfg.Enabled {
+		log.Printf("Authentication enabled with %d users", len(store.users)+len(store.configUsers))
+	}
+	return store, nil
+}
+
+// saveLocked persists users and API keys. s.mu must be held.
+func (s *AuthStore) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	file := authFile{Users: make([]*User, 0, len(s.users)), APIKeys: s.keys}
+	for _, u := range s.users {
+		file.Users = append(file.Users, u)
+	}
+	sort.Slice(file.Users, func(a, b int) bool { return file.Users[a].Username < file.Users[b].Username })
+	return writeJSONFileAtomic(s.path, file)
+}
+
+// userLocked looks a user up in the config-file users, then in the local ones. s.mu must be held.
+func (s *AuthStore) userLocked(username string) (*User, bool) {
+	if u, ok := s.configUsers[username]; ok {
+		return u, true
+	}
+	u, ok := s.users[username]
+	return u, ok
+}
+
+// Login checks a username and password and starts a session, returning its token.
+func (s *AuthStore) Login(username, password string) (string, *User, error) {
+	s.mu.Lock()
+	u, ok := s.userLocked(username)
+	s.mu.Unlock()
+	if !ok || !checkPassword(u.PasswordHash, password) {
+		return "", nil, errInvalidCredentials
+	}
+
+	token := newID() + newID() + newID() + newID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = &session{username: username, expires: now.Add(s.sessionTTL)}
+	return token, u, nil
+}
+
+// Logout ends a session.
+func (s *AuthStore) Logout(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// SessionIdentity resolves a session token to an identity.
+func (s *AuthStore) SessionIdentity(token string) (*Identity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	u, exists := s.userLocked(sess.username)
+	if !exists || time.Now().After(sess.expires) {
+		delete(s.sessions, token)
+		return nil, false
+	}
+	return &Identity{Username: u.Username, Role: u.Role, Method: "session"}, true
+}
+
+// APIKeyIdentity resolves a bearer API key to the identity of its owner.
+func (s *AuthStore) APIKeyIdentity(key string) (*Identity, bool) {
+	hash := hashAPIKey(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) != 1 || k.RevokedAt != nil {
+			continue
+		}
+		u, ok := s.userLocked(k.Username)
+		if !ok {
+			return nil, false
+		}
+		// Usage timestamps are persisted at most once a minute to avoid a write per request.
+		now := time.Now()
+		if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > time.Minute {
+			k.LastUsedAt = &now
+			if err := s.saveLocked(); err != nil {
+				log.Printf("Error saving API key usage: %v", err)
+			}
+		}
+		return &Identity{Username: u.Username, Role: u.Role, Method: "apikey", KeyID: k.ID}, true
+	}
+	return nil, false
+}
+
+// Users lists config-file and local users.
+func (s *AuthStore) Users() []UserInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]UserInfo, 0, len(s.users)+len(s.configUsers))
+	for _, u := range s.configUsers {
+		list = append(list, UserInfo{Username: u.Username, Role: u.Role, Source: "config"})
+	}
+	for _, u := range s.users {
+		if _, shadowed := s.configUsers[u.Username]; !shadowed {
+			list = append(list, UserInfo{Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt, Source: "local"})
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Username < list[b].Username })
+	return list
+}
+
+// CreateUser adds a local user.
+func (s *AuthStore) CreateUser(username, password, role string) (UserInfo, error) {
+	username = strings.TrimSpace(username)
+	switch {
+	case username == "" || strings.ContainsAny(username, " /\t"):
+		return UserInfo{}, errors.New("username must be non-empty and contain no spaces or slashes")
+	case len(password) < minPasswordLength:
+		return UserInfo{}, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	case !validRole(role):
+		return UserInfo{}, fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.userLocked(username); exists {
+		return UserInfo{}, fmt.Errorf("user %q already exists", username)
+	}
+	u := &User{Username: username, PasswordHash: hash, Role: role, CreatedAt: time.Now()}
+	s.users[username] = u
+	if err := s.saveLocked(); err != nil {
+		delete(s.users, username)
+		return UserInfo{}, err
+	}
+	return UserInfo{Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt, Source: "local"}, nil
+}
+
+// DeleteUser removes a local user, revoking their API keys and ending their sessions.
+func (s *AuthStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.configUsers[username]; ok {
+		return errors.New("users defined in the config file cannot be deleted here")
+	}
+	if _, ok := s.users[username]; !ok {
+		return errUserNotFound
+	}
+	delete(s.users, username)
+	now := time.Now()
+	for _, k := range s.keys {
+		if k.Username == username && k.RevokedAt == nil {
+			k.RevokedAt = &now
+		}
+	}
+	for token, sess := range s.sessions {
+		if sess.username == username {
+			delete(s.sessions, token)
+		}
+	}
+	return s.saveLocked()
+}
+
+// IssueKey creates an API key for a user and returns the plaintext key, which is not stored.
+func (s *AuthStore) IssueKey(username, name string) (string, APIKeyInfo, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", APIKeyInfo{}, errors.New("key name must not be empty")
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKeyInfo{}, err
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.userLocked(username); !ok {
+		return "", APIKeyInfo{}, errUserNotFound
+	}
+	key := &APIKey{
+		ID:        newID(),
+		Name:      name,
+		Username:  username,
+		Prefix:    plaintext[:len(apiKeyPrefix)+6],
+		Hash:      hashAPIKey(plaintext),
+		CreatedAt: time.Now(),
+	}
+	s.keys = append(s.keys, key)
+	if err := s.saveLocked(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return "", APIKeyInfo{}, err
+	}
+	return plaintext, key.info(), nil
+}
+
+// Keys lists all API keys, including revoked ones.
+func (s *AuthStore) Keys() []APIKeyInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]APIKeyInfo, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k.info())
+	}
+	return list
+}
+
+// RevokeKey disables an API key.
+func (s *AuthStore) RevokeKey(id string) (APIKeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.ID != id {
+			continue
+		}
+		if k.RevokedAt == nil {
+			now := time.Now()
+			k.RevokedAt = &now
+			if err := s.saveLocked(); err != nil {
+				k.RevokedAt = nil
+				return APIKeyInfo{}, err
+			}
+		}
+		return k.info(), nil
+	}
+	return APIKeyInfo{}, errKeyNotFound
+}
+
+// Authenticator resolves the identity of a request. It returns a nil identity
+// and nil error when the request carries no credentials it understands, and an
+// error when it carries invalid ones.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// authChain tries each authenticator in turn.
+type authChain []Authenticator
+
+func (c authChain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range c {
+		identity, err := a.Authenticate(r)
+		if identity != nil || err != nil {
+			return identity, err
+		}
+	}
+	return nil, nil
+}
+
+// sessionAuthenticator accepts the session cookie set by /api/login.
+type sessionAuthenticator struct{}
+
+func (sessionAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+	if identity, ok := authStore.SessionIdentity(cookie.Value); ok {
+		return identity, nil
+	}
+	return nil, nil // An expired cookie is treated like no cookie, so the user is sent to the login page
+}
+
+// apiKeyAuthenticator accepts "Authorization: Bearer <key>" with an issued API key.
+type apiKeyAuthenticator struct{}
+
+func (apiKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	key, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+	if identity, ok := authStore.APIKeyIdentity(strings.TrimSpace(key)); ok {
+		return identity, nil
+	}
+	return nil, errInvalidAPIKey
+}
+
+// publicPaths are reachable without being logged in.
+var publicPaths = map[string]bool{
+	"/login":      true,
+	"/api/login":  true,
+	"/api/logout": true,
+}
+
+// withAuth resolves the caller's identity for every request and rejects
+// unauthenticated ones when authentication is enabled.
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authStore.enabled {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, anonymousIdentity)))
+			return
+		}
+
+		identity, err := authenticator.Authenticate(r)
+		if identity == nil && !publicPaths[r.URL.Path] {
+			message := "Authentication required"
+			if err != nil {
+				message = err.Error()
+			}
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/v1/"):
+				writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", message)
+			case strings.HasPrefix(r.URL.Path, "/api/"):
+				http.Error(w, message, http.StatusUnauthorized)
+			default:
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			}
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	})
+}
+
+// LoginRequest is the body accepted by /api/login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// handleLogin checks credentials and sets the session cookie.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var loginReq LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&loginReq); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, user, err := authStore.Login(loginReq.Username, loginReq.Password)
+	if err != nil {
+		log.Printf("Failed login for user %q from %s", loginReq.Username, r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(authStore.sessionTTL.Seconds()),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Identity{Username: user.Username, Role: user.Role, Method: "session"})
+}
+
+// handleLogout ends the caller's session.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		authStore.Logout(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", HttpOnly: true, MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMe returns the caller's identity.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	identity := identityFrom(r.Context())
+	json.NewEncoder(w).Encode(struct {
+		*Identity
+		Permissions []Permission `json:"permissions"`
+		AuthEnabled bool         `json:"authEnabled"`
+	}{identity, rolePermissions[identity.Role], authStore.enabled})
+}
+
+// CreateUserRequest is the body accepted by POST /api/admin/users.
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// handleAdminUsers lists (GET) or creates (POST) local users.
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermManageUsers, "") {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]UserInfo{"users": authStore.Users()})
+	case http.MethodPost:
+		var createReq CreateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+			http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if createReq.Role == "" {
+			createReq.Role = RoleUser
+		}
+		user, err := authStore.CreateUser(createReq.Username, createReq.Password, createReq.Role)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("User %q created user %q with role %s", identityFrom(r.Context()).Username, user.Username, user.Role)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(user)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminUser deletes a local user.
+func handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermManageUsers, "") {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username := r.PathValue("username")
+	if username == identityFrom(r.Context()).Username {
+		http.Error(w, "You cannot delete your own account", http.StatusBadRequest)
+		return
+	}
+	if err := authStore.DeleteUser(username); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUserNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("User %q deleted user %q", identityFrom(r.Context()).Username, username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// IssueKeyRequest is the body accepted by POST /api/admin/keys.
+type IssueKeyRequest struct {
+	Name     string `json:"name"`
+	Username string `json:"username"` // Owner of the key; defaults to the caller
+}
+
+// handleAdminKeys lists (GET) or issues (POST) API keys.
+func handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermManageUsers, "") {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]APIKeyInfo{"keys": authStore.Keys()})
+	case http.MethodPost:
+		var issueReq IssueKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&issueReq); err != nil {
+			http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if issueReq.Username == "" {
+			issueReq.Username = identityFrom(r.Context()).Username
+		}
+		plaintext, key, err := authStore.IssueKey(issueReq.Username, issueReq.Name)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errUserNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Printf("User %q issued API key %s (%s) for user %q", identityFrom(r.Context()).Username, key.ID, key.Name, key.Username)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"key": plaintext, "apiKey": key})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminKey revokes an API key.
+func handleAdminKey(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermManageUsers, "") {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, err := authStore.RevokeKey(r.PathValue("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("User %q revoked API key %s", identityFrom(r.Context()).Username, key.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// --- Authorization ---
+
+// Permission is a capability granted to a role.
+type Permission string
+
+// Permissions checked by the handlers.
+const (
+	PermUseModels    Permission = "models:use"    // generate, chat and embed
+	PermPullModels   Permission = "models:pull"   // pull models and manage pull jobs
+	PermDeleteModels Permission = "models:delete" // delete models and unload them from memory
+	PermManageUsers  Permission = "users:manage"  // manage users and API keys
+	PermCreateModels Permission = "models:create" // create models from a Modelfile or by copying one
+	PermPushModels   Permission = "models:push"   // push models to a registry and manage push jobs
+
+	PermManageCollections Permission = "collections:manage" // create collections and upload documents
+)
+
+// rolePermissions lists the permissions of each role. Every role may list
+// models; jobs are visible to their submitter and to roles that may start them.
+var rolePermissions = map[string][]Permission{
+	RoleViewer:     {},
+	RoleUser:       {PermUseModels},
+	RoleModelAdmin: {PermUseModels, PermPullModels, PermPushModels, PermDeleteModels, PermCreateModels, PermManageCollections},
+	RoleAdmin:      {PermUseModels, PermPullModels, PermPushModels, PermDeleteModels, PermCreateModels, PermManageCollections, PermManageUsers},
+}
+
+// actionPermissions maps each ActionType of /api/ollama-action to the permission it requires.
+var actionPermissions = map[string]Permission{
+	"generate":   PermUseModels,
+	"chat":       PermUseModels,
+	"structured": PermUseModels,
+	"embed":      PermUseModels,
+	"pull":       PermPullModels,
+	"push":       PermPushModels,
+	"delete":     PermDeleteModels,
+	"load":       PermUseModels,
+	"unload":     PermDeleteModels,
+	"create":     PermCreateModels,
+	"copy":       PermUseModels,
+	"rename":     PermDeleteModels,
+}
+
+// RolePolicy restricts the models a role may use, pull or delete. Patterns
+// are globs such as "llama3*" or "*:70b"; a name without a tag also matches
+// its ":latest" form. Deny patterns win over allow patterns.
+type RolePolicy struct {
+	AllowModels []string `json:"allowModels"` // When non-empty, only matching models are permitted
+	DenyModels  []string `json:"denyModels"`
+}
+
+func (p RolePolicy) validate() error {
+	for _, pattern := range append(append([]string{}, p.AllowModels...), p.DenyModels...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid model pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// matchModel reports whether a model name matches any of the patterns.
+func matchModel(patterns []string, model string) bool {
+	full := fullModelName(model)
+	short := strings.TrimSuffix(full, ":latest")
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, full); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, short); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionError explains why a request was refused. It is returned to the client as the 403 body.
+type PermissionError struct {
+	Message    string     `json:"error"`
+	Role       string     `json:"role"`
+	Permission Permission `json:"permission"`
+	Model      string     `json:"model,omitempty"`
+}
+
+func (e *PermissionError) Error() string { return e.Message }
+
+// Authorize checks that identity holds perm and, when model is set, that its
+// role's policy permits the model. Without authentication, identity is the
+// anonymous one and its role applies the same way.
+func (s *AuthStore) Authorize(identity *Identity, perm Permission, model string) *PermissionError {
+	granted := false
+	for _, p := range rolePermissions[identity.Role] {
+		if p == perm {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return &PermissionError{
+			Message:    fmt.Sprintf("role %q lacks permission %q", identity.Role, perm),
+			Role:       identity.Role,
+			Permission: perm,
+			Model:      model,
+		}
+	}
+	if model == "" {
+		return nil
+	}
+	policy := s.policies[identity.Role]
+	if matchModel(policy.DenyModels, model) || (len(policy.AllowModels) > 0 && !matchModel(policy.AllowModels, model)) {
+		return &PermissionError{
+			Message:    fmt.Sprintf("role %q may not use model %q", identity.Role, model),
+			Role:       identity.Role,
+			Permission: perm,
+			Model:      model,
+		}
+	}
+	return nil
+}
+
+// authorize writes a structured 403 response unless the caller may perform perm on model.
+func authorize(w http.ResponseWriter, r *http.Request, perm Permission, model string) bool {
+	identity := identityFrom(r.Context())
+	permErr := authStore.Authorize(identity, perm, model)
+	if permErr == nil {
+		return true
+	}
+	log.Printf("Refused %s for user %q: %v", r.URL.Path, identity.Username, permErr)
+	writePermissionError(w, permErr)
+	return false
+}
+
+// writePermissionError writes a refusal as a structured 403 response.
+func writePermissionError(w http.ResponseWriter, permErr *PermissionError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(permErr)
+}
+
+// authorizeOpenAI is authorize for the /v1 endpoints, reporting refusals in the OpenAI error envelope.
+func authorizeOpenAI(w http.ResponseWriter, r *http.Request, model string) bool {
+	identity := identityFrom(r.Context())
+	permErr := authStore.Authorize(identity, PermUseModels, model)
+	if permErr == nil {
+		return true
+	}
+	log.Printf("Refused %s for user %q: %v", r.URL.Path, identity.Username, permErr)
+	writeOpenAIError(w, http.StatusForbidden, "permission_error", permErr.Error())
+	return false
+}
+
+// filterVisibleModels drops the models a role's policy does not permit.
+func filterVisibleModels(identity *Identity, models []OllamaModel) []OllamaModel {
+	policy := authStore.policies[identity.Role]
+	if len(policy.AllowModels) == 0 && len(policy.DenyModels) == 0 {
+		return models
+	}
+	visible := []OllamaModel{}
+	for _, m := range models {
+		if modelVisible(identity, m.Name) {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+// modelVisible reports whether the role policy of identity admits a model.
+func modelVisible(identity *Identity, model string) bool {
+	policy := authStore.policies[identity.Role]
+	return !matchModel(policy.DenyModels, model) && (len(policy.AllowModels) == 0 || matchModel(policy.AllowModels, model))
+}
+
+// --- Metrics ---
+
+// Histogram buckets for latencies in seconds and for generation speed in tokens per second.
+var (
+	latencyBuckets   = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+	tokenRateBuckets = []float64{1, 5, 10, 20, 30, 50, 75, 100, 150, 250}
+)
+
+// Metrics exposed on /metrics.
+var (
+	requestsTotal = newCounter("ollamana_requests_total",
+		"Requests to /api/ollama-action and the /v1 API by action, model and HTTP status.", "action", "model", "status")
+	upstreamDuration = newHistogram("ollamana_upstream_request_duration_seconds",
+		"Time until an Ollama backend answered with response headers. status is \"error\" when it could not be reached.",
+		latencyBuckets, "backend", "endpoint", "status")
+	timeToFirstToken = newHistogram("ollamana_time_to_first_token_seconds",
+		"Time from receiving a generation request to the first generated token.", latencyBuckets, "action", "model")
+	tokensPerSecond = newHistogram("ollamana_tokens_per_second",
+		"Generation speed reported by Ollama in the final chunk (eval_count / eval_duration).", tokenRateBuckets, "model")
+	inflightStreams = newGauge("ollamana_inflight_streams",
+		"Generation streams currently open.", "action")
+	pullBytes = newCounter("ollamana_pull_bytes_total",
+		"Bytes downloaded from the registry by model pulls.", "backend", "model")
+	pushBytes = newCounter("ollamana_push_bytes_total",
+		"Bytes uploaded to registries by model pushes.", "backend", "model")
+	toolCallsTotal = newCounter("ollamana_tool_calls_total",
+		"Tool calls made by models during chat by tool and outcome (ok, error or unknown). Unknown tools are counted as \"other\".", "tool", "status")
+)
+
+// registeredMetrics lists every metric in the order it is exposed.
+var registeredMetrics []*metricVec
+
+// metricSeries is one labelled time series of a metric.
+type metricSeries struct {
+	labelValues []string
+	value       float64  // Counter or gauge value, or the sum of a histogram
+	count       uint64   // Histogram observations
+	buckets     []uint64 // Histogram observations per bucket, not cumulative
+}
+
+// metricVec is a counter, gauge or histogram with a fixed set of label names,
+// written in the Prometheus text exposition format.
+type metricVec struct {
+	name    string
+	help    string
+	kind    string // "counter", "gauge" or "histogram"
+	labels  []string
+	buckets []float64 // Upper bounds, histograms only
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newMetric(name, help, kind string, buckets []float64, labels []string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+	registeredMetrics = append(registeredMetrics, m)
+	return m
+}
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	return newMetric(name, help, "counter", nil, labels)
+}
+
+func newGauge(name, help string, labels ...string) *metricVec {
+	return newMetric(name, help, "gauge", nil, labels)
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	return newMetric(name, help, "histogram", buckets, labels)
+}
+
+// seriesLocked returns the series for the given label values, creating it if needed. m.mu must be held.
+func (m *metricVec) seriesLocked(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add adds v to a counter or gauge. Label values are given in the order of the label names.
+func (m *metricVec) Add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seriesLocked(labelValues).value += v
+}
+
+// Inc adds one to a counter or gauge.
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Observe records a histogram observation.
+func (m *metricVec) Observe(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.seriesLocked(labelValues)
+	s.value += v
+	s.count++
+	for i, upper := range m.buckets {
+		if v <= upper {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+// labelEscaper escapes label values as required by the exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {name="value",...}, with an optional extra label such as le.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	if extraName != "" {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "{" + b.String() + "}"
+}
+
+// writeTo writes the metric in the Prometheus text format, series sorted by label values.
+func (m *metricVec) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, upper := range m.buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", formatMetricValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// handleMetrics serves all metrics in the Prometheus text exposition format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range registeredMetrics {
+		m.writeTo(w)
+	}
+}
+
+// statusRecorder remembers the status code written by a handler. It passes
+// Flush through so that it can wrap streaming responses.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// recordRequest counts a finished request. Unknown action types share one label value to bound cardinality.
+func recordRequest(action, model string, status int) {
+	if _, known := actionPermissions[action]; !known && !strings.HasPrefix(action, "v1.") {
+		action = "unknown"
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	requestsTotal.Inc(action, metricModel(model), strconv.Itoa(status))
+}
+
+// otherModelLabel replaces model names that are neither installed nor in the
+// catalog, since requests may name any model and each name would add series.
+const otherModelLabel = "other"
+
+// installedModelsRefresh is how often the installed models of every backend are listed for metricModel.
+const installedModelsRefresh = time.Minute
+
+// installedModels holds the models each backend listed on its last /api/tags call.
+var installedModels = struct {
+	sync.Mutex
+	byBackend map[string]map[string]bool
+}{byBackend: map[string]map[string]bool{}}
+
+// noteInstalledModels records the models listed by a backend.
+func noteInstalledModels(backend string, models []OllamaModel) {
+	names := make(map[string]bool, len(models))
+	for _, m := range models {
+		names[m.Name] = true
+	}
+	installedModels.Lock()
+	installedModels.byBackend[backend] = names
+	installedModels.Unlock()
+}
+
+// refreshInstalledModels keeps installedModels current for models pulled or deleted outside the proxy.
+func refreshInstalledModels() {
+	for {
+		for _, b := range backends.All() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if _, err := fetchModelTags(ctx, b, b.Client(30*time.Second)); err != nil {
+				log.Printf("Error listing models of backend %q for metrics: %v", b.Name, err)
+			}
+			cancel()
+		}
+		time.Sleep(installedModelsRefresh)
+	}
+}
+
+// metricModel returns the model label value for a requested model: the name
+// itself if it is installed on a backend or listed in the catalog, otherwise "other".
+func metricModel(model string) string {
+	if model == "" {
+		return model
+	}
+	tagged := model
+	if !strings.Contains(model[strings.LastIndex(model, "/")+1:], ":") {
+		tagged += ":latest"
+	}
+	installedModels.Lock()
+	for _, names := range installedModels.byBackend {
+		if names[model] || names[tagged] {
+			installedModels.Unlock()
+			return model
+		}
+	}
+	installedModels.Unlock()
+	if catalog.Lists(model) {
+		return model
+	}
+	return otherModelLabel
+}
+
+// instrumentedTransport records the latency of every request to a backend.
+type instrumentedTransport struct {
+	base    http.RoundTripper
+	backend string
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamDuration.Observe(time.Since(start).Seconds(), t.backend, req.URL.Path, status)
+	return resp, err
+}
+
+// streamMetrics records the metrics of one generation stream.
+type streamMetrics struct {
+	action     string
+	model      string
+	start      time.Time
+	firstToken time.Duration // Zero until the first token arrived
+}
+
+// trackStream counts a generation stream as in flight until Done is called.
+func trackStream(action, model string) *streamMetrics {
+	inflightStreams.Add(1, action)
+	return &streamMetrics{action: action, model: metricModel(model), start: time.Now()}
+}
+
+// Chunk records time-to-first-token on the first generated text and tokens per
+// second on the final chunk, for which it also returns the usage of the stream.
+func (s *streamMetrics) Chunk(chunk OllamaResponseChunk) *GenerationUsage {
+	if s.firstToken == 0 && (chunk.Response != "" || (chunk.Message != nil && (chunk.Message.Content != "" || chunk.Message.Thinking != ""))) {
+		s.firstToken = time.Since(s.start)
+		timeToFirstToken.Observe(s.firstToken.Seconds(), s.action, s.model)
+	}
+	if !chunk.Done {
+		return nil
+	}
+	usage := newGenerationUsage(chunk, s.firstToken)
+	if usage.TokensPerSecond > 0 {
+		tokensPerSecond.Observe(usage.TokensPerSecond, s.model)
+	}
+	return &usage
+}
+
+// Done ends the stream.
+func (s *streamMetrics) Done() {
+	inflightStreams.Add(-1, s.action)
+}
+
+// --- Usage Tracking ---
+
+// GenerationUsage is the token usage and timing of one generation, taken from
+// Ollama's final chunk. It is sent to the UI as a "usage" SSE event before [DONE].
+type GenerationUsage struct {
+	PromptTokens         int     `json:"promptTokens"`
+	CompletionTokens     int     `json:"completionTokens"`
+	TotalDurationMs      float64 `json:"totalDurationMs"`
+	LoadDurationMs       float64 `json:"loadDurationMs"`
+	PromptEvalDurationMs float64 `json:"promptEvalDurationMs"`
+	EvalDurationMs       float64 `json:"evalDurationMs"`
+	TimeToFirstTokenMs   float64 `json:"timeToFirstTokenMs,omitempty"` // Measured by the proxy, including queueing and model load
+	TokensPerSecond      float64 `json:"tokensPerSecond,omitempty"`
+}
+
+// newGenerationUsage converts the statistics of a final chunk.
+func newGenerationUsage(chunk OllamaResponseChunk, firstToken time.Duration) GenerationUsage {
+	ms := func(ns int64) float64 { return float64(ns) / float64(time.Millisecond) }
+	usage := GenerationUsage{
+		PromptTokens:         chunk.PromptEvalCount,
+		CompletionTokens:     chunk.EvalCount,
+		TotalDurationMs:      ms(chunk.TotalDuration),
+		LoadDurationMs:       ms(chunk.LoadDuration),
+		PromptEvalDurationMs: ms(chunk.PromptEvalDuration),
+		EvalDurationMs:       ms(chunk.EvalDuration),
+		TimeToFirstTokenMs:   ms(int64(firstToken)),
+	}
+	if chunk.EvalCount > 0 && chunk.EvalDuration > 0 {
+		usage.TokensPerSecond = float64(chunk.EvalCount) / time.Duration(chunk.EvalDuration).Seconds()
+	}
+	return usage
+}
+
+// writeUsageEvent sends usage as a named SSE event. Clients that ignore event names see a chunk without content.
+func writeUsageEvent(w io.Writer, usage *GenerationUsage) {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		log.Printf("Error marshalling usage: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: usage\ndata: %s\n\n", data)
+}
+
+// UsageTotals accumulates the usage of many generations.
+type UsageTotals struct {
+	Requests         int       `json:"requests"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	TotalDurationMs  float64   `json:"totalDurationMs"`
+	EvalDurationMs   float64   `json:"evalDurationMs"`
+	LastUsedAt       time.Time `json:"lastUsedAt"`
+}
+
+func (t *UsageTotals) add(usage GenerationUsage, at time.Time) {
+	t.Requests++
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.TotalDurationMs += usage.TotalDurationMs
+	t.EvalDurationMs += usage.EvalDurationMs
+	t.LastUsedAt = at
+}
+
+// ModelUsage is the usage of one model by one user.
+type ModelUsage struct {
+	Username string `json:"username"`
+	Model    string `json:"model"`
+	UsageTotals
+}
+
+// ConversationUsage is the usage of one conversation.
+type ConversationUsage struct {
+	ConversationID string `json:"conversationId"`
+	Username       string `json:"username"`
+	UsageTotals
+}
+
+// UsageReport is the on-disk format of the usage log and the response of /api/usage.
+type UsageReport struct {
+	Models        []*ModelUsage        `json:"models"`
+	Conversations []*ConversationUsage `json:"conversations"`
+}
+
+// usageFlushInterval is how often recorded usage is written to disk.
+const usageFlushInterval = 10 * time.Second
+
+// UsageLog aggregates usage per user and model and per conversation, persisted
+// to a JSON file. Changes are written by Flush, not on every generation.
+type UsageLog struct {
+	mu            sync.Mutex
+	path          string
+	models        map[[2]string]*ModelUsage
+	conversations map[string]*ConversationUsage
+	dirty         bool       // Whether there are changes that Flush has not written yet
+	writeMu       sync.Mutex // Serializes writes of the file
+}
+
+// usageLog is the log used by all handlers; it is set up in main.
+var usageLog *UsageLog
+
+// openUsageLog loads the usage file at path, if it exists.
+func openUsageLog(path string) (*UsageLog, error) {
+	l := &UsageLog{path: path, models: make(map[[2]string]*ModelUsage), conversations: make(map[string]*ConversationUsage)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return l, nil
+	case err != nil:
+		return nil, err
+	}
+	var report UsageReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, m := range report.Models {
+		l.models[[2]string{m.Username, m.Model}] = m
+	}
+	for _, c := range report.Conversations {
+		l.conversations[c.ConversationID] = c
+	}
+	return l, nil
+}
+
+// Record adds the usage of one generation by username. conversationID may be empty.
+func (l *UsageLog) Record(username, model, conversationID string, usage GenerationUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	key := [2]string{username, model}
+	m, ok := l.models[key]
+	if !ok {
+		m = &ModelUsage{Username: username, Model: model}
+		l.models[key] = m
+	}
+	m.add(usage, now)
+
+	if conversationID != "" {
+		c, ok := l.conversations[conversationID]
+		if !ok {
+			c = &ConversationUsage{ConversationID: conversationID, Username: username}
+			l.conversations[conversationID] = c
+		}
+		c.add(usage, now)
+	}
+	l.dirty = true
+}
+
+// Forget drops the usage of a deleted conversation. The per-model totals keep it.
+func (l *UsageLog) Forget(conversationID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.conversations[conversationID]; ok {
+		delete(l.conversations, conversationID)
+		l.dirty = true
+	}
+}
+
+// Flush writes the usage file if anything changed since the last write.
+func (l *UsageLog) Flush() {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return
+	}
+	report := l.reportLocked("", true)
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err := writeJSONFileAtomic(l.path, report); err != nil {
+		log.Printf("Error saving usage log: %v", err)
+		l.mu.Lock()
+		l.dirty = true // Retried by the next flush
+		l.mu.Unlock()
+	}
+}
+
+// flushPeriodically writes recorded usage every usageFlushInterval.
+func (l *UsageLog) flushPeriodically() {
+	for {
+		time.Sleep(usageFlushInterval)
+		l.Flush()
+	}
+}
+
+// flushOnShutdown writes pending usage when the server is interrupted or terminated, then exits.
+func flushOnShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("Received %v, saving usage and shutting down", sig)
+	usageLog.Flush()
+	os.Exit(0)
+}
+
+// Report returns the usage of username, or of every user when all is set.
+func (l *UsageLog) Report(username string, all bool) UsageReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reportLocked(username, all)
+}
+
+// reportLocked copies the matching totals, sorted by user and then model or conversation. l.mu must be held.
+func (l *UsageLog) reportLocked(username string, all bool) UsageReport {
+	report := UsageReport{Models: []*ModelUsage{}, Conversations: []*ConversationUsage{}}
+	for _, m := range l.models {
+		if all || m.Username == username {
+			copied := *m
+			report.Models = append(report.Models, &copied)
+		}
+	}
+	for _, c := range l.conversations {
+		if all || c.Username == username {
+			copied := *c
+			report.Conversations = append(report.Conversations, &copied)
+		}
+	}
+	sort.Slice(report.Models, func(a, b int) bool {
+		if report.Models[a].Username != report.Models[b].Username {
+			return report.Models[a].Username < report.Models[b].Username
+		}
+		return report.Models[a].Model < report.Models[b].Model
+	})
+	sort.Slice(report.Conversations, func(a, b int) bool {
+		if report.Conversations[a].Username != report.Conversations[b].Username {
+			return report.Conversations[a].Username < report.Conversations[b].Username
+		}
+		return report.Conversations[a].ConversationID < report.Conversations[b].ConversationID
+	})
+	return report
+}
+
+// handleUsage returns the caller's usage. Users who manage users may pass ?all=true to see everyone's.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	if all && !authorize(w, r, PermManageUsers, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usageLog.Report(identityFrom(r.Context()).Username, all))
+}
+
+// --- Model Catalog ---
+
+// bundledCatalog is the catalog shipped with the binary; a remote catalog extends and overrides it.
+//
+//go:embed catalog.json
+var bundledCatalog []byte
+
+// CatalogConfig configures the optional remote model catalog.
+type CatalogConfig struct {
+	URL             string `json:"url"`             // JSON catalog in the same format as catalog.json
+	RefreshInterval string `json:"refreshInterval"` // How often the remote catalog is fetched, e.g. "24h"
+}
+
+// CatalogVariant is one installable tag of a catalog model.
+type CatalogVariant struct {
+	Tag         string  `json:"tag"`
+	Size        int64   `json:"size"` // Download size in bytes
+	Parameters  string  `json:"parameters,omitempty"`
+	MinMemoryGB float64 `json:"minMemoryGB,omitempty"` // Recommended RAM or VRAM to run it
+}
+
+// CatalogEntry is a model that can be installed from the registry.
+type CatalogEntry struct {
+	Name         string           `json:"name"`
+	Description  string           `json:"description"`
+	Capabilities []string         `json:"capabilities"`
+	Tags         []CatalogVariant `json:"tags"`
+	Source       string           `json:"source"` // "bundled" or "remote"
+}
+
+// catalogFile is the format of catalog.json, of remote catalogs and of the on-disk cache.
+type catalogFile struct {
+	Models    []CatalogEntry `json:"models"`
+	FetchedAt time.Time      `json:"fetchedAt,omitempty"` // Cache only
+}
+
+// ModelCatalog merges the bundled catalog with a remote one cached on disk.
+type ModelCatalog struct {
+	mu        sync.Mutex
+	url       string
+	cachePath string
+	bundled   []CatalogEntry
+	remote    []CatalogEntry
+	fetchedAt time.Time
+}
+
+// catalog is the catalog used by all handlers; it is set up in main.
+var catalog *ModelCatalog
+
+// Defaults for fetching the remote catalog.
+const (
+	defaultCatalogRefresh = 24 * time.Hour
+	catalogRetryDelay     = 10 * time.Minute
+)
+
+// parseCatalog decodes a catalog file and checks that every entry has a name.
+func parseCatalog(data []byte, source string) ([]CatalogEntry, error) {
+	var file catalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for i := range file.Models {
+		if file.Models[i].Name == "" {
+			return nil, fmt.Errorf("catalog entry %d has no name", i)
+		}
+		file.Models[i].Source = source
+	}
+	return file.Models, nil
+}
+
+// newModelCatalog loads the bundled catalog and, when a URL is configured, the
+// cached remote catalog, then keeps the remote catalog fresh in the background.
+func newModelCatalog(cfg CatalogConfig, cachePath string) (*ModelCatalog, error) {
+	bundled, err := parseCatalog(bundledCatalog, "bundled")
+	if err != nil {
+		return nil, fmt.Errorf("bundled catalog: %w", err)
+	}
+	c := &ModelCatalog{url: cfg.URL, cachePath: cachePath, bundled: bundled}
+	if cfg.URL == "" {
+		return c, nil
+	}
+
+	refresh := defaultCatalogRefresh
+	if cfg.RefreshInterval != "" {
+		refresh, err = time.ParseDuration(cfg.RefreshInterval)
+		if err != nil || refresh <= 0 {
+			return nil, fmt.Errorf("invalid catalog.refreshInterval %q", cfg.RefreshInterval)
+		}
+	}
+
+	if data, err := os.ReadFile(cachePath); err == nil {
+		var cached catalogFile
+		if err := json.Unmarshal(data, &cached); err != nil {
+			log.Printf("Ignoring unreadable catalog cache %s: %v", cachePath, err)
+		} else {
+			c.remote, c.fetchedAt = cached.Models, cached.FetchedAt
+		}
+	}
+
+	go func() {
+		for {
+			wait := refresh - time.Since(c.FetchedAt())
+			if wait <= 0 {
+				wait = refresh
+				if err := c.Refresh(context.Background()); err != nil {
+					log.Printf("Error refreshing model catalog from %s: %v", c.url, err)
+					wait = catalogRetryDelay
+				}
+			}
+			time.Sleep(wait)
+		}
+	}()
+	return c, nil
+}
+
+// FetchedAt returns when the remote catalog was last fetched.
+func (c *ModelCatalog) FetchedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetchedAt
+}
+
+// Refresh fetches the remote catalog and writes it to the cache.
+func (c *ModelCatalog) Refresh(ctx context.Context) error {
+	if c.url == "" {
+		return errors.New("no catalog URL is configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("catalog URL returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	entries, err := parseCatalog(data, "remote")
+	if err != nil {
+		return fmt.Errorf("parsing remote catalog: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remote = entries
+	c.fetchedAt = time.Now()
+	log.Printf("Loaded %d catalog entries from %s", len(entries), c.url)
+	if err := os.MkdirAll(filepath.Dir(c.cachePath), 0o700); err != nil {
+		return err
+	}
+	return writeJSONFileAtomic(c.cachePath, catalogFile{Models: c.remote, FetchedAt: c.fetchedAt})
+}
+
+// CatalogFilter selects catalog entries. Zero values do not filter.
+type CatalogFilter struct {
+	Query       string  // Case-insensitive substring of the name or description
+	Capability  string  // Required capability, e.g. "embedding"
+	MaxSize     int64   // Keep only entries with a tag no larger than this many bytes
+	MaxMemoryGB float64 // Keep only entries with a tag that runs in this much memory
+}
+
+// matches reports whether an entry passes the filter. Size and memory limits
+// also drop the tags of the entry that exceed them.
+func (f CatalogFilter) matches(entry *CatalogEntry) bool {
+	if f.Query != "" {
+		q := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(entry.Name), q) && !strings.Contains(strings.ToLower(entry.Description), q) {
+			return false
+		}
+	}
+	if f.Capability != "" && !containsString(entry.Capabilities, f.Capability) {
+		return false
+	}
+	if f.MaxSize > 0 || f.MaxMemoryGB > 0 {
+		fitting := []CatalogVariant{}
+		for _, v := range entry.Tags {
+			if (f.MaxSize <= 0 || v.Size <= f.MaxSize) && (f.MaxMemoryGB <= 0 || v.MinMemoryGB <= f.MaxMemoryGB) {
+				fitting = append(fitting, v)
+			}
+		}
+		if len(fitting) == 0 {
+			return false
+		}
+		entry.Tags = fitting
+	}
+	return true
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists reports whether a model name, with or without a tag, is in the catalog.
+func (c *ModelCatalog) Lists(model string) bool {
+	name, tag, _ := strings.Cut(model, ":")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entries := range [][]CatalogEntry{c.bundled, c.remote} {
+		for _, e := range entries {
+			if e.Name != name {
+				continue
+			}
+			if tag == "" || tag == "latest" {
+				return true
+			}
+			for _, v := range e.Tags {
+				if v.Tag == tag {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Entries returns the merged catalog sorted by name; remote entries replace bundled ones of the same name.
+func (c *ModelCatalog) Entries(filter CatalogFilter) []CatalogEntry {
+	c.mu.Lock()
+	byName := make(map[string]CatalogEntry, len(c.bundled)+len(c.remote))
+	for _, e := range c.bundled {
+		byName[e.Name] = e
+	}
+	for _, e := range c.remote {
+		byName[e.Name] = e
+	}
+	c.mu.Unlock()
+
+	entries := []CatalogEntry{}
+	for _, e := range byName {
+		if filter.matches(&e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Name < entries[b].Name })
+	return entries
+}
+
+// handleCatalog serves the model catalog. Query parameters: q, capability,
+// maxSize (bytes) and maxMemoryGB.
+func handleCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	filter := CatalogFilter{Query: query.Get("q"), Capability: query.Get("capability")}
+	if v := query.Get("maxSize"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "maxSize must be a number of bytes", http.StatusBadRequest)
+			return
+		}
+		filter.MaxSize = size
+	}
+	if v := query.Get("maxMemoryGB"); v != "" {
+		mem, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, "maxMemoryGB must be a number", http.StatusBadRequest)
+			return
+		}
+		filter.MaxMemoryGB = mem
+	}
+
+	response := map[string]any{"models": catalog.Entries(filter)}
+	if fetchedAt := catalog.FetchedAt(); !fetchedAt.IsZero() {
+		response["remoteFetchedAt"] = fetchedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleRefreshCatalog fetches the remote catalog now instead of waiting for the next refresh.
+func handleRefreshCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(w, r, PermPullModels, "") {
+		return
+	}
+	if err := catalog.Refresh(r.Context()); err != nil {
+		log.Printf("Error refreshing model catalog: %v", err)
+		http.Error(w, "Error refreshing catalog: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Embeddings ---
+
+// Limits for embed requests. Inputs are sent to Ollama in batches so a large
+// request does not hold one huge upstream call.
+const (
+	embedBatchSize = 32
+	maxEmbedInputs = 4096
+)
+
+// EmbedResponse is the JSON response of the embed action and /api/embed.
+type EmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	Dimensions      int         `json:"dimensions"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// embedInBatches calls /api/embed for batches of at most embedBatchSize inputs and concatenates the results.
+func embedInBatches(ctx context.Context, backend *Backend, client *http.Client, payload OllamaEmbedRequestPayload) (*OllamaEmbedResponse, error) {
+	result := &OllamaEmbedResponse{Model: payload.Model, Embeddings: make([][]float64, 0, len(payload.Input))}
+	inputs := payload.Input
+	for start := 0; start < len(inputs); start += embedBatchSize {
+		batch := payload
+		batch.Input = inputs[start:min(start+embedBatchSize, len(inputs))]
+		resp, err := callOllamaEmbed(ctx, backend, client, batch)
+		if err != nil {
+			return nil, err
+		}
+		// Callers index the vectors by input position, so a short answer must not pass.
+		if len(resp.Embeddings) != len(batch.Input) {
+			return nil, fmt.Errorf("Ollama returned %d embeddings for %d inputs", len(resp.Embeddings), len(batch.Input))
+		}
+		result.Embeddings = append(result.Embeddings, resp.Embeddings...)
+		result.PromptEvalCount += resp.PromptEvalCount
+		result.TotalDuration += resp.TotalDuration
+	}
+	return result, nil
+}
+
+// callEmbedAPI handles the embed action: it embeds clientReq.Input and writes
+// the vectors as JSON, or as float32 binary when requested.
+func callEmbedAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client) {
+	inputs, err := parseStringOrList(clientReq.Input)
+	if err == nil && len(inputs) == 0 && clientReq.Prompt != "" {
+		inputs = []string{clientReq.Prompt}
+	}
+	asBinary := clientReq.Encoding == "float32" || (clientReq.Encoding == "" && r.Header.Get("Accept") == "application/octet-stream")
+	keepAlive, validateErr := validateCommonParams(clientReq)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("input: %w", err)
+	case validateErr != nil:
+		err = validateErr
+	case len(inputs) == 0:
+		err = errors.New("input must not be empty")
+	case len(inputs) > maxEmbedInputs:
+		err = fmt.Errorf("at most %d inputs can be embedded at once", maxEmbedInputs)
+	case clientReq.Dimensions < 0:
+		err = errors.New("dimensions must not be negative")
+	case clientReq.Encoding != "" && clientReq.Encoding != "json" && clientReq.Encoding != "float32":
+		err = errors.New(`encoding must be "json" or "float32"`)
+	}
+	if err != nil {
+		http.Error(w, "Invalid embed parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	embedResp, err := embedInBatches(r.Context(), backend, client, OllamaEmbedRequestPayload{
+		Model:      clientReq.Model,
+		Input:      inputs,
+		Truncate:   clientReq.Truncate,
+		Dimensions: clientReq.Dimensions,
+		Options:    clientReq.Options,
+		KeepAlive:  keepAlive,
+	})
+	if err != nil {
+		writeUpstreamError(w, backend, "embed", err)
+		return
+	}
+	usageLog.Record(identityFrom(r.Context()).Username, clientReq.Model, "", GenerationUsage{
+		PromptTokens:    embedResp.PromptEvalCount,
+		TotalDurationMs: float64(embedResp.TotalDuration) / float64(time.Millisecond),
+	})
+
+	dimensions := 0
+	if len(embedResp.Embeddings) > 0 {
+		dimensions = len(embedResp.Embeddings[0])
+	}
+	if !asBinary {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(EmbedResponse{
+			Model:           clientReq.Model,
+			Embeddings:      embedResp.Embeddings,
+			Dimensions:      dimensions,
+			PromptEvalCount: embedResp.PromptEvalCount,
+		})
+		return
+	}
+
+	// Binary vectors are count*dimensions little-endian float32 values, row by row.
+	buf := make([]byte, 0, 4*dimensions*len(embedResp.Embeddings))
+	for _, vector := range embedResp.Embeddings {
+		if len(vector) != dimensions {
+			http.Error(w, "Ollama returned vectors of different lengths", http.StatusBadGateway)
+			return
+		}
+		for _, v := range vector {
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v)))
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Embedding-Count", strconv.Itoa(len(embedResp.Embeddings)))
+	w.Header().Set("X-Embedding-Dimensions", strconv.Itoa(dimensions))
+	w.Header().Set("X-Prompt-Eval-Count", strconv.Itoa(embedResp.PromptEvalCount))
+	w.Write(buf)
+}
+
+// handleEmbed is the REST form of the embed action. It accepts the embed
+// fields of ClientRequest (model, input, truncate, dimensions, encoding,
+// options, keepAlive and backend).
+func handleEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var clientReq ClientRequest
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { recordRequest("embed", clientReq.Model, rec.status) }()
+
+	if err := json.NewDecoder(r.Body).Decode(&clientReq); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientReq.ActionType = "embed"
+	if clientReq.Backend == "" {
+		clientReq.Backend = r.URL.Query().Get("backend")
+	}
+	runAction(w, r, clientReq)
+}
+
+// --- Document Collections ---
+
+// Defaults for document ingestion and retrieval.
+const (
+	maxDocumentSize   = 20 << 20            // Bytes per uploaded file
+	maxExtractedText  = 4 * maxDocumentSize // Bytes inflated from one PDF stream, and of text extracted from one PDF
+	chunkSize         = 1200                // Target characters per chunk
+	chunkOverlap      = 200                 // Characters repeated between consecutive chunks of a long paragraph
+	defaultTopK       = 4
+	maxTopK           = 20
+	maxCitationLength = 300 // Characters of chunk text sent with each citation
+)
+
+// collectionNamePattern restricts collection names to what is safe in URLs and file names.
+var collectionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// embeddingVector is stored as base64 little-endian float32, which is about a
+// quarter of the size of a JSON number array.
+type embeddingVector []float32
+
+func (v embeddingVector) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(buf))
+}
+
+func (v *embeddingVector) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if len(buf)%4 != 0 {
+		return errors.New("embedding length is not a multiple of 4 bytes")
+	}
+	*v = make(embeddingVector, len(buf)/4)
+	for i := range *v {
+		(*v)[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return nil
+}
+
+// newEmbeddingVector converts an Ollama embedding to a unit-length float32 vector, so that
+// cosine similarity is a dot product.
+func newEmbeddingVector(values []float64) embeddingVector {
+	var norm float64
+	for _, x := range values {
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	v := make(embeddingVector, len(values))
+	for i, x := range values {
+		if norm > 0 {
+			v[i] = float32(x / norm)
+		}
+	}
+	return v
+}
+
+// CollectionDocument is a file added to a collection.
+type CollectionDocument struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // "text", "markdown" or "pdf"
+	Size    int64     `json:"size"`
+	Chunks  int       `json:"chunks"`
+	AddedAt time.Time `json:"addedAt"`
+	AddedBy string    `json:"addedBy,omitempty"`
+}
+
+// CollectionChunk is an embedded piece of a document.
+type CollectionChunk struct {
+	DocumentID string          `json:"documentId"`
+	Index      int             `json:"index"`
+	Text       string          `json:"text"`
+	Vector     embeddingVector `json:"vector"`
+}
+
+// Collection is a named set of documents embedded with one model on one backend.
+type Collection struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	EmbedModel  string               `json:"embedModel"`
+	Backend     string               `json:"backend,omitempty"` // Empty uses the default backend
+	CreatedBy   string               `json:"createdBy,omitempty"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	UpdatedAt   time.Time            `json:"updatedAt"`
+	Documents   []CollectionDocument `json:"documents"`
+	Chunks      []CollectionChunk    `json:"chunks,omitempty"`
+}
+
+// CollectionSummary is a collection without its chunks, as returned by the API.
+type CollectionSummary struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	EmbedModel  string               `json:"embedModel"`
+	Backend     string               `json:"backend,omitempty"`
+	CreatedBy   string               `json:"createdBy,omitempty"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	UpdatedAt   time.Time            `json:"updatedAt"`
+	Documents   []CollectionDocument `json:"documents"`
+	ChunkCount  int                  `json:"chunkCount"`
+}
+
+func (c *Collection) summary() CollectionSummary {
+	docs := append([]CollectionDocument{}, c.Documents...)
+	return CollectionSummary{
+		Name: c.Name, Description: c.Description, EmbedModel: c.EmbedModel, Backend: c.Backend,
+		CreatedBy: c.CreatedBy, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt,
+		Documents: docs, ChunkCount: len(c.Chunks),
+	}
+}
+
+// CollectionRequest is the body accepted by POST /api/collections.
+type CollectionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	EmbedModel  string `json:"embedModel"`
+	Backend     string `json:"backend"`
+}
+
+// Citation identifies a retrieved chunk used as context for an answer.
+type Citation struct {
+	Index      int     `json:"index"` // Number used in the injected context, e.g. [1]
+	DocumentID string  `json:"documentId"`
+	Document   string  `json:"document"`
+	Chunk      int     `json:"chunk"`
+	Score      float64 `json:"score"` // Cosine similarity to the question
+	Text       string  `json:"text"`
+}
+
+var (
+	errCollectionNotFound = errors.New("collection not found")
+	errCollectionExists   = errors.New("a collection with this name already exists")
+	errDocumentNotFound   = errors.New("document not found")
+	errNoDocumentText     = errors.New("no text could be extracted from the document")
+)
+
+// CollectionStore keeps collections in memory and persists each one to a JSON file.
+type CollectionStore struct {
+	mu          sync.Mutex
+	dir         string
+	collections map[string]*Collection
+}
+
+// collections is the store used by all handlers; it is set up in main.
+var collections *CollectionStore
+
+// openCollectionStore creates dir if needed and loads every collection in it.
+func openCollectionStore(dir string) (*CollectionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	store := &CollectionStore{dir: dir, collections: make(map[string]*Collection)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var coll Collection
+		if err := json.Unmarshal(data, &coll); err != nil {
+			log.Printf("Skipping unreadable collection file %s: %v", entry.Name(), err)
+			continue
+		}
+		store.collections[coll.Name] = &coll
+	}
+	log.Printf("Loaded %d document collections from %s", len(store.collections), dir)
+	return store, nil
+}
+
+// saveLocked writes a collection atomically. s.mu must be held.
+func (s *CollectionStore) saveLocked(coll *Collection) error {
+	return writeJSONFileAtomic(filepath.Join(s.dir, coll.Name+".json"), coll)
+}
+
+// List returns all collections sorted by name.
+func (s *CollectionStore) List() []CollectionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]CollectionSummary, 0, len(s.collections))
+	for _, coll := range s.collections {
+		list = append(list, coll.summary())
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return list
+}
+
+// Get returns the summary of a collection.
+func (s *CollectionStore) Get(name string) (CollectionSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll, ok := s.collections[name]
+	if !ok {
+		return CollectionSummary{}, errCollectionNotFound
+	}
+	return coll.summary(), nil
+}
+
+// Create adds an empty collection.
+func (s *CollectionStore) Create(req CollectionRequest, createdBy string) (CollectionSummary, error) {
+	if !collectionNamePattern.MatchString(req.Name) {
+		return CollectionSummary{}, errors.New("name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if req.EmbedModel == "" {
+		return CollectionSummary{}, errors.New("embedModel is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.collections[req.Name]; exists {
+		return CollectionSummary{}, errCollectionExists
+	}
+	now := time.Now()
+	coll := &Collection{
+		Name: req.Name, Description: req.Description, EmbedModel: req.EmbedModel, Backend: req.Backend,
+		CreatedBy: createdBy, CreatedAt: now, UpdatedAt: now, Documents: []CollectionDocument{},
+	}
+	if err := s.saveLocked(coll); err != nil {
+		return CollectionSummary{}, err
+	}
+	s.collections[coll.Name] = coll
+	return coll.summary(), nil
+}
+
+// Delete removes a collection and its file.
+func (s *CollectionStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.collections[name]; !ok {
+		return errCollectionNotFound
+	}
+	if err := os.Remove(filepath.Join(s.dir, name+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.collections, name)
+	return nil
+}
+
+// embedSettings returns the model and backend a collection embeds with.
+func (s *CollectionStore) embedSettings(name string) (model, backendName string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll, ok := s.collections[name]
+	if !ok {
+		return "", "", errCollectionNotFound
+	}
+	return coll.EmbedModel, coll.Backend, nil
+}
+
+// embedTexts embeds texts with a collection's model and backend.
+func (s *CollectionStore) embedTexts(ctx context.Context, name string, texts []string) ([]embeddingVector, error) {
+	model, backendName, err := s.embedSettings(name)
+	if err != nil {
+		return nil, err
+	}
+	backend, ok := backends.Get(backendName)
+	if !ok {
+		return nil, fmt.Errorf("backend %q of collection %q is not configured", backendName, name)
+	}
+	resp, err := embedInBatches(ctx, backend, backend.Client(300*time.Second), OllamaEmbedRequestPayload{Model: model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([]embeddingVector, len(resp.Embeddings))
+	for i, values := range resp.Embeddings {
+		vectors[i] = newEmbeddingVector(values)
+	}
+	return vectors, nil
+}
+
+// AddDocument extracts, chunks and embeds a file and adds it to a collection.
+func (s *CollectionStore) AddDocument(ctx context.Context, name, fileName string, data []byte, addedBy string) (CollectionDocument, error) {
+	docType, text, err := extractDocumentText(fileName, data)
+	if err != nil {
+		return CollectionDocument{}, err
+	}
+	texts := chunkText(text, chunkSize, chunkOverlap)
+	if len(texts) == 0 {
+		return CollectionDocument{}, errNoDocumentText
+	}
+	// Embedding can take a while, so it runs without holding the lock.
+	vectors, err := s.embedTexts(ctx, name, texts)
+	if err != nil {
+		return CollectionDocument{}, err
+	}
+
+	doc := CollectionDocument{
+		ID: newID(), Name: filepath.Base(fileName), Type: docType, Size: int64(len(data)),
+		Chunks: len(texts), AddedAt: time.Now(), AddedBy: addedBy,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll, ok := s.collections[name]
+	if !ok {
+		return CollectionDocument{}, errCollectionNotFound
+	}
+	for i, t := range texts {
+		coll.Chunks = append(coll.Chunks, CollectionChunk{DocumentID: doc.ID, Index: i, Text: t, Vector: vectors[i]})
+	}
+	coll.Documents = append(coll.Documents, doc)
+	coll.UpdatedAt = doc.AddedAt
+	return doc, s.saveLocked(coll)
+}
+
+// RemoveDocument deletes a document and its chunks from a collection.
+func (s *CollectionStore) RemoveDocument(name, documentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll, ok := s.collections[name]
+	if !ok {
+		return errCollectionNotFound
+	}
+	found := false
+	docs := coll.Documents[:0]
+	for _, d := range coll.Documents {
+		if d.ID == documentID {
+			found = true
+			continue
+		}
+		docs = append(docs, d)
+	}
+	if !found {
+		return errDocumentNotFound
+	}
+	coll.Documents = docs
+	chunks := coll.Chunks[:0]
+	for _, c := range coll.Chunks {
+		if c.DocumentID != documentID {
+			chunks = append(chunks, c)
+		}
+	}
+	coll.Chunks = chunks
+	coll.UpdatedAt = time.Now()
+	return s.saveLocked(coll)
+}
+
+// Search returns the topK chunks most similar to query.
+func (s *CollectionStore) Search(ctx context.Context, name, query string, topK int) ([]Citation, error) {
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	topK = min(topK, maxTopK)
+	vectors, err := s.embedTexts(ctx, name, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll, ok := s.collections[name]
+	if !ok {
+		return nil, errCollectionNotFound
+	}
+	docNames := make(map[string]string, len(coll.Documents))
+	for _, d := range coll.Documents {
+		docNames[d.ID] = d.Name
+	}
+
+	results := make([]Citation, 0, len(coll.Chunks))
+	for _, c := range coll.Chunks {
+		if len(c.Vector) != len(q) {
+			continue // Embedded with a different model or dimensions
+		}
+		var score float64
+		for i := range q {
+			score += float64(q[i]) * float64(c.Vector[i])
+		}
+		results = append(results, Citation{DocumentID: c.DocumentID, Document: docNames[c.DocumentID], Chunk: c.Index, Score: score, Text: c.Text})
+	}
+	sort.Slice(results, func(a, b int) bool { return results[a].Score > results[b].Score })
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	for i := range results {
+		results[i].Index = i + 1
+	}
+	return results, nil
+}
+
+// retrievalPrompt formats retrieved chunks as the system context for a question.
+func retrievalPrompt(citations []Citation) string {
+	var b strings.Builder
+	b.WriteString("Answer the user's question using the following excerpts from the document collection. ")
+	b.WriteString("Cite the excerpts you use by their number, e.g. [1]. If the excerpts do not contain the answer, say so.\n")
+	for _, c := range citations {
+		fmt.Fprintf(&b, "\n[%d] %s (part %d):\n%s\n", c.Index, c.Document, c.Chunk+1, c.Text)
+	}
+	return b.String()
+}
+
+// injectRetrievalContext inserts the retrieval prompt as a system message right before the last user message.
+func injectRetrievalContext(messages []Message, citations []Citation) []Message {
+	contextMsg := Message{Role: "system", Content: retrievalPrompt(citations)}
+	if len(messages) == 0 {
+		return []Message{contextMsg}
+	}
+	last := len(messages) - 1
+	out := make([]Message, 0, len(messages)+1)
+	out = append(out, messages[:last]...)
+	out = append(out, contextMsg, messages[last])
+	return out
+}
+
+// trimCitations shortens citation texts for sending to the client.
+func trimCitations(citations []Citation) []Citation {
+	trimmed := make([]Citation, len(citations))
+	for i, c := range citations {
+		if runes := []rune(c.Text); len(runes) > maxCitationLength {
+			c.Text = string(runes[:maxCitationLength]) + "..."
+		}
+		trimmed[i] = c
+	}
+	return trimmed
+}
+
+// collectionErrorStatus maps store errors to HTTP status codes.
+func collectionErrorStatus(err error) int {
+	var upErr *upstreamError
+	var connErr *connectError
+	switch {
+	case errors.Is(err, errCollectionNotFound), errors.Is(err, errDocumentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errCollectionExists):
+		return http.StatusConflict
+	case errors.As(err, &upErr), errors.As(err, &connErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// --- Document Text Extraction ---
+
+// extractDocumentText returns the type and plain text of an uploaded file, chosen by its extension.
+func extractDocumentText(fileName string, data []byte) (string, string, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".pdf":
+		text := extractPDFText(data)
+		if strings.TrimSpace(text) == "" {
+			return "", "", errors.New("no text could be extracted from the PDF; scanned or image-only PDFs are not supported")
+		}
+		return "pdf", text, nil
+	case ".md", ".markdown":
+		return "markdown", string(bytes.ToValidUTF8(data, nil)), nil
+	case ".txt", ".text", "":
+		return "text", string(bytes.ToValidUTF8(data, nil)), nil
+	default:
+		return "", "", fmt.Errorf("unsupported file type %q; upload .txt, .md or .pdf files", filepath.Ext(fileName))
+	}
+}
+
+// chunkText splits text into chunks of about size characters. Paragraphs are
+// kept together where possible and Markdown headings start a new chunk;
+// paragraphs longer than size are split with overlap characters repeated.
+func chunkText(text string, size, overlap int) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if t := strings.TrimSpace(current.String()); t != "" {
+			chunks = append(chunks, t)
+		}
+		current.Reset()
+	}
+
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if strings.HasPrefix(para, "#") {
+			flush()
+		}
+		if len(para) <= size && current.Len() > 0 && current.Len()+len(para)+2 > size {
+			flush()
+		}
+		if len(para) <= size {
+			if current.Len() > 0 {
+				current.WriteString("\n\n")
+			}
+			current.WriteString(para)
+			continue
+		}
+		// A pending heading stays with the first piece of the long paragraph.
+		prefix := ""
+		if c := current.String(); strings.HasPrefix(c, "#") && !strings.Contains(c, "\n") {
+			prefix = current.String() + "\n\n"
+			current.Reset()
+		}
+		flush()
+		runes := []rune(para)
+		for start := 0; start < len(runes); start += size - overlap {
+			end := min(start+size, len(runes))
+			chunks = append(chunks, prefix+string(runes[start:end]))
+			prefix = ""
+			if end == len(runes) {
+				break
+			}
+		}
+	}
+	flush()
+	return chunks
+}
+
+// maxPDFStreamDict bounds how far before a stream keyword its object header is looked for.
+const maxPDFStreamDict = 4 << 10
+
+// extractPDFText pulls the text shown by the content streams of a PDF. It
+// handles uncompressed and Flate-compressed streams with literal and hex
+// strings; fonts with custom encodings may yield unreadable text. Both the
+// inflated streams and the extracted text are capped at maxExtractedText.
+func extractPDFText(data []byte) string {
+	var out strings.Builder
+	pos := 0
+	for out.Len() < maxExtractedText {
+		idx := bytes.Index(data[pos:], []byte("stream"))
+		if idx < 0 {
+			break
+		}
+		idx += pos
+		pos = idx + len("stream")
+		if idx >= 3 && string(data[idx-3:idx]) == "end" {
+			continue
+		}
+
+		// The stream dictionary sits between the object header and the stream
+		// keyword. Only a window is searched, so that the scan stays linear.
+		windowStart := max(0, idx-maxPDFStreamDict)
+		dictStart := bytes.LastIndex(data[windowStart:idx], []byte(" obj"))
+		if dictStart < 0 {
+			continue
+		}
+		dict := data[windowStart+dictStart : idx]
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/Length1")) || bytes.Contains(dict, []byte("/FontFile")) {
+			continue
+		}
+
+		start := pos
+		if start < len(data) && data[start] == '\r' {
+			start++
+		}
+		if start < len(data) && data[start] == '\n' {
+			start++
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		content := data[start : start+end]
+		pos = start + end + len("endstream")
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, _ = io.ReadAll(io.LimitReader(zr, maxExtractedText)) // Keep whatever was inflated before an error
+			zr.Close()
+		}
+		if text := pdfContentText(content); text != "" {
+			out.WriteString(text)
+			out.WriteString("\n\n")
+		}
+	}
+	if out.Len() > maxExtractedText {
+		return strings.ToValidUTF8(out.String()[:maxExtractedText], "")
+	}
+	return out.String()
+}
+
+// pdfContentText interprets the text operators of a PDF content stream.
+func pdfContentText(content []byte) string {
+	var out strings.Builder
+	inText := false
+	newline := func() {
+		if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			str, next := pdfLiteralString(content, i)
+			if inText {
+				out.WriteString(str)
+			}
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return out.String()
+			}
+			if inText {
+				out.WriteString(pdfHexString(content[i+1 : i+end]))
+			}
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '-' || (c >= '0' && c <= '9') || c == '.':
+			start := i
+			for i < len(content) && (content[i] == '-' || content[i] == '.' || (content[i] >= '0' && content[i] <= '9')) {
+				i++
+			}
+			// Large negative adjustments inside TJ arrays separate words.
+			if n, err := strconv.ParseFloat(string(content[start:i]), 64); err == nil && inText && n < -250 {
+				out.WriteByte(' ')
+			}
+		case (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '\'' || c == '"' || c == '*':
+			start := i
+			for i < len(content) && ((content[i] >= 'A' && content[i] <= 'Z') || (content[i] >= 'a' && content[i] <= 'z') || content[i] == '\'' || content[i] == '"' || content[i] == '*') {
+				i++
+			}
+			switch string(content[start:i]) {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				newline()
+			case "Td", "TD", "T*", "Tm", "'", "\"":
+				if inText {
+					newline()
+				}
+			}
+		default:
+			i++
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// pdfLiteralString decodes a (...) string starting at content[start] and returns it with the index after it.
+func pdfLiteralString(content []byte, start int) (string, int) {
+	var b strings.Builder
+	depth := 0
+	i := start
+	for i < len(content) {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content):
+			i++
+			switch e := content[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					for j := 0; j < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+						n = n*8 + int(content[i]-'0')
+						i++
+					}
+					b.WriteRune(rune(n))
+					continue
+				}
+				b.WriteByte(e)
+			}
+			i++
+		case c == '(':
+			if depth > 0 {
+				b.WriteByte(c)
+			}
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+			if depth == 0 {
+				return b.String(), i
+			}
+			b.WriteByte(c)
+		default:
+			if c < 0x80 {
+				b.WriteByte(c)
+			} else {
+				b.WriteRune(rune(c)) // PDFDocEncoding is close to Latin-1
+			}
+			i++
+		}
+	}
+	return b.String(), i
+}
+
+// pdfHexString decodes a <...> string, treating a leading byte order mark as UTF-16BE.
+func pdfHexString(hexDigits []byte) string {
+	clean := make([]byte, 0, len(hexDigits))
+	for _, c := range hexDigits {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			clean = append(clean, c)
+		}
+	}
+	if len(clean)%2 == 1 {
+		clean = append(clean, '0')
+	}
+	raw, err := hex.DecodeString(string(clean))
+	if err != nil {
+		return ""
+	}
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		var b strings.Builder
+		for i := 2; i+1 < len(raw); i += 2 {
+			b.WriteRune(rune(raw[i])<<8 | rune(raw[i+1]))
+		}
+		return b.String()
+	}
+	var b strings.Builder
+	for _, c := range raw {
+		if c >= 0x20 || c == '\n' || c == '\t' {
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
+
+// --- Collection Handlers ---
+
+// handleCollections lists collections (GET) or creates one (POST).
+func handleCollections(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !authorize(w, r, PermUseModels, "") {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]CollectionSummary{"collections": collections.List()})
+	case http.MethodPost:
+		var collReq CollectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&collReq); err != nil {
+			http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !authorize(w, r, PermManageCollections, collReq.EmbedModel) {
+			return
+		}
+		if _, ok := resolveBackend(w, collReq.Backend); !ok {
+			return
+		}
+		coll, err := collections.Create(collReq, identityFrom(r.Context()).Username)
+		if err != nil {
+			http.Error(w, "Error creating collection: "+err.Error(), collectionErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(coll)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCollection returns (GET) or deletes (DELETE) a collection.
+func handleCollection(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		if !authorize(w, r, PermUseModels, "") {
+			return
+		}
+		coll, err := collections.Get(name)
+		if err != nil {
+			http.Error(w, err.Error(), collectionErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(coll)
+	case http.MethodDelete:
+		if !authorize(w, r, PermManageCollections, "") {
+			return
+		}
+		if err := collections.Delete(name); err != nil {
+			http.Error(w, "Error deleting collection: "+err.Error(), collectionErrorStatus(err))
+			return
+		}
+		log.Printf("User %q deleted collection %q", identityFrom(r.Context()).Username, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCollectionDocuments adds the files of a multipart upload (field "file", repeatable) to a collection.
+func handleCollectionDocuments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.PathValue("name")
+	model, _, err := collections.embedSettings(name)
+	if err != nil {
+		http.Error(w, err.Error(), collectionErrorStatus(err))
+		return
+	}
+	if !authorize(w, r, PermManageCollections, model) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 5*maxDocumentSize)
+	if err := r.ParseMultipartForm(maxDocumentSize); err != nil {
+		http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		http.Error(w, `Upload at least one file in the "file" field`, http.StatusBadRequest)
+		return
+	}
+
+	added := []CollectionDocument{}
+	for _, header := range files {
+		if header.Size > maxDocumentSize {
+			http.Error(w, fmt.Sprintf("%s is larger than %d MB", header.Filename, maxDocumentSize>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			http.Error(w, "Error reading upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, "Error reading upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		doc, err := collections.AddDocument(r.Context(), name, header.Filename, data, identityFrom(r.Context()).Username)
+		if err != nil {
+			log.Printf("Error adding %s to collection %q: %v", header.Filename, name, err)
+			http.Error(w, header.Filename+": "+err.Error(), collectionErrorStatus(err))
+			return
+		}
+		log.Printf("Added %s to collection %q as %d chunks", doc.Name, name, doc.Chunks)
+		added = append(added, doc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string][]CollectionDocument{"documents": added})
+}
+
+// handleCollectionDocument removes a document from a collection.
+func handleCollectionDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(w, r, PermManageCollections, "") {
+		return
+	}
+	if err := collections.RemoveDocument(r.PathValue("name"), r.PathValue("doc")); err != nil {
+		http.Error(w, err.Error(), collectionErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CollectionSearchRequest is the body accepted by /api/collections/{name}/search.
+type CollectionSearchRequest struct {
+	Query string `json:"query"`
+	TopK  int    `json:"topK"`
+}
+
+// handleCollectionSearch returns the chunks most similar to a query.
+func handleCollectionSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.PathValue("name")
+	var searchReq CollectionSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&searchReq); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(searchReq.Query) == "" {
+		http.Error(w, "query must not be empty", http.StatusBadRequest)
+		return
+	}
+	model, _, err := collections.embedSettings(name)
+	if err != nil {
+		http.Error(w, err.Error(), collectionErrorStatus(err))
+		return
+	}
+	if !authorize(w, r, PermUseModels, model) {
+		return
+	}
+	results, err := collections.Search(r.Context(), name, searchReq.Query, searchReq.TopK)
+	if err != nil {
+		http.Error(w, "Error searching collection: "+err.Error(), collectionErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Citation{"results": results})
+}
+
+// --- Tool Calling ---
+
+// Limits for server-side tool calls.
+const (
+	defaultMaxToolRounds = 5        // Model calls with tools per chat turn before the model must answer
+	maxToolResultLength  = 16 << 10 // Bytes of a tool result sent back to the model
+	toolCallTimeout      = 20 * time.Second
+)
+
+// ToolsConfig configures the built-in tools. Tools that need a resource are only
+// registered when it is configured.
+type ToolsConfig struct {
+	MaxRounds  int      `json:"maxRounds"`  // Defaults to 5
+	FetchHosts []string `json:"fetchHosts"` // Host patterns http_fetch may reach, e.g. "*.wikipedia.org"
+	FilesDir   string   `json:"filesDir"`   // Directory read_file may read from
+}
+
+// ToolFunction describes a tool to the model, with its parameters as a JSON Schema object.
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolDefinition is an entry of the "tools" field of an Ollama chat request.
+type ToolDefinition struct {
+	Type     string       `json:"type"` // Always "function"
+	Function ToolFunction `json:"function"`
+}
+
+// ToolCall is a tool invocation requested by the model in an assistant message.
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the tool to call and its arguments object.
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolInvocation records a tool call made during a chat turn. It is streamed as
+// "tool_call" and "tool_result" SSE events and stored with the reply.
+type ToolInvocation struct {
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Result     string          `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMs float64         `json:"durationMs,omitempty"`
+}
+
+// Tool is a function the model can call. Call receives the arguments object
+// chosen by the model and returns the text passed back to it.
+type Tool interface {
+	Function() ToolFunction
+	Call(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// ToolRegistry holds the tools available to chat requests in registration order.
+type ToolRegistry struct {
+	byName    map[string]Tool
+	order     []Tool
+	maxRounds int
+}
+
+// tools is the registry used by all handlers; it is set up in main.
+var tools *ToolRegistry
+
+// newToolRegistry registers the built-in tools enabled by cfg.
+func newToolRegistry(cfg ToolsConfig) (*ToolRegistry, error) {
+	registry := &ToolRegistry{byName: make(map[string]Tool), maxRounds: cfg.MaxRounds}
+	if registry.maxRounds <= 0 {
+		registry.maxRounds = defaultMaxToolRounds
+	}
+	registry.Register(calculatorTool{})
+	registry.Register(currentTimeTool{})
+	if len(cfg.FetchHosts) > 0 {
+		for _, pattern := range cfg.FetchHosts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid tools.fetchHosts pattern %q: %w", pattern, err)
+			}
+		}
+		registry.Register(newHTTPFetchTool(cfg.FetchHosts))
+	}
+	if cfg.FilesDir != "" {
+		dir, err := filepath.Abs(cfg.FilesDir)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("tools.filesDir %q is not a directory", cfg.FilesDir)
+		}
+		registry.Register(readFileTool{dir: dir})
+	}
+	names := make([]string, len(registry.order))
+	for i, tool := range registry.order {
+		names[i] = tool.Function().Name
+	}
+	log.Printf("Tools available to chat: %s", strings.Join(names, ", "))
+	return registry, nil
+}
+
+// Register adds a tool, replacing any tool with the same name.
+func (t *ToolRegistry) Register(tool Tool) {
+	name := tool.Function().Name
+	if _, exists := t.byName[name]; exists {
+		for i, existing := range t.order {
+			if existing.Function().Name == name {
+				t.order[i] = tool
+			}
+		}
+	} else {
+		t.order = append(t.order, tool)
+	}
+	t.byName[name] = tool
+}
+
+// List describes every registered tool.
+func (t *ToolRegistry) List() []ToolFunction {
+	list := make([]ToolFunction, len(t.order))
+	for i, tool := range t.order {
+		list[i] = tool.Function()
+	}
+	return list
+}
+
+// Definitions returns the chat request definitions of the named tools.
+func (t *ToolRegistry) Definitions(names []string) ([]ToolDefinition, error) {
+	defs := make([]ToolDefinition, 0, len(names))
+	for _, name := range names {
+		tool, ok := t.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		defs = append(defs, ToolDefinition{Type: "function", Function: tool.Function()})
+	}
+	return defs, nil
+}
+
+// Run executes a tool call. Errors are recorded in the invocation rather than
+// returned, since the model is told about them and may try again.
+func (t *ToolRegistry) Run(ctx context.Context, call ToolCall) ToolInvocation {
+	inv := ToolInvocation{Name: call.Function.Name, Arguments: call.Function.Arguments}
+	tool, ok := t.byName[call.Function.Name]
+	if !ok {
+		inv.Error = fmt.Sprintf("unknown tool %q", call.Function.Name)
+		toolCallsTotal.Add(1, otherModelLabel, "unknown") // The name comes from the model, so it is not used as a label
+		return inv
+	}
+	ctx, cancel := context.WithTimeout(ctx, toolCallTimeout)
+	defer cancel()
+	start := time.Now()
+	result, err := tool.Call(ctx, call.Function.Arguments)
+	inv.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		inv.Error = err.Error()
+		toolCallsTotal.Add(1, inv.Name, "error")
+		return inv
+	}
+	if len(result) > maxToolResultLength {
+		result = strings.ToValidUTF8(result[:maxToolResultLength], "") + "\n[truncated]"
+	}
+	inv.Result = result
+	toolCallsTotal.Add(1, inv.Name, "ok")
+	return inv
+}
+
+// modelContent is the tool message content sent back to the model.
+func (inv ToolInvocation) modelContent() string {
+	if inv.Error != "" {
+		return "Error: " + inv.Error
+	}
+	return inv.Result
+}
+
+// decodeToolArguments unmarshals tool arguments. Some models send the object as a JSON string.
+func decodeToolArguments(arguments json.RawMessage, v any) error {
+	var encoded string
+	if json.Unmarshal(arguments, &encoded) == nil {
+		arguments = json.RawMessage(encoded)
+	}
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(arguments, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// writeToolEvent sends a tool call or result as a named SSE event.
+func writeToolEvent(w io.Writer, event string, inv ToolInvocation) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		log.Printf("Error marshalling tool event: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+// writeStreamError reports a failure after an SSE stream has started and ends the stream.
+func writeStreamError(w io.Writer, flusher http.Flusher, message string) {
+	data, _ := json.Marshal(map[string]string{"error": message})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// handleListTools lists the tools chat requests may enable.
+func handleListTools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(w, r, PermUseModels, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]ToolFunction{"tools": tools.List()})
+}
+
+// --- Built-in Tools ---
+
+// calculatorTool evaluates arithmetic expressions.
+type calculatorTool struct{}
+
+func (calculatorTool) Function() ToolFunction {
+	return ToolFunction{
+		Name:        "calculator",
+		Description: "Evaluate an arithmetic expression. Supports + - * / % ^, parentheses, the constants pi and e and the functions sqrt, abs, ln, log10, exp, sin, cos, tan, floor, ceil and round.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"The expression to evaluate, e.g. (2 + 3) * 4"}},"required":["expression"]}`),
+	}
+}
+
+func (calculatorTool) Call(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := decodeToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	value, err := evaluateExpression(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64), nil
+}
+
+// expressionParser is a recursive descent parser for calculator expressions.
+type expressionParser struct {
+	input string
+	pos   int
+}
+
+// evaluateExpression parses and evaluates an arithmetic expression.
+func evaluateExpression(expr string) (float64, error) {
+	p := &expressionParser{input: expr}
+	value, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos+1)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return value, nil
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end.
+func (p *expressionParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *expressionParser) parseSum() (float64, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (p *expressionParser) parseProduct() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case op == '*':
+			left *= right
+		case right == 0:
+			return 0, errors.New("division by zero")
+		case op == '/':
+			left /= right
+		default:
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+func (p *expressionParser) parseUnary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// parsePower handles ^, which is right-associative and binds tighter than unary minus on its left.
+func (p *expressionParser) parsePower() (float64, error) {
+	base, err := p.parseAtom()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+// calculatorFunctions are the functions accepted by the calculator.
+var calculatorFunctions = map[string]func(float64) float64{
+	"sqrt": math.Sqrt, "abs": math.Abs, "ln": math.Log, "log10": math.Log10, "exp": math.Exp,
+	"sin": math.Sin, "cos": math.Cos, "tan": math.Tan, "floor": math.Floor, "ceil": math.Ceil, "round": math.Round,
+}
+
+func (p *expressionParser) parseAtom() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		value, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	case (c >= '0' && c <= '9') || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && ((p.input[p.pos] >= '0' && p.input[p.pos] <= '9') || p.input[p.pos] == '.' || p.input[p.pos] == '_') {
+			p.pos++
+		}
+		// Exponent notation such as 1.5e3
+		if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.input) && (p.input[end] == '+' || p.input[end] == '-') {
+				end++
+			}
+			if end < len(p.input) && p.input[end] >= '0' && p.input[end] <= '9' {
+				for end < len(p.input) && p.input[end] >= '0' && p.input[end] <= '9' {
+					end++
+				}
+				p.pos = end
+			}
+		}
+		return strconv.ParseFloat(strings.ReplaceAll(p.input[start:p.pos], "_", ""), 64)
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		start := p.pos
+		for p.pos < len(p.input) && ((p.input[p.pos] >= 'a' && p.input[p.pos] <= 'z') || (p.input[p.pos] >= 'A' && p.input[p.pos] <= 'Z') || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+			p.pos++
+		}
+		name := strings.ToLower(p.input[start:p.pos])
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+		fn, ok := calculatorFunctions[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown function or constant %q", name)
+		}
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("%s needs an argument in parentheses", name)
+		}
+		arg, err := p.parseAtom()
+		if err != nil {
+			return 0, err
+		}
+		return fn(arg), nil
+	case c == 0:
+		return 0, errors.New("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected character %q", c)
+	}
+}
+
+// currentTimeTool reports the current date and time.
+type currentTimeTool struct{}
+
+func (currentTimeTool) Function() ToolFunction {
+	return ToolFunction{
+		Name:        "current_time",
+		Description: "Get the current date and time, optionally in a given IANA time zone.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"IANA time zone such as Europe/Berlin; defaults to the server's local time"}}}`),
+	}
+}
+
+func (currentTimeTool) Call(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := decodeToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if args.Timezone != "" {
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+		}
+		now = now.In(loc)
+	}
+	return now.Format("Monday, 2 January 2006, 15:04:05 MST (2006-01-02T15:04:05Z07:00)"), nil
+}
+
+// httpFetchTool fetches web pages from allow-listed hosts.
+type httpFetchTool struct {
+	hosts  []string // Host patterns matched with path.Match
+	client *http.Client
+}
+
+func newHTTPFetchTool(hosts []string) *httpFetchTool {
+	t := &httpFetchTool{hosts: hosts}
+	t.client = &http.Client{
+		Timeout: toolCallTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return t.checkURL(req.URL)
+		},
+	}
+	return t
+}
+
+func (t *httpFetchTool) Function() ToolFunction {
+	return ToolFunction{
+		Name:        "http_fetch",
+		Description: "Fetch a web page or API response with an HTTP GET request. Only these hosts may be reached: " + strings.Join(t.hosts, ", "),
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"url":{"type":"string","description":"The http or https URL to fetch"}},"required":["url"]}`),
+	}
+}
+
+// checkURL rejects URLs that are not http(s) or whose host is not allow-listed.
+func (t *httpFetchTool) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be fetched")
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range t.hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not allowed", host)
+}
+
+func (t *httpFetchTool) Call(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if err := decodeToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(args.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	if err := t.checkURL(u); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Ollamana-Tools/1.0")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResultLength+1))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("HTTP %d %s\n\n%s", resp.StatusCode, resp.Header.Get("Content-Type"), bytes.ToValidUTF8(body, nil)), nil
+}
+
+// readFileTool reads files below a configured directory.
+type readFileTool struct {
+	dir string // Absolute path
+}
+
+func (t readFileTool) Function() ToolFunction {
+	return ToolFunction{
+		Name:        "read_file",
+		Description: "Read a text file, or list a directory, from the shared files directory. Paths are relative to that directory; use \".\" to list it.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"Relative path of the file or directory"}},"required":["path"]}`),
+	}
+}
+
+func (t readFileTool) Call(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := decodeToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(args.Path, "/")))
+	if !filepath.IsLocal(rel) && rel != "." {
+		return "", errors.New("path must stay inside the files directory")
+	}
+	// Symlinks are resolved so that they cannot point outside the directory.
+	full, err := filepath.EvalSymlinks(filepath.Join(t.dir, rel))
+	if err != nil {
+		return "", fmt.Errorf("%s: no such file or directory", args.Path)
+	}
+	root, err := filepath.EvalSymlinks(t.dir)
+	if err != nil {
+		return "", err
+	}
+	if within, err := filepath.Rel(root, full); err != nil || !filepath.IsLocal(within) && within != "." {
+		return "", errors.New("path must stay inside the files directory")
+	}
+
+	info, err := os.Stat(full)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(full)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, entry := range entries {
+			b.WriteString(entry.Name())
+			if entry.IsDir() {
+				b.WriteString("/")
+			}
+			b.WriteString("\n")
+		}
+		return b.String(), nil
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxToolResultLength+1))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) && !utf8.Valid(data[:max(0, len(data)-3)]) {
+		return "", errors.New("file is not a text file")
+	}
+	return string(data), nil
+}
+
+// --- Images ---
+
+// Defaults for images attached to generate and chat requests.
+const (
+	defaultMaxImageBytes = 10 << 20 // Per image, after base64 decoding
+	maxImagesPerRequest  = 10
+	maxImagePixels       = 50_000_000 // Larger images are rejected before decoding
+)
+
+// ImagesConfig limits the images sent to vision models.
+type ImagesConfig struct {
+	MaxBytes     int64 `json:"maxBytes"`     // Per image; defaults to 10 MB
+	MaxDimension int   `json:"maxDimension"` // Images with a longer side are downscaled to it; 0 keeps the original size
+}
+
+// imageLimits is the image configuration used by all handlers; it is set up in main.
+var imageLimits = ImagesConfig{MaxBytes: defaultMaxImageBytes}
+
+// allowedImageTypes are the image formats accepted, as detected from their content.
+var allowedImageTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+// ImageInfo describes a validated image as returned by /api/images.
+type ImageInfo struct {
+	Data       string `json:"data"` // Base64 without a data: URL prefix, as Ollama expects
+	Type       string `json:"type"`
+	Size       int    `json:"size"`
+	Width      int    `json:"width,omitempty"` // Unknown for WebP, which cannot be decoded here
+	Height     int    `json:"height,omitempty"`
+	Downscaled bool   `json:"downscaled,omitempty"`
+}
+
+// decodeImageData accepts plain base64 or a data: URL and returns the raw bytes.
+func decodeImageData(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if strings.HasPrefix(encoded, "data:") {
+		comma := strings.IndexByte(encoded, ',')
+		if comma < 0 || !strings.HasSuffix(encoded[:comma], ";base64") {
+			return nil, errors.New("data URLs must be base64 encoded")
+		}
+		encoded = encoded[comma+1:]
+	}
+	if int64(base64.StdEncoding.DecodedLen(len(encoded))) > imageLimits.MaxBytes+3 {
+		return nil, fmt.Errorf("image is larger than %d MB", imageLimits.MaxBytes>>20)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("image is not valid base64")
+	}
+	return data, nil
+}
+
+// prepareImage validates the type and size of an image and downscales it if it
+// exceeds the configured dimension.
+func prepareImage(data []byte) (ImageInfo, error) {
+	if int64(len(data)) > imageLimits.MaxBytes {
+		return ImageInfo{}, fmt.Errorf("image is larger than %d MB", imageLimits.MaxBytes>>20)
+	}
+	contentType := http.DetectContentType(data)
+	if !allowedImageTypes[contentType] {
+		return ImageInfo{}, fmt.Errorf("unsupported image type %s; use PNG, JPEG, GIF or WebP", contentType)
+	}
+	info := ImageInfo{Type: contentType, Size: len(data)}
+	if contentType == "image/webp" {
+		info.Data = base64.StdEncoding.EncodeToString(data)
+		return info, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("invalid %s image: %w", contentType, err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return ImageInfo{}, fmt.Errorf("image has %dx%d pixels, more than the %d megapixel limit", cfg.Width, cfg.Height, maxImagePixels/1_000_000)
+	}
+	info.Width, info.Height = cfg.Width, cfg.Height
+	if imageLimits.MaxDimension <= 0 || max(cfg.Width, cfg.Height) <= imageLimits.MaxDimension {
+		info.Data = base64.StdEncoding.EncodeToString(data)
+		return info, nil
 	}
 
-	var clientReq ClientRequest
-	if err := json.NewDecoder(r.Body).Decode(&clientReq); err != nil {
-		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
-		return
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("invalid %s image: %w", contentType, err)
+	}
+	scaled := downscaleImage(img, imageLimits.MaxDimension)
+	var buf bytes.Buffer
+	if contentType == "image/png" {
+		err = png.Encode(&buf, scaled) // Keeps transparency
+	} else {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 90})
 	}
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("encoding downscaled image: %w", err)
+	}
+	bounds := scaled.Bounds()
+	return ImageInfo{
+		Data: base64.StdEncoding.EncodeToString(buf.Bytes()), Type: contentType, Size: buf.Len(),
+		Width: bounds.Dx(), Height: bounds.Dy(), Downscaled: true,
+	}, nil
+}
 
-	client := &http.Client{Timeout: 300 * time.Second} // Long timeout for LLM operations
+// downscaleImage shrinks img so that its longer side is maxDim pixels, averaging
+// the source pixels that make up each destination pixel.
+func downscaleImage(img image.Image, maxDim int) *image.RGBA {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+	scale := float64(maxDim) / float64(max(w, h))
+	dw, dh := max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var r, g, b, a uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(src.Min.X+sx, src.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
 
-	switch clientReq.ActionType {
-	case "generate":
-		callGenerateAPI(w, r, clientReq, client)
-	case "chat":
-		callChatAPI(w, r, clientReq, client)
-	case "pull":
-		callModelPullAPI(w, r, clientReq, client)
-	case "delete":
-		callModelDeleteAPI(w, r, clientReq, client)
-	default:
-		http.Error(w, "Unknown action type: "+clientReq.ActionType, http.StatusBadRequest)
+// prepareImages validates and, if configured, downscales base64 images in place.
+func prepareImages(images []string) error {
+	for i, encoded := range images {
+		data, err := decodeImageData(encoded)
+		if err != nil {
+			return fmt.Errorf("image %d: %w", i+1, err)
+		}
+		info, err := prepareImage(data)
+		if err != nil {
+			return fmt.Errorf("image %d: %w", i+1, err)
+		}
+		images[i] = info.Data
 	}
+	return nil
 }
 
-// callGenerateAPI handles the /api/generate endpoint
-func callGenerateAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, client *http.Client) {
-	ollamaReq := OllamaGenerateRequestPayload{
-		Model:  clientReq.Model,
-		Prompt: clientReq.Prompt,
-		Stream: true,
+// prepareRequestImages checks the images of a generate request and of the messages of a chat request.
+func prepareRequestImages(clientReq *ClientRequest) error {
+	count := len(clientReq.Images)
+	for _, msg := range clientReq.Messages {
+		count += len(msg.Images)
 	}
-	payloadBytes, err := json.Marshal(ollamaReq)
-	if err != nil {
-		http.Error(w, "Error marshalling Ollama generate request: "+err.Error(), http.StatusInternalServerError)
-		return
+	if count > maxImagesPerRequest {
+		return fmt.Errorf("at most %d images are allowed per request, got %d", maxImagesPerRequest, count)
+	}
+	if err := prepareImages(clientReq.Images); err != nil {
+		return err
+	}
+	for _, msg := range clientReq.Messages {
+		if err := prepareImages(msg.Images); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	req, err := http.NewRequest(http.MethodPost, ollamaGenerateAPI, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		http.Error(w, "Error creating generate request to Ollama: "+err.Error(), http.StatusInternalServerError)
+// handleUploadImages validates the images of a multipart upload (field "image",
+// repeatable) and returns them base64 encoded, ready to attach to a request.
+func handleUploadImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error connecting to Ollama generate API: %v", err)
-		http.Error(w, "Could not connect to Ollama. Please ensure Ollama is running on "+ollamaBaseURL+". "+err.Error(), http.StatusBadGateway)
+	if !authorize(w, r, PermUseModels, "") {
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("Ollama generate API returned non-200 status: %d, body: %s", resp.StatusCode, string(bodyBytes))
-		http.Error(w, fmt.Sprintf("Ollama API error: Status %d, Message: %s", resp.StatusCode, strings.TrimSpace(string(bodyBytes))), resp.StatusCode)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImagesPerRequest*imageLimits.MaxBytes+1<<20)
+	if err := r.ParseMultipartForm(imageLimits.MaxBytes); err != nil {
+		http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Set headers for Server-Sent Events (SSE)
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		log.Println("Streaming not supported by this connection for generate API.")
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		http.Error(w, `Upload at least one image in the "image" field`, http.StatusBadRequest)
+		return
+	}
+	if len(files) > maxImagesPerRequest {
+		http.Error(w, fmt.Sprintf("At most %d images can be uploaded at once", maxImagesPerRequest), http.StatusBadRequest)
 		return
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
+	images := make([]ImageInfo, 0, len(files))
+	for _, header := range files {
+		if header.Size > imageLimits.MaxBytes {
+			http.Error(w, fmt.Sprintf("%s is larger than %d MB", header.Filename, imageLimits.MaxBytes>>20), http.StatusRequestEntityTooLarge)
+			return
 		}
-
-		var chunk OllamaResponseChunk
-		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-			log.Printf("Error unmarshalling Ollama generate response chunk: %v, line: %s", err, line)
-			continue
+		f, err := header.Open()
+		if err != nil {
+			http.Error(w, "Error reading upload: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-
-		if chunk.Response != "" {
-			fmt.Fprintf(w, "data: %s\n\n", line) // Send the full JSON chunk as data
-			flusher.Flush()
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, "Error reading upload: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-
-		if chunk.Done {
-			fmt.Fprintf(w, "data: [DONE]\n\n")
-			flusher.Flush()
-			break
+		info, err := prepareImage(data)
+		if err != nil {
+			http.Error(w, header.Filename+": "+err.Error(), http.StatusBadRequest)
+			return
 		}
+		images = append(images, info)
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]ImageInfo{"images": images})
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading Ollama generate response stream: %v", err)
-	}
+// --- Structured Output ---
+
+// Retry limits for the structured action.
+const (
+	defaultStructuredRetries = 2
+	maxStructuredRetries     = 5
+)
+
+// SchemaError is a place where a value does not match its JSON Schema.
+type SchemaError struct {
+	Path    string `json:"path"` // JSON Pointer to the value; empty for the whole document
+	Message string `json:"message"`
 }
 
-// callChatAPI handles the /api/chat endpoint
-func callChatAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, client *http.Client) {
-	ollamaReq := OllamaChatRequestPayload{
-		Model:    clientReq.Model,
-		Messages: clientReq.Messages,
-		Stream:   true,
-	}
-	payloadBytes, err := json.Marshal(ollamaReq)
+// StructuredAttempt is one answer of the model and why it was rejected.
+type StructuredAttempt struct {
+	Attempt    int           `json:"attempt"`
+	Response   string        `json:"response"`
+	ParseError string        `json:"parseError,omitempty"`
+	Errors     []SchemaError `json:"errors,omitempty"`
+}
+
+// StructuredResponse is the result of the structured action. It is returned
+// with status 200 when Valid and 422 when every attempt failed validation.
+type StructuredResponse struct {
+	Model       string              `json:"model"`
+	Valid       bool                `json:"valid"`
+	Object      json.RawMessage     `json:"object"` // The last answer; null if it was not JSON
+	Attempts    int                 `json:"attempts"`
+	Diagnostics []StructuredAttempt `json:"diagnostics,omitempty"` // Every rejected answer
+	Usage       *GenerationUsage    `json:"usage,omitempty"`       // Token counts and durations summed over the attempts
+}
+
+// callStructuredAPI asks the model for JSON matching clientReq.Schema. Answers
+// that do not validate are sent back with the validation errors, up to
+// clientReq.MaxRetries times.
+func callStructuredAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, backend *Backend, client *http.Client) {
+	schema, err := decodeJSONSchema(clientReq.Schema)
 	if err != nil {
-		http.Error(w, "Error marshalling Ollama chat request: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid schema: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	req, err := http.NewRequest(http.MethodPost, ollamaChatAPI, bytes.NewBuffer(payloadBytes))
+	retries := defaultStructuredRetries
+	if clientReq.MaxRetries != nil {
+		retries = *clientReq.MaxRetries
+	}
+	if retries < 0 || retries > maxStructuredRetries {
+		http.Error(w, fmt.Sprintf("maxRetries must be between 0 and %d", maxStructuredRetries), http.StatusBadRequest)
+		return
+	}
+	if clientReq.Prompt != "" {
+		clientReq.Messages = append(clientReq.Messages, Message{Role: "user", Content: clientReq.Prompt, Images: clientReq.Images})
+	}
+	if len(clientReq.Messages) == 0 {
+		http.Error(w, "A prompt or messages are required", http.StatusBadRequest)
+		return
+	}
+	clientReq.Format = clientReq.Schema
+	ollamaReq, err := buildChatPayload(clientReq)
 	if err != nil {
-		http.Error(w, "Error creating chat request to Ollama: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid generation parameters: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
+	ollamaReq.Stream = false
+
+	ctx, generationID, finish := generations.Start(r.Context(), identityFrom(r.Context()).Username)
+	defer finish()
+	w.Header().Set("X-Generation-Id", generationID)
+
+	result := StructuredResponse{Model: clientReq.Model}
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		answer, usage, err := chatOnce(ctx, backend, client, ollamaReq, "structured")
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Structured generation %s cancelled: %v", generationID, context.Cause(ctx))
+				http.Error(w, "Generation cancelled", http.StatusRequestTimeout)
+				return
+			}
+			writeUpstreamError(w, backend, "chat", err)
+			return
+		}
+		result.Attempts = attempt
+		result.Usage = addUsage(result.Usage, usage)
+		usageLog.Record(identityFrom(r.Context()).Username, clientReq.Model, "", *usage)
+
+		check := StructuredAttempt{Attempt: attempt, Response: answer}
+		value, object, err := parseJSONAnswer(answer)
+		if err != nil {
+			check.ParseError = err.Error()
+			result.Object = nil
+		} else {
+			result.Object = object
+			check.Errors = validateJSONSchema(schema, value)
+		}
+		if check.ParseError == "" && len(check.Errors) == 0 {
+			result.Valid = true
+			break
+		}
+		result.Diagnostics = append(result.Diagnostics, check)
+		log.Printf("Structured generation %s: attempt %d did not match the schema", generationID, attempt)
 
-	resp, err := client.Do(req)
+		// Show the model its answer and what was wrong with it.
+		ollamaReq.Messages = append(ollamaReq.Messages,
+			Message{Role: "assistant", Content: answer},
+			Message{Role: "user", Content: schemaFeedback(check)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Valid {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// chatOnce sends a non-streaming chat request and returns the answer without any <think> reasoning.
+func chatOnce(ctx context.Context, backend *Backend, client *http.Client, payload OllamaChatRequestPayload, action string) (string, *GenerationUsage, error) {
+	stats := trackStream(action, payload.Model)
+	defer stats.Done()
+	resp, err := startOllamaStream(ctx, backend, client, ollamaChatPath, payload)
 	if err != nil {
-		log.Printf("Error connecting to Ollama chat API: %v", err)
-		http.Error(w, "Could not connect to Ollama. Please ensure Ollama is running on "+ollamaBaseURL+". "+err.Error(), http.StatusBadGateway)
-		return
+		return "", nil, err
 	}
 	defer resp.Body.Close()
+	var chunk OllamaResponseChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return "", nil, fmt.Errorf("decoding Ollama chat response: %w", err)
+	}
+	chunk.Done = true // A non-streaming response is its own final chunk
+	usage := stats.Chunk(chunk)
+	if chunk.Message == nil {
+		return "", usage, nil
+	}
+	var tags thinkTagSplitter
+	_, answer := tags.Split(chunk.Message.Content)
+	_, rest := tags.Flush()
+	return answer + rest, usage, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("Ollama chat API returned non-200 status: %d, body: %s", resp.StatusCode, string(bodyBytes))
-		http.Error(w, fmt.Sprintf("Ollama API error: Status %d, Message: %s", resp.StatusCode, strings.TrimSpace(string(bodyBytes))), resp.StatusCode)
+// addUsage sums the token counts and durations of two generations.
+func addUsage(total, usage *GenerationUsage) *GenerationUsage {
+	if total == nil {
+		u := *usage
+		return &u
+	}
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalDurationMs += usage.TotalDurationMs
+	total.LoadDurationMs += usage.LoadDurationMs
+	total.PromptEvalDurationMs += usage.PromptEvalDurationMs
+	total.EvalDurationMs += usage.EvalDurationMs
+	if total.EvalDurationMs > 0 {
+		total.TokensPerSecond = float64(total.CompletionTokens) / (total.EvalDurationMs / 1000)
+	}
+	return total
+}
+
+// parseJSONAnswer parses a model answer, tolerating a surrounding Markdown code
+// fence. It returns the value for validation and the compact JSON text.
+func parseJSONAnswer(answer string) (any, json.RawMessage, error) {
+	text := strings.TrimSpace(answer)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text[strings.IndexByte(text+"\n", '\n'):], "\n")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, nil, fmt.Errorf("the answer is not valid JSON: %w", err)
+	}
+	if dec.More() {
+		return nil, nil, errors.New("the answer contains more than one JSON value")
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(text)); err != nil {
+		return nil, nil, err
+	}
+	return value, compact.Bytes(), nil
+}
+
+// schemaFeedback is the message that asks the model to correct a rejected answer.
+func schemaFeedback(check StructuredAttempt) string {
+	var b strings.Builder
+	if check.ParseError != "" {
+		fmt.Fprintf(&b, "Your answer could not be parsed: %s.\n", check.ParseError)
+	} else {
+		b.WriteString("Your answer does not match the JSON Schema:\n")
+		for _, e := range check.Errors {
+			fmt.Fprintf(&b, "- %s: %s\n", cmpPath(e.Path), e.Message)
+		}
+	}
+	b.WriteString("Reply with only the corrected JSON.")
+	return b.String()
+}
+
+// cmpPath names the document root in messages.
+func cmpPath(p string) string {
+	if p == "" {
+		return "(root)"
+	}
+	return p
+}
+
+// handleStructured serves POST /api/structured, the structured action as its own endpoint.
+func handleStructured(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Set headers for Server-Sent Events (SSE)
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	var clientReq ClientRequest
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { recordRequest("structured", clientReq.Model, rec.status) }()
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		log.Println("Streaming not supported by this connection for chat API.")
+	if err := json.NewDecoder(r.Body).Decode(&clientReq); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	clientReq.ActionType = "structured"
+	runAction(w, r, clientReq)
+}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+// --- JSON Schema Validation ---
 
-		var chunk OllamaResponseChunk
-		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-			log.Printf("Error unmarshalling Ollama chat response chunk: %v, line: %s", err, line)
-			continue
-		}
+// maxSchemaSteps bounds the subschema checks of one validation, since anyOf,
+// oneOf and $ref can make their number grow exponentially with the schema size.
+const maxSchemaSteps = 100000
 
-		// For chat, we stream the 'message' content
-		if chunk.Message != nil && chunk.Message.Content != "" {
-			fmt.Fprintf(w, "data: %s\n\n", line) // Send the full JSON chunk as data
-			flusher.Flush()
-		}
+// jsonSchema is a decoded schema document; $ref pointers are resolved against root.
+type jsonSchema struct {
+	root     any
+	steps    int                       // Subschema checks left in the current validation
+	patterns map[string]*regexp.Regexp // Compiled "pattern" keywords; nil for invalid ones
+}
 
-		if chunk.Done {
-			fmt.Fprintf(w, "data: [DONE]\n\n")
-			flusher.Flush()
-			break
-		}
+// decodeJSONSchema parses a schema. The document must be an object, since it is
+// also sent as Ollama's format; nested schemas may be booleans.
+func decodeJSONSchema(raw json.RawMessage) (*jsonSchema, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, errors.New("schema is required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var root any
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
 	}
+	if _, ok := root.(map[string]any); !ok {
+		return nil, errors.New("schema must be a JSON object")
+	}
+	return &jsonSchema{root: root, patterns: make(map[string]*regexp.Regexp)}, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading Ollama chat response stream: %v", err)
+// validateJSONSchema checks value against the schema. It supports the keywords
+// models are usually given: type, enum, const, properties, required,
+// additionalProperties, items, prefixItems, min/maxItems, uniqueItems,
+// min/maxLength, pattern, the numeric bounds, multipleOf, allOf, anyOf, oneOf,
+// not and local $ref. Other keywords are ignored.
+func validateJSONSchema(schema *jsonSchema, value any) []SchemaError {
+	var errs []SchemaError
+	schema.steps = maxSchemaSteps
+	schema.validate(schema.root, value, "", &errs, 0)
+	if schema.steps < 0 {
+		return []SchemaError{{Message: fmt.Sprintf("validation gave up after %d subschema checks; the schema is too complex", maxSchemaSteps)}}
 	}
+	return errs
 }
 
-// callModelPullAPI handles the /api/pull endpoint
-func callModelPullAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, client *http.Client) {
-	ollamaReq := OllamaModelActionPayload{
-		Model: clientReq.Model,
+func (s *jsonSchema) validate(node, value any, ptr string, errs *[]SchemaError, depth int) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, SchemaError{Path: ptr, Message: fmt.Sprintf(format, args...)})
 	}
-	payloadBytes, err := json.Marshal(ollamaReq)
-	if err != nil {
-		http.Error(w, "Error marshalling Ollama pull request: "+err.Error(), http.StatusInternalServerError)
+	if depth > 64 {
+		fail("schema is nested too deeply or has a $ref cycle")
 		return
 	}
-
-	req, err := http.NewRequest(http.MethodPost, ollamaPullAPI, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		http.Error(w, "Error creating pull request to Ollama: "+err.Error(), http.StatusInternalServerError)
+	if s.steps--; s.steps < 0 {
+		fail("validation step budget exhausted")
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error connecting to Ollama pull API: %v", err)
-		http.Error(w, "Could not connect to Ollama. Please ensure Ollama is running on "+ollamaBaseURL+". "+err.Error(), http.StatusBadGateway)
+	switch n := node.(type) {
+	case bool:
+		if !n {
+			fail("no value is allowed here")
+		}
 		return
+	case map[string]any:
+		s.validateObjectSchema(n, value, ptr, errs, depth, fail)
 	}
-	defer resp.Body.Close()
+}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Error reading Ollama pull response: "+err.Error(), http.StatusInternalServerError)
-		return
+func (s *jsonSchema) validateObjectSchema(n map[string]any, value any, ptr string, errs *[]SchemaError, depth int, fail func(string, ...any)) {
+	if ref, ok := n["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		s.validate(target, value, ptr, errs, depth+1)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Ollama pull API returned non-200 status: %d, body: %s", resp.StatusCode, string(bodyBytes))
-		http.Error(w, fmt.Sprintf("Ollama API error pulling model: Status %d, Message: %s", resp.StatusCode, strings.TrimSpace(string(bodyBytes))), resp.StatusCode)
-		return
+	if t, ok := n["type"]; ok && !matchesSchemaType(t, value) {
+		fail("must be of type %s, got %s", describeSchemaType(t), jsonTypeName(value))
+		return // Other keywords would only repeat the problem
 	}
-
-	w.Header().Set("Content-Type", "text/plain") // Pull often returns plain text status
-	w.Write(bodyBytes)
-}
-
-// callModelDeleteAPI handles the /api/delete endpoint
-func callModelDeleteAPI(w http.ResponseWriter, r *http.Request, clientReq ClientRequest, client *http.Client) {
-	ollamaReq := OllamaModelActionPayload{
-		Model: clientReq.Model,
+	if enum, ok := n["enum"].([]any); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compactJSON(enum))
+		}
 	}
-	payloadBytes, err := json.Marshal(ollamaReq)
-	if err != nil {
-		http.Error(w, "Error marshalling Ollama delete request: "+err.Error(), http.StatusInternalServerError)
-		return
+	if c, ok := n["const"]; ok && !jsonEqual(c, value) {
+		fail("must be %s", compactJSON(c))
 	}
 
-	// DELETE request for Ollama's /api/delete
-	req, err := http.NewRequest(http.MethodDelete, ollamaDeleteAPI, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		http.Error(w, "Error creating delete request to Ollama: "+err.Error(), http.StatusInternalServerError)
-		return
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := n["properties"].(map[string]any)
+		if required, ok := n["required"].([]any); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPtr := ptr + "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+			if prop, ok := props[key]; ok {
+				s.validate(prop, v[key], childPtr, errs, depth+1)
+				continue
+			}
+			if additional, ok := n["additionalProperties"]; ok {
+				if allowed, isBool := additional.(bool); isBool && !allowed {
+					fail("property %q is not allowed", key)
+				} else if !isBool {
+					s.validate(additional, v[key], childPtr, errs, depth+1)
+				}
+			}
+		}
+	case []any:
+		if min, ok := schemaNumber(n["minItems"]); ok && float64(len(v)) < min {
+			fail("must have at least %v items, got %d", min, len(v))
+		}
+		if max, ok := schemaNumber(n["maxItems"]); ok && float64(len(v)) > max {
+			fail("must have at most %v items, got %d", max, len(v))
+		}
+		prefix, _ := n["prefixItems"].([]any)
+		for i, item := range v {
+			childPtr := ptr + "/" + strconv.Itoa(i)
+			if i < len(prefix) {
+				s.validate(prefix[i], item, childPtr, errs, depth+1)
+			} else if items, ok := n["items"]; ok {
+				s.validate(items, item, childPtr, errs, depth+1)
+			}
+		}
+		if unique, _ := n["uniqueItems"].(bool); unique {
+			for i := range v {
+				for j := i + 1; j < len(v); j++ {
+					if jsonEqual(v[i], v[j]) {
+						fail("items %d and %d are equal but must be unique", i, j)
+					}
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if min, ok := schemaNumber(n["minLength"]); ok && length < min {
+			fail("must be at least %v characters long", min)
+		}
+		if max, ok := schemaNumber(n["maxLength"]); ok && length > max {
+			fail("must be at most %v characters long", max)
+		}
+		if pattern, ok := n["pattern"].(string); ok {
+			re, cached := s.patterns[pattern]
+			if !cached {
+				re, _ = regexp.Compile(pattern)
+				s.patterns[pattern] = re
+			}
+			if re == nil {
+				fail("schema pattern %q is not a valid regular expression", pattern)
+			} else if !re.MatchString(v) {
+				fail("must match the pattern %s", pattern)
+			}
+		}
+	case json.Number:
+		x, _ := v.Float64()
+		if min, ok := schemaNumber(n["minimum"]); ok && x < min {
+			fail("must be >= %v", min)
+		}
+		if max, ok := schemaNumber(n["maximum"]); ok && x > max {
+			fail("must be <= %v", max)
+		}
+		if min, ok := schemaNumber(n["exclusiveMinimum"]); ok && x <= min {
+			fail("must be > %v", min)
+		}
+		if max, ok := schemaNumber(n["exclusiveMaximum"]); ok && x >= max {
+			fail("must be < %v", max)
+		}
+		if m, ok := schemaNumber(n["multipleOf"]); ok && m > 0 {
+			if q := x / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("must be a multiple of %v", m)
+			}
+		}
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error connecting to Ollama delete API: %v", err)
-		http.Error(w, "Could not connect to Ollama. Please ensure Ollama is running on "+ollamaBaseURL+". "+err.Error(), http.StatusBadGateway)
-		return
+	if all, ok := n["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(sub, value, ptr, errs, depth+1)
+		}
 	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Error reading Ollama delete response: "+err.Error(), http.StatusInternalServerError)
-		return
+	if anyOf, ok := n["anyOf"].([]any); ok && s.countMatches(anyOf, value, ptr, depth) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if oneOf, ok := n["oneOf"].([]any); ok {
+		if matches := s.countMatches(oneOf, value, ptr, depth); matches != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", matches)
+		}
 	}
+	if not, ok := n["not"]; ok && s.countMatches([]any{not}, value, ptr, depth) == 1 {
+		fail("must not match the \"not\" schema")
+	}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Ollama delete API returned non-200 status: %d, body: %s", resp.StatusCode, string(bodyBytes))
-		http.Error(w, fmt.Sprintf("Ollama API error deleting model: Status %d, Message: %s", resp.StatusCode, strings.TrimSpace(string(bodyBytes))), resp.StatusCode)
-		return
+// countMatches returns how many of the schemas value satisfies.
+func (s *jsonSchema) countMatches(schemas []any, value any, ptr string, depth int) int {
+	matches := 0
+	for _, sub := range schemas {
+		var subErrs []SchemaError
+		s.validate(sub, value, ptr, &subErrs, depth+1)
+		if len(subErrs) == 0 {
+			matches++
+		}
 	}
+	return matches
+}
 
-	w.Header().Set("Content-Type", "text/plain") // Delete often returns plain text success
-	w.Write(bodyBytes)
+// resolve follows a local $ref such as "#/$defs/Address".
+func (s *jsonSchema) resolve(ref string) (any, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local $ref pointers are supported, got %q", ref)
+	}
+	node := s.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+		if node, ok = obj[part]; !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+	}
+	return node, nil
 }
 
-// handleListModels fetches the list of available Ollama models from the /api/tags endpoint.
-func handleListModels(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// matchesSchemaType checks the "type" keyword, a type name or a list of them.
+func matchesSchemaType(t, value any) bool {
+	names, ok := t.([]any)
+	if !ok {
+		names = []any{t}
+	}
+	actual := jsonTypeName(value)
+	for _, name := range names {
+		switch name {
+		case actual:
+			return true
+		case "number":
+			if actual == "integer" {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	client := &http.Client{Timeout: 10 * time.Second} // Shorter timeout for listing models
-	resp, err := client.Get(ollamaTagsAPI)
-	if err != nil {
-		log.Printf("Error connecting to Ollama tags API: %v", err)
-		http.Error(w, "Could not connect to Ollama to list models. Please ensure Ollama is running on "+ollamaTagsAPI+".", http.StatusBadGateway)
-		return
+func describeSchemaType(t any) string {
+	if names, ok := t.([]any); ok {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = fmt.Sprint(name)
+		}
+		return strings.Join(parts, " or ")
 	}
-	defer resp.Body.Close()
+	return fmt.Sprint(t)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("Ollama tags API returned non-200 status: %d, body: %s", resp.StatusCode, string(bodyBytes))
-		http.Error(w, fmt.Sprintf("Ollama API error fetching models: Status %d, Message: %s", resp.StatusCode, strings.TrimSpace(string(bodyBytes))), resp.StatusCode)
-		return
+// jsonTypeName returns the JSON Schema type of a value decoded with UseNumber.
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case json.Number:
+		// 1.0 counts as an integer in JSON Schema
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", value)
 	}
+}
 
-	var tagsResponse OllamaTagsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tagsResponse); err != nil {
-		log.Printf("Error unmarshalling Ollama tags response: %v", err)
-		http.Error(w, "Error parsing Ollama models response.", http.StatusInternalServerError)
-		return
+// schemaNumber reads a numeric keyword value.
+func schemaNumber(v any) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
 	}
+	f, err := n.Float64()
+	return f, err == nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tagsResponse)
+// jsonEqual compares two decoded JSON values, treating numbers by value.
+func jsonEqual(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, xv := range x {
+			yv, present := y[key]
+			if !present || !jsonEqual(xv, yv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
 }
 
+// compactJSON renders a value for an error message.
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestMatchModel(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		model    string
+		want     bool
+	}{
+		{[]string{"llama3*"}, "llama3", true},
+		{[]string{"llama3*"}, "llama3:8b", true},
+		{[]string{"llama3*"}, "llama2:7b", false},
+		{[]string{"llama3"}, "llama3:latest", true},
+		{[]string{"llama3"}, "llama3:8b", false},
+		{[]string{"llama3:latest"}, "llama3", true},
+		{[]string{"*:70b"}, "llama3:70b", true},
+		{[]string{"*:70b"}, "llama3:8b", false},
+		{[]string{"*:70b"}, "llama3", false},
+		{[]string{"mistral*", "*:70b"}, "llama3:70b", true},
+		{[]string{"llama3*"}, "team/llama3:8b", false},
+		{[]string{"team/*"}, "team/llama3:8b", true},
+		{[]string{"registry.internal:5000/*/*"}, "registry.internal:5000/team/llama3", true},
+		{[]string{"llama?"}, "llama3", true},
+		{nil, "llama3", false},
+	}
+	for _, tt := range tests {
+		if got := matchModel(tt.patterns, tt.model); got != tt.want {
+			t.Errorf("matchModel(%q, %q) = %t, want %t", tt.patterns, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestRolePolicyValidate(t *testing.T) {
+	if err := (RolePolicy{AllowModels: []string{"llama3*", "*:70b"}, DenyModels: []string{"phi?"}}).validate(); err != nil {
+		t.Errorf("validate rejected valid patterns: %v", err)
+	}
+	if err := (RolePolicy{DenyModels: []string{"llama[3"}}).validate(); err == nil {
+		t.Error("validate accepted a malformed pattern")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	store := &AuthStore{
+		enabled: true,
+		policies: map[string]RolePolicy{
+			RoleUser:       {AllowModels: []string{"llama3*", "mistral*"}, DenyModels: []string{"*:70b"}},
+			RoleModelAdmin: {DenyModels: []string{"secret-*"}},
+		},
+	}
+	tests := []struct {
+		role  string
+		perm  Permission
+		model string
+		want  bool
+	}{
+		{RoleViewer, PermUseModels, "llama3", false},
+		{RoleUser, PermUseModels, "", true},
+		{RoleUser, PermUseModels, "llama3:8b", true},
+		{RoleUser, PermUseModels, "mistral", true},
+		{RoleUser, PermUseModels, "phi3", false},       // Not allowed
+		{RoleUser, PermUseModels, "llama3:70b", false}, // Denied, though allowed
+		{RoleUser, PermPullModels, "llama3:8b", false}, // Missing permission
+		{RoleModelAdmin, PermPullModels, "phi3", true}, // No allow list
+		{RoleModelAdmin, PermDeleteModels, "secret-model", false},
+		{RoleModelAdmin, PermManageUsers, "", false},
+		{RoleAdmin, PermManageUsers, "", true},
+		{RoleAdmin, PermDeleteModels, "llama3:70b", true}, // Policies are per role
+		{"unknown", PermUseModels, "", false},
+	}
+	for _, tt := range tests {
+		permErr := store.Authorize(&Identity{Username: "test", Role: tt.role}, tt.perm, tt.model)
+		if got := permErr == nil; got != tt.want {
+			t.Errorf("Authorize(%s, %s, %q) = %v, want allowed %t", tt.role, tt.perm, tt.model, permErr, tt.want)
+			continue
+		}
+		if permErr != nil && (permErr.Role != tt.role || permErr.Permission != tt.perm || permErr.Model != tt.model) {
+			t.Errorf("Authorize(%s, %s, %q) error = %+v, want it to name the role, permission and model", tt.role, tt.perm, tt.model, permErr)
+		}
+	}
+}
+
+func TestAuthorizeAnonymous(t *testing.T) {
+	store := &AuthStore{}
+	anonymous := &Identity{Role: RoleUser, Method: "anonymous"}
+	if permErr := store.Authorize(anonymous, PermUseModels, "llama3"); permErr != nil {
+		t.Errorf("Authorize refused chat to the anonymous user role: %v", permErr)
+	}
+	if permErr := store.Authorize(anonymous, PermDeleteModels, "llama3"); permErr == nil {
+		t.Error("Authorize let the anonymous user role delete models with authentication disabled")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2", 3},
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"10 - 4 - 3", 3},
+		{"12 / 4 / 3", 1},
+		{"7 % 4", 3},
+		{"-7 % 4", -3},
+		{"2 ^ 10", 1024},
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"2 ^ -1", 0.5},
+		{"--3", 3},
+		{"+3", 3},
+		{"1.5e3 + 1_000", 2500},
+		{"2E-2", 0.02},
+		{".5 * 4", 2},
+		{"sqrt(16) + abs(-2)", 6},
+		{"SQRT(9)", 3},
+		{"round(2.5) + floor(1.9) + ceil(1.1)", 6},
+		{"ln(e)", 1},
+		{"log10(1000)", 3},
+		{"exp(0)", 1},
+		{"cos(pi)", -1},
+		{"sqrt((3^2) + 4^2)", 5},
+		{"\t 1+1 ", 2},
+	}
+	for _, tt := range tests {
+		got, err := evaluateExpression(tt.expr)
+		if err != nil {
+			t.Errorf("evaluateExpression(%q): %v", tt.expr, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("evaluateExpression(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"1 / 0", "division by zero"},
+		{"5 % 0", "division by zero"},
+		{"(1 + 2", "missing closing parenthesis"},
+		{"1 + 2)", `unexpected ")"`},
+		{"2 3", `unexpected "3"`},
+		{"foo(1)", `unknown function or constant "foo"`},
+		{"sqrt 4", "sqrt needs an argument in parentheses"},
+		{"1 # 2", `unexpected "# 2"`},
+		{"$", `unexpected character '$'`},
+		{"1.2.3", "invalid syntax"},
+		{"sqrt(-1)", "not a finite number"},
+		{"10 ^ 400", "not a finite number"},
+		{"ln(0)", "not a finite number"},
+	}
+	for _, tt := range tests {
+		got, err := evaluateExpression(tt.expr)
+		if err == nil {
+			t.Errorf("evaluateExpression(%q) = %v, want an error containing %q", tt.expr, got, tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("evaluateExpression(%q) error = %q, want it to contain %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCalculatorToolCall(t *testing.T) {
+	result, err := calculatorTool{}.Call(context.Background(), json.RawMessage(`{"expression": "0.1 + 0.2 * 10"}`))
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if result != "2.1" {
+		t.Errorf("Call = %q, want %q", result, "2.1")
+	}
+	if _, err := (calculatorTool{}).Call(context.Background(), json.RawMessage(`{"expression": "1 / 0"}`)); err == nil {
+		t.Error("Call succeeded for a division by zero")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUsageLogFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	l, err := openUsageLog(path)
+	if err != nil {
+		t.Fatalf("openUsageLog: %v", err)
+	}
+	l.Record("alice", "llama3", "conv1", GenerationUsage{PromptTokens: 3, CompletionTokens: 5})
+	l.Record("alice", "llama3", "conv2", GenerationUsage{PromptTokens: 1, CompletionTokens: 2})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Record wrote the usage file before a flush: %v", err)
+	}
+
+	l.Forget("conv1")
+	l.Flush()
+	reopened, err := openUsageLog(path)
+	if err != nil {
+		t.Fatalf("openUsageLog: %v", err)
+	}
+	report := reopened.Report("alice", false)
+	if len(report.Models) != 1 || report.Models[0].Requests != 2 || report.Models[0].CompletionTokens != 7 {
+		t.Errorf("model usage after reopening = %+v, want both generations", report.Models)
+	}
+	if len(report.Conversations) != 1 || report.Conversations[0].ConversationID != "conv2" {
+		t.Errorf("conversation usage after reopening = %+v, want only conv2", report.Conversations)
+	}
+
+	// Without changes a flush leaves the file alone.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Flush()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Flush without changes wrote the usage file: %v", err)
+	}
+}